@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"film-crop-detector/filmcrop"
+)
+
+// errNoStoredCrop means no record matched a target file
+var errNoStoredCrop = errors.New("no stored crop")
+
+// errNoStoredFrame means a record holds only the crop box, which --deskew
+// cannot straighten
+var errNoStoredFrame = errors.New("no stored frame to deskew")
+
+// errAmbiguousCrop means records of several source images matched a target
+// file equally well
+var errAmbiguousCrop = errors.New("ambiguous stored crop")
+
+// storedCrop is a crop read back from a record file. Only json and ndjson
+// records carry the frame; rect is nil for crop data and XMP sidecars.
+type storedCrop struct {
+	frame    int
+	crop     filmcrop.Crop
+	rotation float64
+	// rect is the corrected frame in pixels of a width x height image, and
+	// format the film format it was corrected to
+	rect          *filmcrop.RotatedRect
+	format        string
+	width, height int
+}
+
+// result rebuilds the detection result of c for a w x h image. The crop box
+// carries over as a fraction of the image; the frame is scaled to match.
+func (c storedCrop) result(w, h int) filmcrop.Result {
+	res := filmcrop.ResultFromCrop(c.crop, c.rotation, w, h)
+	if c.rect == nil || c.width <= 0 || c.height <= 0 {
+		return res
+	}
+
+	sx := float32(w) / float32(c.width)
+	sy := float32(h) / float32(c.height)
+	rect := &filmcrop.RotatedRect{
+		Center: filmcrop.Point2f{X: c.rect.Center.X * sx, Y: c.rect.Center.Y * sy},
+		Size:   filmcrop.Point2f{X: c.rect.Size.X * sx, Y: c.rect.Size.Y * sy},
+		Angle:  c.rect.Angle,
+	}
+	res.RawRect, res.InsetRect, res.Rect = rect, rect, rect
+	res.Format = c.format
+	return res
+}
+
+// cropStore holds the stored crops of each source image, frames in strip
+// order. Images are keyed by the path they were recorded under, with forward
+// slashes: the path below the input folder for json and ndjson records that
+// carry it, the file as named otherwise, and the image name for XMP
+// sidecars and crop data.
+type cropStore map[string][]storedCrop
+
+// lookup returns the crops recorded for name, the path of a target below its
+// input folder. It tries the recorded path itself, then the path without
+// extension, then the base name without extension, and fails when a step
+// matches several source images.
+func (s cropStore) lookup(name string) ([]storedCrop, error) {
+	name = filepath.ToSlash(name)
+	if crops, ok := s[name]; ok {
+		return crops, nil
+	}
+	stem := func(p string) string { return strings.TrimSuffix(p, path.Ext(p)) }
+	for _, key := range []func(string) string{stem, func(p string) string { return stem(path.Base(p)) }} {
+		var matches []string
+		for recorded := range s {
+			if key(recorded) == key(name) {
+				matches = append(matches, recorded)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			return s[matches[0]], nil
+		}
+		sort.Strings(matches)
+		return nil, fmt.Errorf("%w: %s matches %s", errAmbiguousCrop, name, strings.Join(matches, ", "))
+	}
+	return nil, fmt.Errorf("%w: %s", errNoStoredCrop, name)
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runApply implements the apply command: it crops target files using crops
+// recorded by an earlier run instead of detecting again, and returns the
+// exit code
+func runApply(args []string) int {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+
+	var cfg runConfig
+	var records stringList
+	var mapFile string
+	var interpolation string
+	var enforceAspect bool
+	var walk walkConfig
+
+	fs.Var(&records, "records", "Record file (.json, .ndjson, .txt or .xmp) or folder of them (repeatable)")
+	fs.StringVar(&mapFile, "map", "", "Tab-separated file of \"source name<TAB>target path\" lines overriding name matching")
+	fs.BoolVar(&verbose, "verbose", false, "Print debug information")
+	fs.BoolVar(&cfg.dryRun, "dry-run", false, "Do not write cropped output image")
+	fs.StringVar(&cfg.outputDir, "output-dir", "", "Output directory for processed images, mirroring the layout of input folders")
+	fs.BoolVar(&cfg.overwrite, "overwrite", false, "Overwrite target images")
+	fs.BoolVar(&cfg.xmp, "xmp", false, "Write the crop to a Lightroom XMP sidecar instead of cropping pixels")
+	fs.BoolVar(&cfg.metadata, "metadata", true, "Copy EXIF, ICC profile and XMP metadata into cropped output")
+	fs.BoolVar(&cfg.deskew, "deskew", false, "Rotate the frame level before cropping (needs json or ndjson records)")
+	fs.BoolVar(&enforceAspect, "enforce-aspect", false, "Enforce the exact aspect ratio of the recorded format with --deskew")
+	fs.StringVar(&interpolation, "interpolation", "cubic", "Interpolation used by --deskew: nearest, linear, cubic, area or lanczos")
+	fs.IntVar(&cfg.jobs, "jobs", 1, "Number of images to process in parallel (0 = one per CPU)")
+	fs.Int64Var(&cfg.maxMemory, "max-memory", 0, "Memory budget in MB for images in flight (0 = half of available memory)")
+	fs.BoolVar(&walk.recursive, "recursive", false, "Descend into subfolders of target folders")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s apply --records FILE [options] target_files...\n", os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+Targets are matched to records by their path below the target folder, or
+their name when named directly: first exactly, then without extension, so
+crops detected on scan.jpg apply to scan.tif, then by base name without
+extension. A name matching records of several images is an error; use --map
+to pick one. Records are --output-format json or ndjson output, <image>.txt
+crop data kept with --keep-crop-data, or Lightroom XMP sidecars. Only json
+and ndjson records keep the frame itself, which --deskew straightens; the
+crop box of the others is already shrunk and cannot be deskewed.
+`)
+	}
+	fs.Parse(args)
+
+	var err error
+	cfg.interpolation, err = filmcrop.ParseInterpolation(interpolation)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+	if len(records) == 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: apply needs at least one --records file\n")
+		return ExitUsage
+	}
+
+	store, err := loadCrops(records)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+
+	targets := fs.Args()
+	mapping := make(map[string]string)
+	if mapFile != "" {
+		mapping, err = readCropMap(mapFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Failed to read --map '%s': %v\n", mapFile, err)
+			return ExitUsage
+		}
+		for target := range mapping {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets[len(fs.Args()):])
+	}
+	if len(targets) == 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	if cfg.outputDir != "" {
+		cfg.outputDir, err = filepath.Abs(cfg.outputDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return ExitUsage
+		}
+		walk.skip = append(walk.skip, cfg.outputDir)
+	}
+	walk.uniqueRel = cfg.outputDir != "" && !cfg.overwrite && !cfg.xmp
+	inputs, failures := collectInputs(targets, walk)
+
+	// Deskewing redoes the aspect correction and final shrink of the stored
+	// frame; the stored crop box already includes them
+	opts := filmcrop.DefaultOptions()
+	opts.EnforceAspect = enforceAspect
+	opts.Verbose = verbose
+	detector := filmcrop.NewDetector(opts)
+
+	total := len(inputs)
+	succeeded := 0
+	work := func(in input) outcome {
+		name := mapping[in.path]
+		if name == "" {
+			name = in.rel
+		}
+		crops, err := store.lookup(name)
+		if err != nil {
+			return outcome{err: err}
+		}
+		for _, c := range crops {
+			if cfg.deskew && c.rect == nil {
+				return outcome{err: fmt.Errorf("%w: %s", errNoStoredFrame, name)}
+			}
+		}
+		return applyFile(detector, in, crops, cfg)
+	}
+	runBatch(inputs, cfg, work, func(idx int, o outcome) {
+		status := fmt.Sprintf("[%d/%d] ", idx+1, total)
+		if o.err != nil {
+			fmt.Fprintf(os.Stderr, "%sWARNING: Skipping '%s': %v\n", status, inputs[idx].path, o.err)
+			failures = append(failures, failure{path: inputs[idx].path, err: o.err})
+			return
+		}
+		succeeded++
+		fmt.Println(status + o.line)
+	})
+
+	printSummary(failures)
+	return exitCode(succeeded, len(failures))
+}
+
+// applyFile crops a single target with its stored crops
+func applyFile(detector *filmcrop.Detector, in input, crops []storedCrop, cfg runConfig) (o outcome) {
+	start := time.Now()
+	defer func() { o.elapsed = time.Since(start) }()
+
+	img, err := filmcrop.ReadImage(in.path)
+	defer img.Close()
+	if err != nil {
+		return outcome{err: err}
+	}
+
+	for _, c := range crops {
+		o.results = append(o.results, c.result(img.Cols(), img.Rows()))
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "file= %s frames= %d size= %dx%d\n", in.path, len(crops), img.Cols(), img.Rows())
+	}
+
+	cfg.strip = len(o.results) > 1
+	o.line, o.outputs, o.err = writeOutput(detector, in, img, o.results, cfg)
+	return o
+}
+
+// loadCrops reads every record file in paths, expanding folders to the
+// record files directly inside them
+func loadCrops(paths []string) (cropStore, error) {
+	store := make(cropStore)
+	for _, path := range paths {
+		files := []string{path}
+		if isDir(path) {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			files = files[:0]
+			for _, entry := range entries {
+				name := filepath.Join(path, entry.Name())
+				if !entry.IsDir() && isRecordFile(name) {
+					files = append(files, name)
+				}
+			}
+		}
+
+		// An image recorded in several files takes its crops from the last
+		for _, file := range files {
+			found := make(cropStore)
+			if err := loadCropFile(found, file); err != nil {
+				return nil, fmt.Errorf("failed to read records '%s': %v", file, err)
+			}
+			for key, crops := range found {
+				store[key] = crops
+			}
+		}
+	}
+
+	for key, crops := range store {
+		sort.SliceStable(crops, func(i, j int) bool { return crops[i].frame < crops[j].frame })
+		store[key] = crops
+	}
+	return store, nil
+}
+
+// isRecordFile reports whether a file found in a records folder holds crops
+func isRecordFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".ndjson", ".xmp":
+		return true
+	case ".txt":
+		// Crop data is written next to the image as <image>.txt
+		return isImageFile(strings.TrimSuffix(path, filepath.Ext(path)))
+	}
+	return false
+}
+
+func loadCropFile(store cropStore, path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".ndjson":
+		return loadJSONCrops(store, path)
+	case ".xmp":
+		crop, rotation, err := filmcrop.ReadXMPSidecar(path)
+		if err != nil {
+			return err
+		}
+		// The sidecar is named after the image, without its extension
+		key := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		store[key] = append(store[key], storedCrop{crop: crop, rotation: rotation})
+		return nil
+	case ".txt":
+		return loadTextCrops(store, path)
+	}
+	return fmt.Errorf("unknown record format %q", filepath.Ext(path))
+}
+
+// loadJSONCrops reads --output-format json or ndjson records. Records of
+// failed files carry no crop and are skipped. Frames are only grouped when
+// they come from the same file, so two scans sharing a name stay apart.
+func loadJSONCrops(store cropStore, path string) error {
+	records, err := readRecords(path)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		if rec.Detection == nil || rec.Status == statusError {
+			continue
+		}
+		c := rec.Crop
+		key := rec.Rel
+		if key == "" {
+			key = filepath.ToSlash(rec.File)
+		}
+		crop := storedCrop{
+			frame:    rec.Frame,
+			crop:     filmcrop.Crop{Left: c.Left, Right: c.Right, Top: c.Top, Bottom: c.Bottom},
+			rotation: rec.Rotation,
+			format:   rec.Format,
+			width:    rec.Width,
+			height:   rec.Height,
+		}
+		if r := rec.Rect; r != nil {
+			crop.rect = &filmcrop.RotatedRect{
+				Center: filmcrop.Point2f{X: float32(r.CenterX), Y: float32(r.CenterY)},
+				Size:   filmcrop.Point2f{X: float32(r.Width), Y: float32(r.Height)},
+				Angle:  r.Angle,
+			}
+		}
+		store[key] = append(store[key], crop)
+	}
+	return nil
+}
+
+// loadTextCrops reads the crop data processImage writes to <image>.txt: five
+// lines of left, right, top, bottom and rotation per frame
+func loadTextCrops(store cropStore, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values []float64
+	for _, field := range strings.Fields(string(data)) {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return fmt.Errorf("bad value %q", field)
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 || len(values)%5 != 0 {
+		return fmt.Errorf("expected five values per frame, found %d", len(values))
+	}
+
+	key := filepath.Base(strings.TrimSuffix(path, filepath.Ext(path)))
+	for i := 0; i < len(values); i += 5 {
+		store[key] = append(store[key], storedCrop{
+			frame:    i/5 + 1,
+			crop:     filmcrop.Crop{Left: values[i], Right: values[i+1], Top: values[i+2], Bottom: values[i+3]},
+			rotation: values[i+4],
+		})
+	}
+	return nil
+}
+
+// readCropMap reads "source name<TAB>target path" lines, returning the
+// source name for each target
+func readCropMap(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mapping := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		source, target, ok := strings.Cut(line, "\t")
+		if !ok || source == "" || target == "" {
+			return nil, fmt.Errorf("line %d: expected \"source<TAB>target\"", n)
+		}
+		mapping[target] = source
+	}
+	return mapping, scanner.Err()
+}
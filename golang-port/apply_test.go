@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCropStoreLookup(t *testing.T) {
+	records := `{"file":"in/Roll1/001.jpg","rel":"Roll1/001.jpg","status":"ok","width":100,"height":100,"crop":{"left":0.1,"right":0.9,"top":0.1,"bottom":0.9},"rotation":0.5}
+{"file":"in/Roll2/001.jpg","rel":"Roll2/001.jpg","status":"ok","width":100,"height":100,"crop":{"left":0.2,"right":0.8,"top":0.2,"bottom":0.8},"rotation":-0.5}
+{"file":"in/strip.jpg","rel":"strip.jpg","frame":1,"status":"ok","width":100,"height":100,"crop":{"left":0,"right":0.5,"top":0,"bottom":1}}
+{"file":"in/strip.jpg","rel":"strip.jpg","frame":2,"status":"ok","width":100,"height":100,"crop":{"left":0.5,"right":1,"top":0,"bottom":1}}
+{"file":"old/scan.jpg","status":"ok","width":100,"height":100,"crop":{"left":0,"right":1,"top":0,"bottom":1}}
+{"file":"old/scan.tif","status":"ok","width":100,"height":100,"crop":{"left":0,"right":1,"top":0,"bottom":1}}
+`
+	path := filepath.Join(t.TempDir(), "records.ndjson")
+	if err := os.WriteFile(path, []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := loadCrops([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		frames int
+		left   float64
+		err    error
+	}{
+		{"Roll1/001.jpg", 1, 0.1, nil},
+		{"Roll2/001.tif", 1, 0.2, nil},
+		{"strip.png", 2, 0, nil},
+		{"old/scan.jpg", 1, 0, nil},
+		// Named directly, 001.jpg could be either roll
+		{"001.jpg", 0, 0, errAmbiguousCrop},
+		{"scan.png", 0, 0, errAmbiguousCrop},
+		{"002.jpg", 0, 0, errNoStoredCrop},
+	}
+	for _, tt := range tests {
+		crops, err := store.lookup(tt.name)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("lookup(%q) error = %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || len(crops) != tt.frames || crops[0].crop.Left != tt.left {
+			t.Errorf("lookup(%q) = %+v, %v", tt.name, crops, err)
+		}
+	}
+}
+
+func TestStoredCropResult(t *testing.T) {
+	records := `{"file":"scan.jpg","status":"ok","width":200,"height":100,"format":"645","rect":{"center_x":100,"center_y":50,"width":160,"height":80,"angle":2},"crop":{"left":0.05,"right":0.95,"top":0.05,"bottom":0.95},"rotation":-2}
+`
+	dir := t.TempDir()
+	path := filepath.Join(dir, "records.ndjson")
+	if err := os.WriteFile(path, []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "other.jpg.txt"), []byte("0.1 0.9 0.1 0.9 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := loadCrops([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The frame is scaled to the target, not rebuilt from the crop box
+	crops, err := store.lookup("scan.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := crops[0].result(400, 200)
+	if res.Format != "645" || res.Rect == nil || res.Rect.Center.X != 200 || res.Rect.Size.X != 320 || res.Rect.Size.Y != 160 || res.Rect.Angle != 2 {
+		t.Errorf("result(400, 200) = format %q rect %+v", res.Format, res.Rect)
+	}
+
+	// Crop data only holds the crop box
+	crops, err = store.lookup("other.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crops[0].rect != nil {
+		t.Errorf("crop data has frame %+v", crops[0].rect)
+	}
+}
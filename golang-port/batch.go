@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"film-crop-detector/filmcrop"
+
+	"gocv.io/x/gocv"
+)
+
+// memoryFactor approximates how many copies of the decoded image are alive
+// during detection: the master, the 8-bit proxy and the filter/threshold
+// working images
+const memoryFactor = 4
+
+// outcome is the result of processing one input file
+type outcome struct {
+	results []filmcrop.Result
+	// outputs holds the file written for each result, or "" for none
+	outputs []string
+	// review is set when the file was held back for low confidence
+	review  bool
+	line    string
+	err     error
+	elapsed time.Duration
+}
+
+// runBatch calls work for every file using cfg.jobs workers, and report for
+// each outcome in input order from the calling goroutine
+func runBatch(files []input, cfg runConfig, work func(in input) outcome, report func(idx int, o outcome)) {
+	jobs := cfg.jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	if cfg.showWindows {
+		// Debug windows must stay on a single thread
+		jobs = 1
+	}
+	jobs = min(jobs, len(files))
+
+	if jobs <= 1 {
+		for idx, in := range files {
+			report(idx, work(in))
+		}
+		return
+	}
+
+	// Split OpenCV's own threads between the workers
+	gocv.SetNumThreads(max(1, runtime.NumCPU()/jobs))
+
+	limit := cfg.maxMemory << 20
+	if limit <= 0 {
+		limit = defaultMemoryLimit()
+	}
+	limiter := newMemoryLimiter(limit)
+	if verbose {
+		fmt.Fprintf(os.Stderr, "jobs= %d memory limit= %d MB\n", jobs, limit>>20)
+	}
+
+	queue := make(chan int)
+	finished := make(chan int, len(files))
+	outcomes := make([]outcome, len(files))
+
+	for w := 0; w < jobs; w++ {
+		go func() {
+			for idx := range queue {
+				weight := estimateMemory(files[idx].path)
+				limiter.acquire(weight)
+				outcomes[idx] = work(files[idx])
+				limiter.release(weight)
+				finished <- idx
+			}
+		}()
+	}
+
+	go func() {
+		for idx := range files {
+			queue <- idx
+		}
+		close(queue)
+	}()
+
+	// Hold back outcomes that finish early until their predecessors are done
+	ready := make([]bool, len(files))
+	next := 0
+	for range files {
+		ready[<-finished] = true
+		for next < len(files) && ready[next] {
+			report(next, outcomes[next])
+			next++
+		}
+	}
+}
+
+// memoryLimiter is a weighted semaphore bounding the bytes of decoded image
+// data in flight. A single request larger than the limit is allowed to run
+// on its own.
+type memoryLimiter struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+func newMemoryLimiter(limit int64) *memoryLimiter {
+	l := &memoryLimiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *memoryLimiter) acquire(n int64) {
+	n = min(n, l.limit)
+	l.mu.Lock()
+	for l.used > 0 && l.used+n > l.limit {
+		l.cond.Wait()
+	}
+	l.used += n
+	l.mu.Unlock()
+}
+
+func (l *memoryLimiter) release(n int64) {
+	n = min(n, l.limit)
+	l.mu.Lock()
+	l.used -= n
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// estimateMemory guesses the memory needed to process path from its pixel
+// dimensions, falling back to the file size for formats the standard
+// library cannot inspect (such as TIFF, which is usually uncompressed)
+func estimateMemory(path string) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	if cfg, _, err := image.DecodeConfig(f); err == nil {
+		bytesPerPixel := int64(4)
+		switch cfg.ColorModel {
+		case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+			bytesPerPixel = 8
+		}
+		return int64(cfg.Width) * int64(cfg.Height) * bytesPerPixel * memoryFactor
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size() * memoryFactor
+}
+
+// defaultMemoryLimit is half of the available memory, or 4 GB when that
+// cannot be determined
+func defaultMemoryLimit() int64 {
+	const fallback = 4 << 30
+
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return fallback
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err == nil && kb > 0 {
+				return kb << 10 / 2
+			}
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"film-crop-detector/filmcrop"
+)
+
+// command is a subcommand of the tool
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands lists the subcommands in help order. It is filled in by init as
+// the help command refers back to it.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"detect", "find frames and print the results without writing any file", func(args []string) int { return runCropCommand(detectMode, args) }},
+		{"crop", "find frames and write cropped images or XMP sidecars", func(args []string) int { return runCropCommand(cropMode, args) }},
+		{"apply", "crop other files, such as masters, using stored crop records", runApply},
+		{"report", "summarize the json or ndjson results of a run", runReport},
+		{"watch", "crop new images as they appear in a capture folder", runWatch},
+		{"serve", "answer detect and crop requests over HTTP", runServe},
+		{"eval", "score detection against annotated scans", runEval},
+		{"synth", "render synthetic scans with known frames for eval and tests", runSynth},
+		{"help", "show help for a command", runHelp},
+	}
+}
+
+// findCommand returns the subcommand called name, or nil
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func runHelp(args []string) int {
+	if len(args) > 0 {
+		if cmd := findCommand(args[0]); cmd != nil && cmd.name != "help" {
+			return cmd.run([]string{"-h"})
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options] [files...]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, `
+Without a command the original single-command interface is used:
+  %s [options] image_files...
+which crops like "crop" and also accepts --dry-run and --enforce-32.
+Run "%s help <command>" for the options of a command.
+`, os.Args[0], os.Args[0])
+	return ExitUsage
+}
+
+// detectionMode selects which of detect, crop and the compatible default a
+// run behaves as
+type detectionMode struct {
+	// name is the subcommand, or "" for the compatible default
+	name string
+	// write enables the flags that write images and sidecars
+	write bool
+	// compat enables --dry-run, --enforce-32 and the five crop values per
+	// frame printed by text output
+	compat bool
+	// output is the default --output-format
+	output string
+}
+
+var (
+	legacyMode = detectionMode{write: true, compat: true, output: "text"}
+	cropMode   = detectionMode{name: "crop", write: true, output: "text"}
+	detectMode = detectionMode{name: "detect", output: "ndjson"}
+)
+
+// runCropCommand parses args for mode, runs detection on every input and
+// returns the exit code
+func runCropCommand(mode detectionMode, args []string) int {
+	name := os.Args[0]
+	if mode.name != "" {
+		name += " " + mode.name
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+
+	f := addCropFlags(fs, mode.write)
+	var enforce32 bool
+	var filesFrom string
+	var reviewList string
+	var outputFormat string
+	var rollMode string
+	rollTol := filmcrop.DefaultRollTolerance
+	var walk walkConfig
+
+	// Detection
+	fs.BoolVar(&f.cfg.showWindows, "show", false, "Display debug windows")
+	if mode.compat {
+		fs.BoolVar(&enforce32, "enforce-32", false, "Enforce 3:2 or 2:3 aspect ratio (same as --format 135 --enforce-aspect)")
+	}
+	fs.StringVar(&reviewList, "review-list", "", "Write the files left for review, with their confidence, to this file")
+	fs.StringVar(&rollMode, "roll", "", "Treat the inputs as one roll: detect every frame first, then flag, replace or refit the frames whose size or angle differ from the roll median")
+	fs.Float64Var(&rollTol.Size, "roll-size", rollTol.Size, "Largest difference of either frame side from the roll median, as a fraction, before --roll adjusts a frame")
+	fs.Float64Var(&rollTol.Angle, "roll-angle", rollTol.Angle, "Largest difference in degrees of the frame angle from the roll median before --roll adjusts a frame")
+
+	// Output
+	fs.StringVar(&outputFormat, "output-format", mode.output, "Result output on stdout: "+strings.Join(outputFormats, ", "))
+	if mode.compat {
+		fs.BoolVar(&f.cfg.dryRun, "dry-run", false, "Do not write cropped output image")
+	}
+	if mode.write {
+		fs.BoolVar(&f.cfg.overwrite, "overwrite", false, "Overwrite original images")
+	}
+
+	// Inputs
+	fs.BoolVar(&walk.recursive, "recursive", false, "Descend into subfolders of input folders")
+	fs.Var(&walk.include, "include", "Only process folder files matching this glob (repeatable)")
+	fs.Var(&walk.exclude, "exclude", "Skip folder files and subfolders matching this glob (repeatable)")
+	fs.StringVar(&filesFrom, "files-from", "", "Read input paths from this file, one per line or NUL-separated (- for stdin)")
+	fs.Usage = func() { usage(fs, mode) }
+
+	fs.Parse(args)
+
+	files := fs.Args()
+	if filesFrom != "" {
+		listed, err := readFileList(filesFrom)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Failed to read --files-from '%s': %v\n", filesFrom, err)
+			return ExitUsage
+		}
+		files = append(files, listed...)
+	}
+	if len(files) == 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	// Machine readable results own stdout, so progress moves to stderr
+	report, err := newReporter(outputFormat, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+	progress := io.Writer(os.Stdout)
+	if report != nil {
+		progress = os.Stderr
+	}
+
+	if enforce32 {
+		f.format = "135"
+		f.enforceAspect = true
+	}
+	cfg, opts, err := f.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+	if reviewList != "" && cfg.minConfidence == 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: --review-list needs --min-confidence\n")
+		return ExitUsage
+	}
+	var roll *rollConfig
+	if rollMode != "" {
+		mode, err := parseRollMode(rollMode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return ExitUsage
+		}
+		if rollTol.Size <= 0 || rollTol.Angle <= 0 {
+			fmt.Fprintf(os.Stderr, "ERROR: --roll-size and --roll-angle must be positive\n")
+			return ExitUsage
+		}
+		roll = &rollConfig{mode: mode, tol: rollTol}
+	}
+	for _, out := range []string{cfg.outputDir, cfg.reviewDir} {
+		if out != "" {
+			walk.skip = append(walk.skip, out)
+		}
+	}
+	detector := filmcrop.NewDetector(opts)
+
+	// Expand directories
+	for _, file := range files {
+		if mode.write && isDir(file) && !cfg.overwrite && cfg.outputDir == "" && !cfg.xmp {
+			fmt.Fprintf(os.Stderr, "ERROR: When passing a folder, provide --output-dir, --overwrite or --xmp\n")
+			return ExitUsage
+		}
+	}
+	walk.uniqueRel = (cfg.outputDir != "" && !cfg.overwrite && !cfg.xmp) || cfg.reviewDir != ""
+	inputs, failures := collectInputs(files, walk)
+
+	total := len(inputs)
+	succeeded := 0
+	var reviews []review
+
+	// Outcomes arrive in input order whatever the number of jobs
+	work := func(in input) outcome { return runFile(detector, in, cfg) }
+	var adjusted []rollFrame
+	if roll != nil {
+		// Detect the whole roll first, then process every file against the
+		// consensus
+		failed := detectRoll(detector, inputs, cfg, roll, progress)
+		cfg.roll = roll
+		work = func(in input) outcome {
+			if o, ok := failed[in.path]; ok {
+				return o
+			}
+			return runFile(detector, in, cfg)
+		}
+	}
+	runBatch(inputs, cfg, work, func(idx int, o outcome) {
+		status := fmt.Sprintf("[%d/%d] ", idx+1, total)
+		filename := inputs[idx].path
+
+		if report != nil {
+			if err := report.write(newRecords(filename, filepath.ToSlash(inputs[idx].rel), o)); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: Failed to write results: %v\n", err)
+			}
+		} else if mode.compat {
+			for _, v := range cropData(o.results) {
+				fmt.Println(v)
+			}
+		}
+
+		if o.err != nil {
+			fmt.Fprintf(os.Stderr, "%sWARNING: Skipping '%s': %v\n", status, filename, o.err)
+			failures = append(failures, failure{path: filename, err: o.err})
+			return
+		}
+		succeeded++
+		if o.review {
+			reviews = append(reviews, review{path: filename, score: lowestConfidence(o.results)})
+		}
+		adjusted = append(adjusted, rollFrames(filename, o.results)...)
+		fmt.Fprintln(progress, status+o.line)
+	})
+
+	if report != nil {
+		if err := report.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Failed to write results: %v\n", err)
+		}
+	}
+
+	if reviewList != "" && !cfg.dryRun {
+		if err := writeReviewList(reviewList, reviews); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			failures = append(failures, failure{path: reviewList, err: err})
+		}
+	}
+	if len(reviews) > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d file(s) left for review below confidence %.2f\n", len(reviews), cfg.minConfidence)
+	}
+	printRollFrames(adjusted)
+
+	printSummary(failures)
+	return exitCode(succeeded, len(failures))
+}
+
+func usage(fs *flag.FlagSet, mode detectionMode) {
+	switch mode.name {
+	case "":
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] image_files...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s <command> [options] [files...]   (see %s help)\n", os.Args[0], os.Args[0])
+	case "detect":
+		fmt.Fprintf(os.Stderr, "Usage: %s detect [options] image_files...\n\nFinds frames and prints the results; no file is written.\n\n", os.Args[0])
+	case "crop":
+		fmt.Fprintf(os.Stderr, "Usage: %s crop [options] image_files...\n\nFinds frames and writes the cropped images, or XMP sidecars with --xmp.\n\n", os.Args[0])
+	}
+	fs.PrintDefaults()
+
+	fmt.Fprintf(os.Stderr, "\nFilm formats:\n%s", formatList())
+	if mode.write {
+		fmt.Fprintf(os.Stderr, `
+Output paths:
+  --overwrite      the input file itself
+  --output-dir DIR DIR/<path below the input folder>, or DIR/<name> for files
+                   named directly; a relative DIR is taken from the working
+                   directory and is never scanned as input; of two inputs
+                   that would share an output path, the later one fails
+                   before anything is written
+  otherwise        <name>_cropped<ext> next to the input
+`)
+	}
+	fmt.Fprintf(os.Stderr, `
+Exit codes:
+  %d  all files processed
+  %d  some files failed
+  %d  invalid arguments, nothing ran
+  %d  no file could be processed
+  %d  no input files found, nothing ran
+`, ExitOK, ExitPartial, ExitUsage, ExitAllFailed, ExitNoInputs)
+}
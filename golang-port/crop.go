@@ -1,892 +1,571 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"image"
-	"image/color"
 	"math"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
-	"flag"
-	
+	"time"
+
+	"film-crop-detector/filmcrop"
+	"film-crop-detector/jpegcrop"
+	"film-crop-detector/metadata"
+
 	"gocv.io/x/gocv"
 )
 
-// Detection settings
+// Exit codes reported to batch scripts
 const (
-	MaxCoverage   = 0.98
-	InsetPercent  = 0.005
+	// ExitOK means every input file was processed
+	ExitOK = 0
+	// ExitPartial means at least one file failed but others succeeded
+	ExitPartial = 1
+	// ExitUsage means the arguments were invalid and nothing ran
+	ExitUsage = 2
+	// ExitAllFailed means no input file could be processed
+	ExitAllFailed = 3
+	// ExitNoInputs means the arguments were valid but named no file to
+	// process, such as an empty folder, so nothing ran
+	ExitNoInputs = 4
 )
 
 var verbose bool
 
-// Point2f represents a 2D point with float coordinates
-type Point2f struct {
-	X, Y float32
+// runConfig holds the output settings shared by every file in a run
+type runConfig struct {
+	showWindows bool
+	dryRun      bool
+	outputDir   string
+	overwrite   bool
+	xmp         bool
+	metadata    bool
+	strip       bool
+	autoFormat  bool
+	jobs        int
+	maxMemory   int64
+
+	keepCropData bool
+	// detectOnly stops after detection, as the detect command does
+	detectOnly bool
+
+	minConfidence float64
+	reviewDir     string
+
+	deskew bool
+	// perspective warps the refined quadrilateral square instead of
+	// cropping, for frames shot at a slant
+	perspective   bool
+	interpolation gocv.InterpolationFlags
+
+	// invert turns negatives into positives using the sampled film base
+	invert  bool
+	balance filmcrop.Balance
+
+	// lossless crops JPEG input without re-encoding, snapping the frame
+	// onto the block grid
+	lossless bool
+	snap     jpegcrop.Snap
+
+	// roll holds the roll consensus and first pass detections during the
+	// second pass of --roll, and is nil otherwise
+	roll *rollConfig
 }
 
-// RotatedRect represents a rotated rectangle
-type RotatedRect struct {
-	Center Point2f
-	Size   Point2f  
-	Angle  float64
+// failure records why an input could not be processed
+type failure struct {
+	path string
+	err  error
 }
 
 func main() {
-	var showWindows bool
-	var enforce32 bool
-	var dryRun bool
-	var outputDir string
-	var overwrite bool
-	
-	flag.BoolVar(&verbose, "verbose", false, "Print debug information")
-	flag.BoolVar(&showWindows, "show", false, "Display debug windows")
-	flag.BoolVar(&enforce32, "enforce-32", false, "Enforce 3:2 or 2:3 aspect ratio")
-	flag.BoolVar(&dryRun, "dry-run", false, "Do not write cropped output image")
-	flag.StringVar(&outputDir, "output-dir", "", "Output directory for processed images")
-	flag.BoolVar(&overwrite, "overwrite", false, "Overwrite original images")
-	
-	flag.Parse()
-	
-	files := flag.Args()
-	if len(files) == 0 {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] image_files...\n", os.Args[0])
-		flag.PrintDefaults()
-		os.Exit(1)
-	}
-	
-	// Expand directories
-	var inputFiles []string
-	for _, file := range files {
-		if isDir(file) {
-			if !overwrite && outputDir == "" {
-				fmt.Fprintf(os.Stderr, "ERROR: When passing a folder, provide --output-dir or --overwrite\n")
-				os.Exit(2)
-			}
-			dirFiles, err := expandDirectory(file)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "ERROR: Failed to list directory '%s': %v\n", file, err)
-				continue
-			}
-			inputFiles = append(inputFiles, dirFiles...)
-		} else {
-			inputFiles = append(inputFiles, file)
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if cmd := findCommand(args[0]); cmd != nil {
+			os.Exit(cmd.run(args[1:]))
 		}
 	}
-	
-	total := len(inputFiles)
-	
-	for idx, filename := range inputFiles {
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					fmt.Fprintf(os.Stderr, "[%d/%d] WARNING: Skipping '%s': %v\n", idx+1, total, filename, r)
-				}
-			}()
-			
-			img, left, right, top, bottom, intermediates := processImage(filename, showWindows, enforce32)
-			defer img.Close()
-			
-			// Write cropped output unless dry-run
-			var outPath string
-			if !dryRun && !img.Empty() {
-				h, w := img.Rows(), img.Cols()
-				x0 := int(math.Max(0, math.Min(float64(w-1), left*float64(w))))
-				x1 := int(math.Max(0, math.Min(float64(w), right*float64(w))))
-				y0 := int(math.Max(0, math.Min(float64(h-1), top*float64(h))))
-				y1 := int(math.Max(0, math.Min(float64(h), bottom*float64(h))))
-				
-				if verbose {
-					fmt.Fprintf(os.Stderr, "crop px (x0,x1,y0,y1)= %d %d %d %d\n", x0, x1, y0, y1)
-				}
-				
-				if x1 > x0 && y1 > y0 {
-					rect := image.Rect(x0, y0, x1, y1)
-					cropped := img.Region(rect)
-					defer cropped.Close()
-					
-					// Determine output path
-					if overwrite {
-						outPath = filename
-					} else if outputDir != "" {
-						baseDir := filepath.Dir(filename)
-						var finalOutputDir string
-						if filepath.IsAbs(outputDir) {
-							finalOutputDir = outputDir
-						} else {
-							finalOutputDir = filepath.Join(filepath.Dir(baseDir), outputDir)
-						}
-						os.MkdirAll(finalOutputDir, 0755)
-						outPath = filepath.Join(finalOutputDir, filepath.Base(filename))
-					} else {
-						ext := filepath.Ext(filename)
-						base := strings.TrimSuffix(filename, ext)
-						outPath = base + "_cropped" + ext
-					}
-					
-					ok := gocv.IMWrite(outPath, cropped)
-					if verbose {
-						fmt.Fprintf(os.Stderr, "wrote cropped: %v %s\n", ok, outPath)
-					}
-				}
-			}
-			
-			// Cleanup intermediates
-			for _, p := range intermediates {
-				os.Remove(p)
-				if verbose {
-					fmt.Fprintf(os.Stderr, "cleaned up intermediate: %s\n", p)
-				}
-			}
-			
-			// Progress output
-			retained := math.Max(0.0, (right-left)*(bottom-top))
-			pct := int(math.Round(retained * 100))
-			status := fmt.Sprintf("[%d/%d] ", idx+1, total)
-			
-			var line string
-			if dryRun {
-				line = fmt.Sprintf("%swould crop to %d%% (%s)", status, pct, filepath.Base(filename))
-			} else {
-				dest := outPath
-				if dest == "" {
-					dest = "(no output)"
-				}
-				line = fmt.Sprintf("%scropped image to %d%% -> %s", status, pct, dest)
-			}
-			fmt.Println(line)
-		}()
+
+	// Without a subcommand behave like crop with the original flags
+	os.Exit(runCropCommand(legacyMode, args))
+}
+
+func formatList() string {
+	var b strings.Builder
+	for _, f := range filmcrop.Formats {
+		fmt.Fprintf(&b, "  %-11s %s\n", f.Name, f.Description)
 	}
+	fmt.Fprintf(&b, "  %-11s %s\n", filmcrop.Auto.Name, filmcrop.Auto.Description)
+	return b.String()
 }
 
-func processImage(filename string, showWindows, enforce32 bool) (gocv.Mat, float64, float64, float64, float64, []string) {
-	if !fileExists(filename) {
-		panic(fmt.Sprintf("Could not find file '%s'", filename))
+// exitCode maps the outcome of a run to one of the documented exit codes,
+// warning when there was no file to run on
+func exitCode(succeeded, failed int) int {
+	switch {
+	case succeeded+failed == 0:
+		fmt.Fprintf(os.Stderr, "WARNING: No input files found\n")
+		return ExitNoInputs
+	case succeeded == 0:
+		return ExitAllFailed
+	case failed > 0:
+		return ExitPartial
+	default:
+		return ExitOK
 	}
-	
-	var intermediates []string
-	
-	// Read image
-	img := gocv.IMRead(filename, gocv.IMReadColor)
-	if img.Empty() {
-		panic("failed to read image")
+}
+
+func printSummary(failures []failure) {
+	if len(failures) == 0 {
+		return
 	}
-	
-	if verbose {
-		fmt.Fprintf(os.Stderr, "file= %s\n", filename)
-		fmt.Fprintf(os.Stderr, "image.shape= %dx%dx%d dtype= %v\n", img.Rows(), img.Cols(), img.Channels(), img.Type())
+
+	fmt.Fprintf(os.Stderr, "\n%d file(s) failed:\n", len(failures))
+	for _, f := range failures {
+		fmt.Fprintf(os.Stderr, "  %s: %v\n", f.path, f.err)
 	}
-	
-	rawRect := findExposureBounds(img, showWindows)
-	if verbose {
-		fmt.Fprintf(os.Stderr, "rawRect= %+v\n", rawRect)
-	}
-	
-	// Default outputs
-	cropLeft := 0.0
-	cropRight := 1.0
-	cropTop := 0.0
-	cropBottom := 1.0
-	rotation := 0.0
-	
-	if rawRect != nil {
-		// Average height and width to get constant inset
-		insetPixels := ((rawRect.Size.X + rawRect.Size.Y) / 2.0) * InsetPercent
-		
-		insetRect := &RotatedRect{
-			Center: rawRect.Center,
-			Size:   Point2f{X: rawRect.Size.X - insetPixels, Y: rawRect.Size.Y - insetPixels},
-			Angle:  rawRect.Angle,
-		}
-		
-		rect, aspectChanged := correctAspectRatio(insetRect, 1.5, 0.3)
-		if verbose {
-			fmt.Fprintf(os.Stderr, "insetRect= %+v rectCorrected= %+v aspectChanged= %v\n", insetRect, rect, aspectChanged)
-		}
-		
-		cropLeft, cropRight, cropTop, cropBottom = calculateCropCoordinates(rect, img.Rows(), img.Cols())
-		
-		// Enforce 3:2 aspect ratio if requested
-		if enforce32 {
-			cropLeft, cropRight, cropTop, cropBottom = enforce32AspectRatio(
-				cropLeft, cropRight, cropTop, cropBottom, img.Cols(), img.Rows())
-		}
-		
-		// Final 1% inward crop preserving aspect ratio
-		prev := [4]float64{cropLeft, cropRight, cropTop, cropBottom}
-		cropLeft, cropRight, cropTop, cropBottom = shrinkCropUniform(
-			cropLeft, cropRight, cropTop, cropBottom, 0.01)
-		if verbose {
-			fmt.Fprintf(os.Stderr, "final 1%% shrink from %v to %v\n", prev, [4]float64{cropLeft, cropRight, cropTop, cropBottom})
-		}
-		
-		// Rotation for Lightroom
-		rotation = -rect.Angle
-		if rotation > 45 {
-			rotation -= 90
-		} else if rotation < -90 {
-			rotation += 45
-		}
-		
-		if verbose {
-			fmt.Fprintf(os.Stderr, "rotation= %f\n", rotation)
-			fmt.Fprintf(os.Stderr, "crops LRTB= %f %f %f %f\n", cropLeft, cropRight, cropTop, cropBottom)
-		}
-		
-		// Draw debug overlays
-		debugImg := img.Clone()
-		drawDebugOverlays(debugImg, rawRect, insetRect, rect)
-		
-		// Write results
-		cropData := []float64{cropLeft, cropRight, cropTop, cropBottom, rotation}
-		for _, v := range cropData {
-			fmt.Println(v)
-		}
-		
-		txtPath := filename + ".txt"
-		writeCropData(txtPath, cropData)
-		intermediates = append(intermediates, txtPath)
-		
-		analysisPath := filename + "-analysis.jpg"
-		gocv.IMWrite(analysisPath, debugImg)
-		intermediates = append(intermediates, analysisPath)
-		debugImg.Close()
-		
-		if showWindows {
-			window := gocv.NewWindow("image")
-			defer window.Close()
-			
-			resized := gocv.NewMat()
-			defer resized.Close()
-			gocv.Resize(debugImg, &resized, image.Point{}, 0.75, 0.75, gocv.InterpolationLinear)
-			
-			window.IMShow(resized)
-			window.WaitKey(0)
+}
+
+// runFile detects and crops a single file
+func runFile(detector *filmcrop.Detector, in input, cfg runConfig) (o outcome) {
+	start := time.Now()
+	defer func() { o.elapsed = time.Since(start) }()
+
+	img, results, err := processImage(detector, in, cfg)
+	defer img.Close()
+	if err != nil {
+		return outcome{err: err}
+	}
+	if cfg.invert {
+		if err := sampleBases(detector, img, results); err != nil {
+			return outcome{err: fmt.Errorf("%w: %s", err, in.path)}
 		}
+	}
+
+	o.results = results
+	if cfg.detectOnly {
+		o.review = cfg.needsReview(results)
+		o.line = fmt.Sprintf("detected %d frame(s), confidence %.2f (%s)%s", len(results), lowestConfidence(results), filepath.Base(in.path), formatNote(results, cfg))
+		return o
+	}
+	if cfg.needsReview(results) {
+		// Hold back unreliable detections instead of cropping them blindly
+		o.review = true
+		o.line, o.outputs, o.err = queueForReview(in, results, cfg)
 	} else {
-		// Even when no rect found, still emit default crop data
-		cropData := []float64{cropLeft, cropRight, cropTop, cropBottom, rotation}
-		for _, v := range cropData {
-			fmt.Println(v)
-		}
-		
-		txtPath := filename + ".txt"
-		writeCropData(txtPath, cropData)
-		intermediates = append(intermediates, txtPath)
+		o.line, o.outputs, o.err = writeOutput(detector, in, img, results, cfg)
 	}
-	
-	return img, cropLeft, cropRight, cropTop, cropBottom, intermediates
+	return o
 }
 
-func findExposureBounds(img gocv.Mat, showOutputWindow bool) *RotatedRect {
-	// Detect polarity and optionally invert for processing
-	polarity := detectScanPolarity(img)
-	workImg := img.Clone()
-	defer workImg.Close()
-	
-	if polarity == "positive" {
-		// Invert positive to negative-like for processing
-		gocv.BitwiseNot(workImg, &workImg)
-		if verbose {
-			fmt.Fprintf(os.Stderr, "inverted positive image for processing\n")
-		}
+// writeOutput writes the crop of every detected frame and returns the
+// progress line along with the file written for each frame, if any
+func writeOutput(detector *filmcrop.Detector, in input, img gocv.Mat, results []filmcrop.Result, cfg runConfig) (string, []string, error) {
+	if cfg.strip {
+		return writeStrip(detector, in, img, results, cfg)
 	}
-	
-	gray := gocv.NewMat()
-	defer gray.Close()
-	gocv.CvtColor(workImg, &gray, gocv.ColorBGRToGray)
-	
-	// Smooth out noise and maximize brightness range
-	bilateralFiltered := gocv.NewMat()
-	defer bilateralFiltered.Close()
-	gocv.BilateralFilter(gray, &bilateralFiltered, 11, 17, 17)
-	
-	equalized := gocv.NewMat()
-	defer equalized.Close()
-	gocv.EqualizeHist(bilateralFiltered, &equalized)
-	
-	ignoreMask := createIgnoreMask(workImg, equalized, polarity)
-	defer ignoreMask.Close()
-	
-	// Get min/max region of interest areas
-	height, width := workImg.Rows(), workImg.Cols()
-	maxArea := (float64(height) * MaxCoverage) * (float64(width) * MaxCoverage)
-	minCaptureArea := maxArea * 0.65
-	
-	var results []*RotatedRect
-	var bestRect *RotatedRect
-	bestArea := 0.0
-	
-	kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Point{5, 5})
-	defer kernel.Close()
-	
-	for lowerThreshold := 0; lowerThreshold < 240; lowerThreshold += 5 {
-		// Use negative logic (THRESH_BINARY_INV) since we invert positives
-		binary := gocv.NewMat()
-		gocv.Threshold(equalized, &binary, float32(lowerThreshold), 255, gocv.ThresholdBinaryInv)
-		
-		masked := gocv.NewMat()
-		gocv.BitwiseAnd(ignoreMask, binary, &masked)
-		binary.Close()
-		
-		// Morphology
-		dilated := gocv.NewMat()
-		gocv.Dilate(masked, &dilated, kernel)
-		masked.Close()
-		
-		eroded := gocv.NewMat()
-		gocv.Erode(dilated, &eroded, kernel)
-		dilated.Close()
-		
-		rect, area := findLargestContourRect(eroded)
-		
-		if verbose {
-			fmt.Fprintf(os.Stderr, "threshold= %d area= %f rect= %+v\n", lowerThreshold, area, rect)
-		}
-		
-		// Track best seen rect by area
-		if rect != nil && area > bestArea {
-			bestArea = area
-			bestRect = rect
+
+	filename := in.path
+	res := results[0]
+	pct := int(math.Round(res.Crop.Retained() * 100))
+	if cfg.dryRun {
+		return fmt.Sprintf("would crop to %d%% (%s)%s", pct, filepath.Base(filename), formatNote(results, cfg)), nil, nil
+	}
+
+	// Sidecar mode records the crop without touching pixels
+	if cfg.xmp {
+		xmpPath := filmcrop.SidecarPath(filename)
+		if err := filmcrop.WriteXMPSidecar(xmpPath, res); err != nil {
+			return "", nil, err
 		}
-		
-		// Stop once a valid result is returned
-		if rect != nil && area >= maxArea {
-			eroded.Close()
-			break
+		return fmt.Sprintf("wrote crop of %d%% -> %s%s", pct, xmpPath, formatNote(results, cfg)), []string{xmpPath}, nil
+	}
+
+	if cfg.lossless {
+		outPath, err := outputPath(in, cfg)
+		if err != nil {
+			return "", nil, err
 		}
-		
-		if rect != nil && area >= minCaptureArea {
-			results = append(results, rect)
+		ok, err := writeLossless(outPath, filename, img, res, cfg)
+		if err != nil {
+			return "", nil, err
 		}
-		
-		if showOutputWindow {
-			debugImg := gocv.NewMat()
-			gocv.CvtColor(eroded, &debugImg, gocv.ColorGrayToBGR)
-			
-			if rect != nil {
-				drawRotatedRect(debugImg, rect, image.RGBA{0, 255, 0, 255}, 3) // Green for collected
-			}
-			
-			// Draw threshold text
-			gocv.PutText(&debugImg, fmt.Sprintf("Threshold: %d", lowerThreshold), 
-				image.Point{20, 30}, gocv.FontHersheyPlain, 2, 
-				image.RGBA{0, 150, 255, 255}, 2)
-			
-			window := gocv.NewWindow("image")
-			resized := gocv.NewMat()
-			gocv.Resize(debugImg, &resized, image.Point{}, 0.75, 0.75, gocv.InterpolationLinear)
-			window.IMShow(resized)
-			window.WaitKey(1)
-			window.Close()
-			resized.Close()
-			debugImg.Close()
+		if ok {
+			return fmt.Sprintf("cropped image to %d%% (lossless) -> %s%s", pct, outPath, formatNote(results, cfg)), []string{outPath}, nil
 		}
-		
-		eroded.Close()
 	}
-	
-	// Prefer median of good results; fall back to best seen rect
-	median := medianRect(results)
-	if median != nil {
-		return median
+
+	// Write cropped output
+	cropped, err := cropImage(detector, img, res, cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	defer cropped.Close()
+
+	if cropped.Empty() {
+		return fmt.Sprintf("cropped image to %d%% -> (no output)%s", pct, formatNote(results, cfg)), nil, nil
 	}
-	return bestRect
-}
 
-func detectScanPolarity(img gocv.Mat) string {
-	gray := gocv.NewMat()
-	defer gray.Close()
-	gocv.CvtColor(img, &gray, gocv.ColorBGRToGray)
-	
-	h, w := gray.Rows(), gray.Cols()
-	band := int(math.Max(5, float64(min(h, w))*0.02))
-	
-	// Sample border bands
-	topBand := gray.Region(image.Rect(0, 0, w, band))
-	bottomBand := gray.Region(image.Rect(0, h-band, w, h))
-	leftBand := gray.Region(image.Rect(0, 0, band, h))
-	rightBand := gray.Region(image.Rect(w-band, 0, w, h))
-	
-	meanVal := (gocv.Mean(topBand).Val1 + gocv.Mean(bottomBand).Val1 + 
-		gocv.Mean(leftBand).Val1 + gocv.Mean(rightBand).Val1) / 4.0
-	
-	topBand.Close()
-	bottomBand.Close()
-	leftBand.Close()
-	rightBand.Close()
-	
-	var polarity string
-	if meanVal >= 150.0 {
-		polarity = "negative"
-	} else {
-		polarity = "positive"
+	outPath, err := outputPath(in, cfg)
+	if err != nil {
+		return "", nil, err
 	}
-	
-	if verbose {
-		fmt.Fprintf(os.Stderr, "polarity mean border gray= %f => %s\n", meanVal, polarity)
+	if err := writeImage(outPath, filename, cropped, cfg); err != nil {
+		return "", nil, err
 	}
-	
-	return polarity
-}
 
-func createIgnoreMask(img, gray gocv.Mat, polarity string) gocv.Mat {
-	// Mask brightest spots
-	ignoreMask := gocv.NewMat()
-	gocv.Threshold(gray, &ignoreMask, 240, 255, gocv.ThresholdBinary)
-	
-	kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Point{3, 3})
-	defer kernel.Close()
-	
-	dilated := gocv.NewMat()
-	defer dilated.Close()
-	gocv.Dilate(ignoreMask, &dilated, kernel)
-	ignoreMask.Close()
-	
-	if polarity == "negative" {
-		// Ignore areas of low saturation (common in negative scans)
-		hsv := gocv.NewMat()
-		defer hsv.Close()
-		gocv.CvtColor(img, &hsv, gocv.ColorBGRToHSV)
-		
-		blurred := gocv.NewMat()
-		defer blurred.Close()
-		gocv.GaussianBlur(hsv, &blurred, image.Point{5, 5}, 0, 0, gocv.BorderDefault)
-		
-		satMask := gocv.NewMat()
-		defer satMask.Close()
-		lower := gocv.NewScalar(0, 0, 0, 0)
-		upper := gocv.NewScalar(255, 7, 255, 0)
-		gocv.InRangeWithScalar(blurred, lower, upper, &satMask)
-		
-		combined := gocv.NewMat()
-		gocv.BitwiseOr(dilated, satMask, &combined)
-		dilated.Close()
-		
-		// Flip to create keep mask
-		final := gocv.NewMat()
-		gocv.BitwiseNot(combined, &final)
-		combined.Close()
-		return final
-	}
-	
-	// Flip to create keep mask
-	final := gocv.NewMat()
-	gocv.BitwiseNot(dilated, &final)
-	return final
+	return fmt.Sprintf("cropped image to %d%% -> %s%s", pct, outPath, formatNote(results, cfg)), []string{outPath}, nil
 }
 
-func findLargestContourRect(binary gocv.Mat) (*RotatedRect, float64) {
-	contours := gocv.FindContours(binary, gocv.RetrievalExternal, gocv.ChainApproxSimple)
-	defer contours.Close()
-	
-	var largestArea float64
-	var largestRect *RotatedRect
-	
-	for i := 0; i < contours.Size(); i++ {
-		contour := contours.At(i)
-		area := gocv.ContourArea(contour)
-		
-		if area > largestArea {
-			largestArea = area
-			rotRect := gocv.MinAreaRect(contour)
-			largestRect = &RotatedRect{
-				Center: Point2f{X: float32(rotRect.Center.X), Y: float32(rotRect.Center.Y)},
-				Size:   Point2f{X: float32(rotRect.Width), Y: float32(rotRect.Height)},
-				Angle:  rotRect.Angle,
+// writeStrip writes each frame of a film strip to its own numbered file
+func writeStrip(detector *filmcrop.Detector, in input, img gocv.Mat, results []filmcrop.Result, cfg runConfig) (string, []string, error) {
+	filename := in.path
+	if cfg.dryRun {
+		return fmt.Sprintf("would split into %d frames (%s)%s", len(results), filepath.Base(filename), formatNote(results, cfg)), nil, nil
+	}
+
+	basePath, err := outputPath(in, cfg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var written []string
+	lossless := 0
+	outputs := make([]string, len(results))
+	for i, res := range results {
+		outPath := framePath(basePath, i+1)
+		if cfg.lossless {
+			ok, err := writeLossless(outPath, filename, img, res, cfg)
+			if err != nil {
+				return "", outputs, err
+			}
+			if ok {
+				outputs[i] = outPath
+				written = append(written, outPath)
+				lossless++
+				continue
 			}
 		}
-		contour.Close()
-	}
-	
-	return largestRect, largestArea
-}
 
-func normalizeRectRotation(rawRects []*RotatedRect) []*RotatedRect {
-	var rects []*RotatedRect
-	for _, rect := range rawRects {
-		newRect := &RotatedRect{
-			Center: rect.Center,
-			Size:   rect.Size,
-			Angle:  rect.Angle,
+		cropped, err := cropImage(detector, img, res, cfg)
+		if err != nil {
+			return "", outputs, err
 		}
-		
-		if newRect.Angle < -45 {
-			newRect.Size = Point2f{X: rect.Size.Y, Y: rect.Size.X}
-			newRect.Angle = rect.Angle + 90
+		if cropped.Empty() {
+			cropped.Close()
+			continue
 		}
-		rects = append(rects, newRect)
+
+		err = writeImage(outPath, filename, cropped, cfg)
+		cropped.Close()
+		if err != nil {
+			return "", outputs, err
+		}
+		outputs[i] = outPath
+		written = append(written, outPath)
+	}
+
+	note := ""
+	if lossless > 0 {
+		note = fmt.Sprintf(" (%d lossless)", lossless)
 	}
-	return rects
+	return fmt.Sprintf("split into %d frames%s -> %s%s", len(written), note, strings.Join(written, ", "), formatNote(results, cfg)), outputs, nil
 }
 
-func medianRect(rects []*RotatedRect) *RotatedRect {
-	if len(rects) == 0 {
-		return nil
-	}
-	
-	normalized := normalizeRectRotation(rects)
-	
-	// Sort by area
-	sort.Slice(normalized, func(i, j int) bool {
-		areaI := float64(normalized[i].Size.X * normalized[i].Size.Y)
-		areaJ := float64(normalized[j].Size.X * normalized[j].Size.Y)
-		return areaI < areaJ
-	})
-	
-	// Calculate medians
-	var centerX, centerY, sizeX, sizeY, angles []float64
-	for _, r := range normalized {
-		centerX = append(centerX, float64(r.Center.X))
-		centerY = append(centerY, float64(r.Center.Y))
-		sizeX = append(sizeX, float64(r.Size.X))
-		sizeY = append(sizeY, float64(r.Size.Y))
-		angles = append(angles, r.Angle)
-	}
-	
-	return &RotatedRect{
-		Center: Point2f{X: float32(median(centerX)), Y: float32(median(centerY))},
-		Size:   Point2f{X: float32(median(sizeX)), Y: float32(median(sizeY))},
-		Angle:  median(angles),
+// formatNote names the formats chosen by --format auto for the progress line
+func formatNote(results []filmcrop.Result, cfg runConfig) string {
+	if !cfg.autoFormat {
+		return ""
+	}
+	names := make([]string, len(results))
+	for i, res := range results {
+		names[i] = res.Format
 	}
+	return " [" + strings.Join(names, ", ") + "]"
 }
 
-func correctAspectRatio(rect *RotatedRect, targetRatio, maxDifference float64) (*RotatedRect, bool) {
-	size := rect.Size
-	aspectRatio := math.Max(float64(size.X), float64(size.Y)) / math.Min(float64(size.X), float64(size.Y))
-	aspectError := targetRatio - aspectRatio
-	
-	// Factor out orientation to simplify logic
-	var rectWidth, rectHeight float32
-	var widthIsX bool
-	
-	if size.X == math.Max(float64(size.X), float64(size.Y)) {
-		rectWidth = size.X
-		rectHeight = size.Y
-		widthIsX = true
-	} else {
-		rectHeight = size.X
-		rectWidth = size.Y
-		widthIsX = false
-	}
-	
-	// Only attempt to correct aspect ratio where the ROI is roughly right already
-	if math.Abs(aspectError) > maxDifference {
-		return rect, false
-	}
-	
-	// Adjust dimensions
-	if aspectRatio > targetRatio {
-		if verbose {
-			fmt.Fprintf(os.Stderr, "ratio too large %f\n", aspectError)
-		}
-		rectWidth = rectHeight * float32(targetRatio)
-	} else if aspectRatio < targetRatio {
-		if verbose {
-			fmt.Fprintf(os.Stderr, "ratio too small %f\n", aspectError)
-		}
-		rectHeight = rectWidth / float32(targetRatio)
+// framePath numbers a strip frame by inserting _NN before the extension
+func framePath(path string, frame int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s_%02d%s", strings.TrimSuffix(path, ext), frame, ext)
+}
+
+// cropImage cuts the detected frame out of img, either as an axis-aligned
+// region or, with deskew or perspective, as a straightened copy. With
+// invert, negative frames come out as positives.
+func cropImage(detector *filmcrop.Detector, img gocv.Mat, res filmcrop.Result, cfg runConfig) (gocv.Mat, error) {
+	cropped, err := cutFrame(detector, img, res, cfg)
+	if err != nil || cropped.Empty() || !cfg.invert || res.Base == nil {
+		return cropped, err
 	}
-	
-	// Apply new width/height in the original orientation
-	var newSize Point2f
-	if widthIsX {
-		newSize = Point2f{X: rectWidth, Y: rectHeight}
-	} else {
-		newSize = Point2f{X: rectHeight, Y: rectWidth}
+
+	defer cropped.Close()
+	return filmcrop.InvertNegative(cropped, *res.Base, cfg.balance)
+}
+
+func cutFrame(detector *filmcrop.Detector, img gocv.Mat, res filmcrop.Result, cfg runConfig) (gocv.Mat, error) {
+	if cfg.deskew {
+		return detector.Deskew(img, res, cfg.interpolation)
 	}
-	
-	newRect := &RotatedRect{
-		Center: rect.Center,
-		Size:   newSize,
-		Angle:  rect.Angle,
+	if cfg.perspective {
+		return detector.Rectify(img, res, cfg.interpolation)
+	}
+
+	rect := res.Crop.Pixels(img.Cols(), img.Rows())
+	if verbose {
+		fmt.Fprintf(os.Stderr, "crop px (x0,x1,y0,y1)= %d %d %d %d\n", rect.Min.X, rect.Max.X, rect.Min.Y, rect.Max.Y)
 	}
-	
-	return newRect, true
+	if rect.Empty() {
+		return gocv.NewMat(), nil
+	}
+	return img.Region(rect), nil
 }
 
-func calculateCropCoordinates(rect *RotatedRect, imgHeight, imgWidth int) (float64, float64, float64, float64) {
-	// Get box points from rotated rectangle
-	cos := math.Cos(rect.Angle * math.Pi / 180)
-	sin := math.Sin(rect.Angle * math.Pi / 180)
-	
-	halfW := float64(rect.Size.X) / 2
-	halfH := float64(rect.Size.Y) / 2
-	
-	cx := float64(rect.Center.X)
-	cy := float64(rect.Center.Y)
-	
-	// Calculate the four corners of the rotated rectangle
-	points := []image.Point{
-		{X: int(cx + halfW*cos - halfH*sin), Y: int(cy + halfW*sin + halfH*cos)},
-		{X: int(cx - halfW*cos - halfH*sin), Y: int(cy - halfW*sin + halfH*cos)},
-		{X: int(cx - halfW*cos + halfH*sin), Y: int(cy - halfW*sin - halfH*cos)},
-		{X: int(cx + halfW*cos + halfH*sin), Y: int(cy + halfW*sin - halfH*cos)},
-	}
-	
-	// Find bounding box
-	var left, right, top, bottom []int
-	for _, point := range points {
-		if float64(point.X) > cx {
-			right = append(right, point.X)
-		} else {
-			left = append(left, point.X)
+// sampleBases records the film base of every negative frame in results, so
+// cropImage can invert it. Positive frames are left as they are.
+func sampleBases(detector *filmcrop.Detector, img gocv.Mat, results []filmcrop.Result) error {
+	for i := range results {
+		if results[i].Polarity != "negative" {
+			continue
 		}
-		
-		if float64(point.Y) > cy {
-			bottom = append(bottom, point.Y)
-		} else {
-			top = append(top, point.Y)
+		base, err := detector.SampleBase(img, results[i])
+		if err != nil {
+			return err
 		}
+		results[i].Base = &base
 	}
-	
-	cropRight := float64(minInt(right)) / float64(imgWidth)
-	cropLeft := float64(maxInt(left)) / float64(imgWidth)
-	cropBottom := float64(minInt(bottom)) / float64(imgHeight)
-	cropTop := float64(maxInt(top)) / float64(imgHeight)
-	
-	return cropLeft, cropRight, cropTop, cropBottom
+	return nil
 }
 
-func enforce32AspectRatio(cropLeft, cropRight, cropTop, cropBottom float64, imgWidth, imgHeight int) (float64, float64, float64, float64) {
-	// Convert normalized crop bounds to pixel units
-	x0 := cropLeft * float64(imgWidth)
-	x1 := cropRight * float64(imgWidth)
-	y0 := cropTop * float64(imgHeight)
-	y1 := cropBottom * float64(imgHeight)
-	
-	// Current crop width/height in pixels
-	w := math.Max(0.0, x1-x0)
-	h := math.Max(0.0, y1-y0)
-	if w <= 0.0 || h <= 0.0 {
-		return cropLeft, cropRight, cropTop, cropBottom
-	}
-	
-	r := w / h
-	r32 := 3.0 / 2.0
-	r23 := 2.0 / 3.0
-	
-	// Choose the nearest target ratio
-	var target float64
-	if math.Abs(r-r32) <= math.Abs(r-r23) {
-		target = r32
-	} else {
-		target = r23
-	}
-	
-	// Option A: keep height, reduce width to target
-	wKeepH := math.Min(w, target*h)
-	areaA := wKeepH * h
-	
-	// Option B: keep width, reduce height to target
-	hKeepW := math.Min(h, w/target)
-	areaB := w * hKeepW
-	
-	// Pick option that preserves larger area
-	var decision string
-	if areaA >= areaB {
-		newW := wKeepH
-		deltaW := w - newW
-		x0 += deltaW / 2.0
-		x1 -= deltaW / 2.0
-		decision = "reduce-width"
-	} else {
-		newH := hKeepW
-		deltaH := h - newH
-		y0 += deltaH / 2.0
-		y1 -= deltaH / 2.0
-		decision = "reduce-height"
-	}
-	
-	// Convert back to normalized [0,1]
-	cropLeft = math.Max(0.0, math.Min(1.0, x0/float64(imgWidth)))
-	cropRight = math.Max(0.0, math.Min(1.0, x1/float64(imgWidth)))
-	cropTop = math.Max(0.0, math.Min(1.0, y0/float64(imgHeight)))
-	cropBottom = math.Max(0.0, math.Min(1.0, y1/float64(imgHeight)))
-	
-	if verbose {
-		newWPx := math.Max(0.0, (cropRight-cropLeft)*float64(imgWidth))
-		newHPx := math.Max(0.0, (cropBottom-cropTop)*float64(imgHeight))
-		var newR float64
-		if newHPx == 0 {
-			newR = math.Inf(1)
-		} else {
-			newR = newWPx / newHPx
+// writeImage encodes img to outPath and, unless disabled, copies the
+// metadata of the source file across
+func writeImage(outPath, source string, img gocv.Mat, cfg runConfig) error {
+	// Read metadata first, as outPath may be the source itself
+	var meta *metadata.Metadata
+	if cfg.metadata {
+		var err error
+		meta, err = metadata.Read(source)
+		if err != nil && verbose {
+			fmt.Fprintf(os.Stderr, "no metadata copied from %s: %v\n", source, err)
 		}
-		fmt.Fprintf(os.Stderr, "aspect enforce (px): current= %f target= %f decision= %s new_ratio= %f\n", r, target, decision, newR)
 	}
-	return cropLeft, cropRight, cropTop, cropBottom
-}
 
-func shrinkCropUniform(cropLeft, cropRight, cropTop, cropBottom, percent float64) (float64, float64, float64, float64) {
-	width := math.Max(0.0, cropRight-cropLeft)
-	height := math.Max(0.0, cropBottom-cropTop)
-	if width <= 0.0 || height <= 0.0 {
-		return cropLeft, cropRight, cropTop, cropBottom
-	}
-	
-	scale := math.Max(0.0, 1.0-percent)
-	cx := (cropLeft + cropRight) / 2.0
-	cy := (cropTop + cropBottom) / 2.0
-	halfW := (width * scale) / 2.0
-	halfH := (height * scale) / 2.0
-	
-	newLeft := cx - halfW
-	newRight := cx + halfW
-	newTop := cy - halfH
-	newBottom := cy + halfH
-	
-	// Clamp
-	newLeft = math.Max(0.0, math.Min(1.0, newLeft))
-	newRight = math.Max(0.0, math.Min(1.0, newRight))
-	newTop = math.Max(0.0, math.Min(1.0, newTop))
-	newBottom = math.Max(0.0, math.Min(1.0, newBottom))
-	
-	return newLeft, newRight, newTop, newBottom
-}
+	ok := gocv.IMWrite(outPath, img)
+	if verbose {
+		fmt.Fprintf(os.Stderr, "wrote cropped: %v %s\n", ok, outPath)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", filmcrop.ErrWriteFailed, outPath)
+	}
 
-func drawDebugOverlays(img gocv.Mat, rawRect, insetRect, rect *RotatedRect) {
-	// Draw original detected area in blue
-	drawRotatedRect(img, rawRect, color.RGBA{255, 0, 0, 255}, 1)
-	
-	// Draw inset area in cyan
-	drawRotatedRect(img, insetRect, color.RGBA{0, 255, 255, 255}, 1)
-	
-	// Draw adjusted aspect ratio area in green
-	drawRotatedRect(img, rect, color.RGBA{0, 255, 0, 255}, 2)
-	
-	// Draw center point
-	center := image.Point{X: int(rect.Center.X), Y: int(rect.Center.Y)}
-	gocv.Circle(&img, center, 3, color.RGBA{0, 255, 0, 255}, 3)
+	if meta != nil {
+		if err := meta.Apply(outPath, img.Cols(), img.Rows()); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Could not copy metadata to '%s': %v\n", outPath, err)
+		}
+	}
+	return nil
 }
 
-func drawRotatedRect(img gocv.Mat, rect *RotatedRect, clr color.RGBA, thickness int) {
-	if rect == nil {
-		return
-	}
-	
-	// Calculate the four corners of the rotated rectangle
-	cos := math.Cos(rect.Angle * math.Pi / 180)
-	sin := math.Sin(rect.Angle * math.Pi / 180)
-	
-	halfW := float64(rect.Size.X) / 2
-	halfH := float64(rect.Size.Y) / 2
-	
-	cx := float64(rect.Center.X)
-	cy := float64(rect.Center.Y)
-	
-	points := []image.Point{
-		{X: int(cx + halfW*cos - halfH*sin), Y: int(cy + halfW*sin + halfH*cos)},
-		{X: int(cx - halfW*cos - halfH*sin), Y: int(cy - halfW*sin + halfH*cos)},
-		{X: int(cx - halfW*cos + halfH*sin), Y: int(cy - halfW*sin - halfH*cos)},
-		{X: int(cx + halfW*cos + halfH*sin), Y: int(cy + halfW*sin - halfH*cos)},
-	}
-	
-	// Draw lines between consecutive points
-	for i := 0; i < len(points); i++ {
-		start := points[i]
-		end := points[(i+1)%len(points)]
-		gocv.Line(&img, start, end, clr, thickness)
+// writeLossless writes the frame of res in the JPEG file source to outPath
+// without re-encoding it. img is the upright image res was detected on; the
+// EXIF orientation of source is applied as a lossless rotation. It returns
+// false, having written nothing, when source cannot be cropped losslessly
+// and the frame must be re-encoded instead.
+func writeLossless(outPath, source string, img gocv.Mat, res filmcrop.Result, cfg runConfig) (bool, error) {
+	rect := res.Crop.Pixels(img.Cols(), img.Rows())
+	if rect.Empty() {
+		return false, nil
 	}
-}
 
-func writeCropData(filename string, data []float64) {
-	file, err := os.Create(filename)
+	// Read everything first, as outPath may be the source itself
+	src, err := os.ReadFile(source)
 	if err != nil {
+		return false, err
+	}
+	orientation := 1
+	meta, err := metadata.Read(source)
+	if err == nil {
+		orientation = meta.Orientation()
+	} else if verbose {
+		fmt.Fprintf(os.Stderr, "no metadata read from %s: %v\n", source, err)
+	}
+
+	out, got, err := jpegcrop.Crop(src, jpegcrop.Options{
+		Transform:    jpegcrop.ForOrientation(orientation),
+		Rect:         rect,
+		Snap:         cfg.snap,
+		StripMarkers: !cfg.metadata,
+	})
+	if errors.Is(err, jpegcrop.ErrUnsupported) {
 		if verbose {
-			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", filename, err)
+			fmt.Fprintf(os.Stderr, "re-encoding %s: %v\n", source, err)
 		}
-		return
+		return false, nil
 	}
-	defer file.Close()
-	
-	for _, value := range data {
-		fmt.Fprintf(file, "%f\r\n", value)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", err, source)
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "lossless crop px (x0,x1,y0,y1)= %d %d %d %d\n", got.Min.X, got.Max.X, got.Min.Y, got.Max.Y)
 	}
-}
-
-// Utility functions
 
-func median(values []float64) float64 {
-	sorted := make([]float64, len(values))
-	copy(sorted, values)
-	sort.Float64s(sorted)
-	
-	n := len(sorted)
-	if n%2 == 0 {
-		return (sorted[n/2-1] + sorted[n/2]) / 2
+	tmp := outPath + ".tmp"
+	if err := os.WriteFile(tmp, out, 0644); err != nil {
+		return false, fmt.Errorf("%w: %v", filmcrop.ErrWriteFailed, err)
+	}
+	if err := os.Rename(tmp, outPath); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("%w: %v", filmcrop.ErrWriteFailed, err)
 	}
-	return sorted[n/2]
-}
 
-func min(a, b int) int {
-	if a < b {
-		return a
+	// The copied EXIF still holds the orientation of source
+	if cfg.metadata && meta != nil {
+		if err := meta.Apply(outPath, got.Dx(), got.Dy()); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Could not copy metadata to '%s': %v\n", outPath, err)
+		}
 	}
-	return b
+	return true, nil
 }
 
-func minInt(values []int) int {
-	if len(values) == 0 {
-		return 0
+// outputPath determines where the cropped copy of in is written:
+//   - with --overwrite, the input file itself
+//   - with --output-dir, in.rel below the (already absolute) output folder,
+//     creating any missing subfolders
+//   - otherwise next to the input with a _cropped suffix
+func outputPath(in input, cfg runConfig) (string, error) {
+	if cfg.overwrite {
+		return in.path, nil
 	}
-	min := values[0]
-	for _, v := range values[1:] {
-		if v < min {
-			min = v
+
+	if cfg.outputDir != "" {
+		outPath := filepath.Join(cfg.outputDir, in.rel)
+		if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+			return "", fmt.Errorf("%w: %v", filmcrop.ErrWriteFailed, err)
 		}
+		return outPath, nil
 	}
-	return min
+
+	ext := filepath.Ext(in.path)
+	base := strings.TrimSuffix(in.path, ext)
+	return base + "_cropped" + ext, nil
 }
 
-func maxInt(values []int) int {
-	if len(values) == 0 {
-		return 0
+// processImage reads in and runs detection on it, returning one result per
+// frame. The returned Mat is always safe to Close, even when an error is
+// returned. Nothing is written next to the file except the crop data with
+// keepCropData; the analysis overlay of a file held back for review goes
+// straight into the review folder.
+func processImage(detector *filmcrop.Detector, in input, cfg runConfig) (gocv.Mat, []filmcrop.Result, error) {
+	filename := in.path
+
+	// Keep the master at its stored depth and detect on an 8-bit copy
+	img, err := filmcrop.ReadImage(filename)
+	if err != nil {
+		return img, nil, err
+	}
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "file= %s\n", filename)
+		fmt.Fprintf(os.Stderr, "image.shape= %dx%dx%d dtype= %v\n", img.Rows(), img.Cols(), img.Channels(), img.Type())
+	}
+
+	proxy, err := filmcrop.DetectionProxy(img)
+	if err != nil {
+		return img, nil, err
+	}
+	defer proxy.Close()
+
+	var results []filmcrop.Result
+	if stored, ok := cfg.roll.stored(filename); ok {
+		// Detected in the first pass of a roll
+		results = cfg.roll.adjust(detector, proxy, stored)
+	} else if cfg.strip {
+		results, err = detector.DetectStrip(proxy)
+	} else {
+		var res filmcrop.Result
+		res, err = detector.Detect(proxy)
+		results = []filmcrop.Result{res}
+	}
+	if err != nil {
+		return img, nil, err
+	}
+
+	// Keep the results for a later apply run, five lines per frame
+	if cfg.keepCropData && !cfg.detectOnly {
+		writeCropData(filename+".txt", cropData(results))
+	}
+
+	if !cfg.showWindows && !cfg.copiesOverlay(results) {
+		return img, results, nil
 	}
-	max := values[0]
-	for _, v := range values[1:] {
-		if v > max {
-			max = v
+
+	// Draw debug overlays
+	debugImg := proxy.Clone()
+	defer debugImg.Close()
+	for _, res := range results {
+		filmcrop.DrawOverlay(debugImg, res)
+	}
+
+	if cfg.copiesOverlay(results) {
+		if err := writeOverlay(reviewPath(in, cfg)+"-analysis.jpg", debugImg); err != nil {
+			return img, nil, err
 		}
 	}
-	return max
+
+	if cfg.showWindows {
+		window := gocv.NewWindow("image")
+		defer window.Close()
+
+		resized := gocv.NewMat()
+		defer resized.Close()
+		gocv.Resize(debugImg, &resized, image.Point{}, 0.75, 0.75, gocv.InterpolationLinear)
+
+		window.IMShow(resized)
+		window.WaitKey(0)
+	}
+
+	return img, results, nil
 }
 
-func fileExists(filename string) bool {
-	_, err := os.Stat(filename)
-	return !os.IsNotExist(err)
+// copiesOverlay reports whether results will be copied for review along
+// with their analysis overlay
+func (cfg runConfig) copiesOverlay(results []filmcrop.Result) bool {
+	return !cfg.detectOnly && !cfg.dryRun && cfg.reviewDir != "" && cfg.needsReview(results)
 }
 
-func isDir(path string) bool {
-	info, err := os.Stat(path)
-	return err == nil && info.IsDir()
+// writeOverlay writes the analysis overlay to path, creating its folder
+func writeOverlay(path string, overlay gocv.Mat) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("%w: %v", filmcrop.ErrWriteFailed, err)
+	}
+	if !gocv.IMWrite(path, overlay) {
+		return fmt.Errorf("%w: %s", filmcrop.ErrWriteFailed, path)
+	}
+	return nil
 }
 
-func expandDirectory(dir string) ([]string, error) {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, err
+// cropData flattens results into the LRTB + rotation values printed per frame
+func cropData(results []filmcrop.Result) []float64 {
+	var data []float64
+	for _, res := range results {
+		data = append(data, res.Crop.Left, res.Crop.Right, res.Crop.Top, res.Crop.Bottom, res.Rotation)
 	}
-	
-	var imageFiles []string
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		
-		path := filepath.Join(dir, entry.Name())
-		if isImageFile(path) {
-			imageFiles = append(imageFiles, path)
+	return data
+}
+
+func writeCropData(filename string, data []float64) {
+	file, err := os.Create(filename)
+	if err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", filename, err)
 		}
+		return
 	}
-	
-	return imageFiles, nil
-}
+	defer file.Close()
 
-func isImageFile(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" || 
-		   ext == ".tif" || ext == ".tiff" || ext == ".bmp" || ext == ".webp"
-}
\ No newline at end of file
+	for _, value := range data {
+		fmt.Fprintf(file, "%f\r\n", value)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"path/filepath"
+	"strings"
+
+	"film-crop-detector/filmcrop"
+	"film-crop-detector/jpegcrop"
+)
+
+// detectFlags are the detector flags shared by every command that finds
+// frames
+type detectFlags struct {
+	format    string
+	film      string
+	detector  string
+	proxySize int
+	refine    bool
+	quad      bool
+}
+
+// addDetectFlags registers the detector flags on fs
+func addDetectFlags(fs *flag.FlagSet) *detectFlags {
+	f := &detectFlags{}
+	fs.BoolVar(&verbose, "verbose", false, "Print debug information")
+	fs.StringVar(&f.format, "format", "135", "Film format: "+strings.Join(filmcrop.FormatNames(), ", "))
+	fs.StringVar(&f.film, "film", "auto", "Film type, or auto to classify each scan: "+strings.Join(filmcrop.FilmTypeNames(), ", "))
+	fs.StringVar(&f.detector, "detector", "threshold", "Frame detection algorithm: "+strings.Join(filmcrop.FrameDetectorNames(), ", ")+" (ensemble runs the others and votes)")
+	fs.IntVar(&f.proxySize, "proxy-size", 0, "Detect on a copy downsampled to this long edge in pixels (0 = full resolution)")
+	fs.BoolVar(&f.refine, "refine", false, "Refine the edges of a frame found with --proxy-size at full resolution")
+	fs.BoolVar(&f.quad, "quad", false, "Fit each side of the frame on its own to a fraction of a pixel, following keystone or a bowed holder")
+	return f
+}
+
+// options returns the detector options selected by the flags
+func (f *detectFlags) options() (filmcrop.Options, error) {
+	opts := filmcrop.DefaultOptions()
+	var err error
+	if opts.Format, err = filmcrop.LookupFormat(f.format); err != nil {
+		return opts, err
+	}
+	if opts.FilmType, err = filmcrop.ParseFilmType(f.film); err != nil {
+		return opts, err
+	}
+	if opts.FrameDetector, err = filmcrop.LookupFrameDetector(f.detector); err != nil {
+		return opts, err
+	}
+	opts.Verbose = verbose
+	opts.ProxyLongEdge = f.proxySize
+	opts.RefineEdges = f.refine
+	opts.RefineQuad = f.quad
+	return opts, nil
+}
+
+// cropFlags are the flags shared by the commands that crop or detect files:
+// crop, detect, watch and the compatible default. Flags that only make sense
+// for one of them are registered by that command.
+type cropFlags struct {
+	*detectFlags
+	cfg           runConfig
+	write         bool
+	enforceAspect bool
+	interpolation string
+	balance       string
+	snap          string
+}
+
+// addCropFlags registers the crop flags on fs; the flags that write images
+// and sidecars only when write is set
+func addCropFlags(fs *flag.FlagSet, write bool) *cropFlags {
+	f := &cropFlags{detectFlags: addDetectFlags(fs), write: write}
+	cfg := &f.cfg
+
+	// Detection
+	fs.BoolVar(&f.enforceAspect, "enforce-aspect", false, "Enforce the exact aspect ratio of --format")
+	stripHelp := "Split a film strip scan into one numbered file per frame"
+	if !write {
+		stripHelp = "Find every frame on a film strip scan"
+	}
+	fs.BoolVar(&cfg.strip, "strip", false, stripHelp)
+	confidenceHelp := "Leave files whose detection confidence (0-1) is below this cutoff uncropped for review (0 = crop everything)"
+	if !write {
+		confidenceHelp = "Mark files whose detection confidence (0-1) is below this cutoff for review"
+	}
+	fs.Float64Var(&cfg.minConfidence, "min-confidence", 0, confidenceHelp)
+
+	// Output
+	if write {
+		fs.StringVar(&cfg.outputDir, "output-dir", "", "Output directory for processed images, mirroring the layout of input folders")
+		fs.BoolVar(&cfg.xmp, "xmp", false, "Write the crop to a Lightroom XMP sidecar instead of cropping pixels")
+		fs.BoolVar(&cfg.metadata, "metadata", true, "Copy EXIF, ICC profile and XMP metadata into cropped output")
+		fs.BoolVar(&cfg.keepCropData, "keep-crop-data", false, "Write the crop data to <image>.txt for a later apply run")
+		fs.BoolVar(&cfg.deskew, "deskew", false, "Rotate the frame level before cropping")
+		fs.BoolVar(&cfg.perspective, "perspective", false, "Warp the frame square, undoing perspective as well as rotation, for camera scans (implies --quad)")
+		fs.StringVar(&f.interpolation, "interpolation", "cubic", "Interpolation used by --deskew and --perspective: nearest, linear, cubic, area or lanczos")
+		fs.BoolVar(&cfg.invert, "invert", false, "Convert negatives to positives, removing the film base sampled around each frame")
+		fs.StringVar(&f.balance, "balance", "levels", "Levels of inverted negatives: levels, auto (levels and gray world white balance) or none")
+		fs.BoolVar(&cfg.lossless, "lossless", false, "Crop JPEG input on its 8 or 16 pixel block grid without re-encoding; other input is re-encoded")
+		fs.StringVar(&f.snap, "lossless-snap", "outward", "How --lossless moves the left and top edges onto the block grid: outward (keep all of the frame) or inward (keep nothing outside it)")
+		fs.StringVar(&cfg.reviewDir, "review-dir", "", "Copy files left for review and their analysis overlay into this folder")
+	}
+
+	// Inputs
+	fs.IntVar(&cfg.jobs, "jobs", 1, "Number of images to process in parallel (0 = one per CPU)")
+	fs.Int64Var(&cfg.maxMemory, "max-memory", 0, "Memory budget in MB for images in flight (0 = half of available memory)")
+	return f
+}
+
+// config checks the parsed flags against each other and returns the run
+// config with absolute output folders, and the detector options
+func (f *cropFlags) config() (runConfig, filmcrop.Options, error) {
+	cfg := f.cfg
+	cfg.detectOnly = !f.write
+
+	if cfg.minConfidence < 0 || cfg.minConfidence > 1 {
+		return cfg, filmcrop.Options{}, errors.New("--min-confidence must be between 0 and 1")
+	}
+	if cfg.reviewDir != "" && cfg.minConfidence == 0 {
+		return cfg, filmcrop.Options{}, errors.New("--review-dir needs --min-confidence")
+	}
+	if cfg.strip && cfg.xmp {
+		return cfg, filmcrop.Options{}, errors.New("--strip cannot be combined with --xmp")
+	}
+	if cfg.invert && cfg.xmp {
+		return cfg, filmcrop.Options{}, errors.New("--invert cannot be combined with --xmp")
+	}
+	if cfg.lossless && (cfg.deskew || cfg.perspective || cfg.invert || cfg.xmp) {
+		return cfg, filmcrop.Options{}, errors.New("--lossless cannot be combined with --deskew, --perspective, --invert or --xmp")
+	}
+	if cfg.perspective && (cfg.deskew || cfg.xmp) {
+		return cfg, filmcrop.Options{}, errors.New("--perspective cannot be combined with --deskew or --xmp")
+	}
+
+	// Relative output folders are relative to the working directory
+	for _, dir := range []*string{&cfg.outputDir, &cfg.reviewDir} {
+		if *dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			return cfg, filmcrop.Options{}, err
+		}
+		*dir = abs
+	}
+
+	var err error
+	if f.write {
+		if cfg.interpolation, err = filmcrop.ParseInterpolation(f.interpolation); err != nil {
+			return cfg, filmcrop.Options{}, err
+		}
+		if cfg.balance, err = filmcrop.ParseBalance(f.balance); err != nil {
+			return cfg, filmcrop.Options{}, err
+		}
+		if cfg.snap, err = jpegcrop.ParseSnap(f.snap); err != nil {
+			return cfg, filmcrop.Options{}, err
+		}
+	}
+
+	opts, err := f.options()
+	if err != nil {
+		return cfg, opts, err
+	}
+	cfg.autoFormat = opts.Format == filmcrop.Auto
+	opts.EnforceAspect = f.enforceAspect
+	opts.ShowWindows = cfg.showWindows
+	opts.RefineQuad = f.quad || cfg.perspective
+	return cfg, opts, nil
+}
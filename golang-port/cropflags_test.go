@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCropFlagsConfig(t *testing.T) {
+	tests := []struct {
+		args  []string
+		write bool
+		err   string
+	}{
+		{nil, true, ""},
+		{[]string{"--strip", "--xmp"}, true, "--strip cannot be combined"},
+		{[]string{"--invert", "--xmp"}, true, "--invert cannot be combined"},
+		{[]string{"--lossless", "--deskew"}, true, "--lossless cannot be combined"},
+		{[]string{"--perspective", "--deskew"}, true, "--perspective cannot be combined"},
+		{[]string{"--min-confidence", "2"}, false, "--min-confidence must be"},
+		{[]string{"--review-dir", "review"}, true, "--review-dir needs"},
+		{[]string{"--format", "110"}, false, "unknown"},
+		{[]string{"--balance", "none", "--interpolation", "bogus"}, true, "interpolation"},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := addCropFlags(fs, tt.write)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		_, _, err := f.config()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%v: error = %v, want %q", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestCropFlagsConfigOutput(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := addCropFlags(fs, true)
+	fs.Parse([]string{"--output-dir", "out", "--perspective", "--min-confidence", "0.5", "--review-dir", "review"})
+	cfg, opts, err := f.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{cfg.outputDir, cfg.reviewDir} {
+		if !filepath.IsAbs(dir) {
+			t.Errorf("output folder %q is not absolute", dir)
+		}
+	}
+	if !opts.RefineQuad {
+		t.Error("--perspective does not imply --quad")
+	}
+	if cfg.detectOnly {
+		t.Error("crop flags run detection only")
+	}
+}
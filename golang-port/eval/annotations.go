@@ -0,0 +1,94 @@
+package eval
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Annotation is the expected frame on one scan, in pixels of the upright
+// image, that is after its EXIF orientation has been applied. The frame is
+// either four corners, or an upright box turned by Angle.
+type Annotation struct {
+	// File is the scan, relative to the annotation file
+	File string `json:"file"`
+
+	Corners []Point `json:"corners,omitempty"`
+
+	Left   *float64 `json:"left,omitempty"`
+	Right  *float64 `json:"right,omitempty"`
+	Top    *float64 `json:"top,omitempty"`
+	Bottom *float64 `json:"bottom,omitempty"`
+	// Angle turns the box clockwise on screen, in degrees
+	Angle float64 `json:"angle,omitempty"`
+
+	// path is File as found from the working directory
+	path string
+}
+
+// annotationFile is the layout of an annotation file
+type annotationFile struct {
+	Images []Annotation `json:"images"`
+}
+
+// ReadAnnotations reads an annotation file
+func ReadAnnotations(path string) ([]Annotation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f annotationFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(f.Images) == 0 {
+		return nil, fmt.Errorf("%s: no images annotated", path)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range f.Images {
+		a := &f.Images[i]
+		if a.File == "" {
+			return nil, fmt.Errorf("%s: image %d has no file", path, i+1)
+		}
+		if _, err := a.Quad(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, a.File, err)
+		}
+		a.path = a.File
+		if !filepath.IsAbs(a.File) {
+			a.path = filepath.Join(dir, a.File)
+		}
+	}
+	return f.Images, nil
+}
+
+// Path returns the scan file as found from the working directory
+func (a Annotation) Path() string {
+	if a.path == "" {
+		return a.File
+	}
+	return a.path
+}
+
+// Quad returns the expected frame outline
+func (a Annotation) Quad() (Quad, error) {
+	box := a.Left != nil || a.Right != nil || a.Top != nil || a.Bottom != nil
+	switch {
+	case len(a.Corners) > 0 && box:
+		return Quad{}, errors.New("give either corners or left, right, top and bottom")
+	case len(a.Corners) > 0:
+		if len(a.Corners) != 4 {
+			return Quad{}, fmt.Errorf("%d corners instead of 4", len(a.Corners))
+		}
+		return NewQuad([4]Point(a.Corners)), nil
+	case a.Left != nil && a.Right != nil && a.Top != nil && a.Bottom != nil:
+		if *a.Right <= *a.Left || *a.Bottom <= *a.Top {
+			return Quad{}, errors.New("empty box")
+		}
+		return BoxQuad(*a.Left, *a.Top, *a.Right, *a.Bottom, a.Angle), nil
+	}
+	return Quad{}, errors.New("no corners or incomplete left, right, top and bottom")
+}
@@ -0,0 +1,215 @@
+// Package eval scores detected frames against annotated ground truth and
+// compares the scores of a run with a stored baseline, so that changes to
+// detection can be measured and regressions caught.
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Edges holds one value per side of a frame
+type Edges struct {
+	Left   float64 `json:"left"`
+	Top    float64 `json:"top"`
+	Right  float64 `json:"right"`
+	Bottom float64 `json:"bottom"`
+}
+
+// Max returns the largest of the four values
+func (e Edges) Max() float64 {
+	return math.Max(math.Max(e.Left, e.Top), math.Max(e.Right, e.Bottom))
+}
+
+// Score measures one detection against its annotation
+type Score struct {
+	File string `json:"file"`
+	// Error is why detection failed; the other fields are then zero
+	Error string `json:"error,omitempty"`
+
+	IoU float64 `json:"iou"`
+	// EdgeError is the mean distance in pixels of the ends of each detected
+	// side from the annotated side
+	EdgeError Edges `json:"edge_error_px"`
+	// AngleError is the detected minus the annotated angle in degrees
+	AngleError float64 `json:"angle_error_deg"`
+}
+
+// Failed reports whether detection failed
+func (s Score) Failed() bool {
+	return s.Error != ""
+}
+
+// Evaluate scores the frame detected on the scan of a, or the error
+// detection returned
+func Evaluate(a Annotation, detected Quad, err error) Score {
+	s := Score{File: a.File}
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	want, err := a.Quad()
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+
+	s.IoU = IoU(want, detected)
+	got := detected.edges()
+	for i, e := range want.edges() {
+		d := (lineDistance(got[i][0], e[0], e[1]) + lineDistance(got[i][1], e[0], e[1])) / 2
+		switch i {
+		case 0:
+			s.EdgeError.Left = d
+		case 1:
+			s.EdgeError.Top = d
+		case 2:
+			s.EdgeError.Right = d
+		case 3:
+			s.EdgeError.Bottom = d
+		}
+	}
+	s.AngleError = detected.Angle() - want.Angle()
+	return s
+}
+
+// Summary aggregates the scores of a run. Failed detections count towards
+// Failures only.
+type Summary struct {
+	Images   int `json:"images"`
+	Failures int `json:"failures"`
+
+	MeanIoU float64 `json:"mean_iou"`
+	MinIoU  float64 `json:"min_iou"`
+	// MeanEdgeError and MaxEdgeError are over all four sides of every frame
+	MeanEdgeError float64 `json:"mean_edge_error_px"`
+	MaxEdgeError  float64 `json:"max_edge_error_px"`
+	// MeanAngleError and MaxAngleError are of the absolute angle error
+	MeanAngleError float64 `json:"mean_angle_error_deg"`
+	MaxAngleError  float64 `json:"max_angle_error_deg"`
+}
+
+// Summarize aggregates scores
+func Summarize(scores []Score) Summary {
+	sum := Summary{Images: len(scores)}
+	n := 0
+	for _, s := range scores {
+		if s.Failed() {
+			sum.Failures++
+			continue
+		}
+		if n == 0 || s.IoU < sum.MinIoU {
+			sum.MinIoU = s.IoU
+		}
+		n++
+		e := s.EdgeError
+		angle := math.Abs(s.AngleError)
+		sum.MeanIoU += s.IoU
+		sum.MeanEdgeError += (e.Left + e.Top + e.Right + e.Bottom) / 4
+		sum.MaxEdgeError = math.Max(sum.MaxEdgeError, e.Max())
+		sum.MeanAngleError += angle
+		sum.MaxAngleError = math.Max(sum.MaxAngleError, angle)
+	}
+	if n > 0 {
+		sum.MeanIoU /= float64(n)
+		sum.MeanEdgeError /= float64(n)
+		sum.MeanAngleError /= float64(n)
+	}
+	return sum
+}
+
+// Baseline is the stored result of a run that later runs must not fall
+// behind
+type Baseline struct {
+	Summary Summary `json:"summary"`
+	Scores  []Score `json:"scores"`
+}
+
+// NewBaseline returns the baseline for scores
+func NewBaseline(scores []Score) Baseline {
+	return Baseline{Summary: Summarize(scores), Scores: scores}
+}
+
+// ReadBaseline reads a baseline written by WriteBaseline
+func ReadBaseline(path string) (Baseline, error) {
+	var b Baseline
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return b, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// WriteBaseline writes b to path as indented JSON
+func WriteBaseline(path string, b Baseline) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Tolerance is how much worse than the baseline a score may be before it
+// counts as a regression
+type Tolerance struct {
+	IoU          float64
+	EdgePixels   float64
+	AngleDegrees float64
+}
+
+// DefaultTolerance absorbs the small differences between OpenCV builds
+var DefaultTolerance = Tolerance{IoU: 0.005, EdgePixels: 2, AngleDegrees: 0.1}
+
+// Regression is a score that fell behind the baseline
+type Regression struct {
+	File     string
+	Metric   string
+	Baseline float64
+	Current  float64
+}
+
+func (r Regression) String() string {
+	if r.Metric == "failed" {
+		return fmt.Sprintf("%s: detection now fails", r.File)
+	}
+	return fmt.Sprintf("%s: %s %.4g -> %.4g", r.File, r.Metric, r.Baseline, r.Current)
+}
+
+// Compare returns the scores that are worse than their baseline by more
+// than tol. Files the baseline does not know are new and are not compared.
+// A file that failed in the baseline never regresses, failed or not, since
+// there is no score to fall behind.
+func Compare(base Baseline, scores []Score, tol Tolerance) []Regression {
+	before := make(map[string]Score, len(base.Scores))
+	for _, s := range base.Scores {
+		before[s.File] = s
+	}
+
+	var regressions []Regression
+	for _, s := range scores {
+		b, ok := before[s.File]
+		if !ok || b.Failed() {
+			continue
+		}
+		if s.Failed() {
+			regressions = append(regressions, Regression{File: s.File, Metric: "failed"})
+			continue
+		}
+
+		if s.IoU < b.IoU-tol.IoU {
+			regressions = append(regressions, Regression{File: s.File, Metric: "iou", Baseline: b.IoU, Current: s.IoU})
+		}
+		if e, be := s.EdgeError.Max(), b.EdgeError.Max(); e > be+tol.EdgePixels {
+			regressions = append(regressions, Regression{File: s.File, Metric: "edge error px", Baseline: be, Current: e})
+		}
+		if a, ba := math.Abs(s.AngleError), math.Abs(b.AngleError); a > ba+tol.AngleDegrees {
+			regressions = append(regressions, Regression{File: s.File, Metric: "angle error deg", Baseline: ba, Current: a})
+		}
+	}
+	return regressions
+}
@@ -0,0 +1,205 @@
+package eval
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func near(a, b, eps float64) bool {
+	return math.Abs(a-b) <= eps
+}
+
+func box(left, top, right, bottom float64) Quad {
+	return BoxQuad(left, top, right, bottom, 0)
+}
+
+func TestNewQuadOrdersCorners(t *testing.T) {
+	q := NewQuad([4]Point{{10, 90}, {100, 0}, {0, 0}, {110, 100}})
+	want := Quad{{0, 0}, {100, 0}, {110, 100}, {10, 90}}
+	if q != want {
+		t.Errorf("NewQuad = %v, want %v", q, want)
+	}
+}
+
+func TestIoU(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Quad
+		want float64
+	}{
+		{"identical", box(0, 0, 100, 50), box(0, 0, 100, 50), 1},
+		{"disjoint", box(0, 0, 10, 10), box(20, 0, 30, 10), 0},
+		{"half shifted", box(0, 0, 100, 100), box(50, 0, 150, 100), 1.0 / 3},
+		{"contained", box(0, 0, 100, 100), box(25, 25, 75, 75), 0.25},
+		{"rotated copy", BoxQuad(0, 0, 100, 60, 7), BoxQuad(0, 0, 100, 60, 7), 1},
+	}
+	for _, tt := range tests {
+		if got := IoU(tt.a, tt.b); !near(got, tt.want, 1e-9) {
+			t.Errorf("%s: IoU = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// A square turned 45 degrees about the center of an equal square
+	// overlaps it in a regular octagon
+	got := IoU(BoxQuad(-1, -1, 1, 1, 0), BoxQuad(-1, -1, 1, 1, 45))
+	octagon := 8 * (math.Sqrt2 - 1)
+	if want := octagon / (8 - octagon); !near(got, want, 1e-9) {
+		t.Errorf("turned square: IoU = %v, want %v", got, want)
+	}
+}
+
+func TestBoxQuadAngle(t *testing.T) {
+	for _, angle := range []float64{-3, 0, 0.5, 10} {
+		q := BoxQuad(100, 200, 900, 700, angle)
+		if got := q.Angle(); !near(got, angle, 1e-9) {
+			t.Errorf("Angle of box turned %v = %v", angle, got)
+		}
+		if got := q.Area(); !near(got, 800*500, 1e-6) {
+			t.Errorf("Area of box turned %v = %v", angle, got)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	left, top, right, bottom := 10.0, 20.0, 410.0, 320.0
+	a := Annotation{File: "scan.jpg", Left: &left, Top: &top, Right: &right, Bottom: &bottom}
+
+	s := Evaluate(a, box(13, 20, 410, 316), nil)
+	if s.Failed() {
+		t.Fatalf("Evaluate failed: %s", s.Error)
+	}
+	want := Edges{Left: 3, Top: 0, Right: 0, Bottom: 4}
+	e := s.EdgeError
+	if !near(e.Left, want.Left, 1e-9) || !near(e.Top, want.Top, 1e-9) || !near(e.Right, want.Right, 1e-9) || !near(e.Bottom, want.Bottom, 1e-9) {
+		t.Errorf("EdgeError = %+v, want %+v", e, want)
+	}
+	if wantIoU := 397.0 * 296 / (400 * 300); !near(s.IoU, wantIoU, 1e-9) {
+		t.Errorf("IoU = %v, want %v", s.IoU, wantIoU)
+	}
+	if !near(s.AngleError, 0, 1e-9) {
+		t.Errorf("AngleError = %v, want 0", s.AngleError)
+	}
+
+	s = Evaluate(a, BoxQuad(left, top, right, bottom, 1.5), nil)
+	if !near(s.AngleError, 1.5, 1e-9) {
+		t.Errorf("AngleError = %v, want 1.5", s.AngleError)
+	}
+
+	s = Evaluate(a, Quad{}, os.ErrNotExist)
+	if !s.Failed() || s.IoU != 0 {
+		t.Errorf("Evaluate of a failed detection = %+v", s)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	scores := []Score{
+		{File: "a", IoU: 0.9, EdgeError: Edges{1, 2, 3, 4}, AngleError: -0.5},
+		{File: "b", IoU: 0.7, EdgeError: Edges{5, 5, 5, 9}, AngleError: 0.25},
+		{File: "c", Error: "no frame"},
+	}
+	s := Summarize(scores)
+	if s.Images != 3 || s.Failures != 1 {
+		t.Errorf("Images, Failures = %d, %d, want 3, 1", s.Images, s.Failures)
+	}
+	if !near(s.MeanIoU, 0.8, 1e-9) || s.MinIoU != 0.7 {
+		t.Errorf("MeanIoU, MinIoU = %v, %v, want 0.8, 0.7", s.MeanIoU, s.MinIoU)
+	}
+	if !near(s.MeanEdgeError, 4.25, 1e-9) || s.MaxEdgeError != 9 {
+		t.Errorf("MeanEdgeError, MaxEdgeError = %v, %v, want 4.25, 9", s.MeanEdgeError, s.MaxEdgeError)
+	}
+	if !near(s.MeanAngleError, 0.375, 1e-9) || s.MaxAngleError != 0.5 {
+		t.Errorf("MeanAngleError, MaxAngleError = %v, %v, want 0.375, 0.5", s.MeanAngleError, s.MaxAngleError)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := NewBaseline([]Score{
+		{File: "steady", IoU: 0.95, EdgeError: Edges{1, 1, 1, 1}, AngleError: 0.1},
+		{File: "worse", IoU: 0.95, EdgeError: Edges{1, 1, 1, 1}, AngleError: 0.1},
+		{File: "broken", IoU: 0.95},
+		{File: "always failed", Error: "no frame"},
+	})
+	scores := []Score{
+		{File: "steady", IoU: 0.949, EdgeError: Edges{2.5, 1, 1, 1}, AngleError: -0.15},
+		{File: "worse", IoU: 0.9, EdgeError: Edges{1, 1, 6, 1}, AngleError: 0.5},
+		{File: "broken", Error: "no frame"},
+		{File: "always failed", Error: "no frame"},
+		{File: "new", Error: "no frame"},
+	}
+
+	got := Compare(base, scores, DefaultTolerance)
+	want := []Regression{
+		{File: "worse", Metric: "iou", Baseline: 0.95, Current: 0.9},
+		{File: "worse", Metric: "edge error px", Baseline: 1, Current: 6},
+		{File: "worse", Metric: "angle error deg", Baseline: 0.1, Current: 0.5},
+		{File: "broken", Metric: "failed"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Compare = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("regression %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBaselineRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	b := NewBaseline([]Score{{File: "a", IoU: 0.9, EdgeError: Edges{1, 2, 3, 4}, AngleError: 0.2}})
+	if err := WriteBaseline(path, b); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Summary != b.Summary || len(got.Scores) != 1 || got.Scores[0] != b.Scores[0] {
+		t.Errorf("ReadBaseline = %+v, want %+v", got, b)
+	}
+}
+
+func TestReadAnnotations(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "annotations.json")
+	data := `{"images": [
+		{"file": "a.jpg", "corners": [[0, 0], [100, 0], [100, 50], [0, 50]]},
+		{"file": "b.jpg", "left": 5, "top": 6, "right": 105, "bottom": 56, "angle": 0.5}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	annotations, err := ReadAnnotations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 2 {
+		t.Fatalf("read %d annotations, want 2", len(annotations))
+	}
+	if a := annotations[0]; a.File != "a.jpg" || a.Path() != filepath.Join(dir, "a.jpg") {
+		t.Errorf("File, Path = %q, %q", a.File, a.Path())
+	}
+	q, err := annotations[1].Quad()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(q.Angle(), 0.5, 1e-9) || !near(q.Area(), 100*50, 1e-6) {
+		t.Errorf("box annotation gives %v", q)
+	}
+
+	for _, bad := range []string{
+		`{"images": []}`,
+		`{"images": [{"corners": [[0, 0], [1, 0], [1, 1], [0, 1]]}]}`,
+		`{"images": [{"file": "a.jpg", "corners": [[0, 0], [1, 0], [1, 1]]}]}`,
+		`{"images": [{"file": "a.jpg", "left": 0, "right": 10, "top": 0}]}`,
+		`{"images": [{"file": "a.jpg", "left": 10, "right": 0, "top": 0, "bottom": 10}]}`,
+	} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := ReadAnnotations(path); err == nil {
+			t.Errorf("ReadAnnotations accepted %s", bad)
+		}
+	}
+}
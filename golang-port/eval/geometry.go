@@ -0,0 +1,170 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Point is a position in image pixels. In JSON it is written as [x, y].
+type Point struct {
+	X, Y float64
+}
+
+func (p Point) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]float64{p.X, p.Y})
+}
+
+func (p *Point) UnmarshalJSON(data []byte) error {
+	var xy [2]float64
+	if err := json.Unmarshal(data, &xy); err != nil {
+		return fmt.Errorf("point must be [x, y]: %w", err)
+	}
+	p.X, p.Y = xy[0], xy[1]
+	return nil
+}
+
+func (p Point) sub(q Point) Point {
+	return Point{X: p.X - q.X, Y: p.Y - q.Y}
+}
+
+func cross(a, b Point) float64 {
+	return a.X*b.Y - a.Y*b.X
+}
+
+// Quad is a frame outline given by its corners in the order top left, top
+// right, bottom right, bottom left
+type Quad [4]Point
+
+// NewQuad orders four corners given in any order around the frame
+func NewQuad(corners [4]Point) Quad {
+	var c Point
+	for _, p := range corners {
+		c.X += p.X / 4
+		c.Y += p.Y / 4
+	}
+
+	// With y pointing down, increasing angles run clockwise on screen
+	pts := corners
+	sort.Slice(pts[:], func(i, j int) bool {
+		return math.Atan2(pts[i].Y-c.Y, pts[i].X-c.X) < math.Atan2(pts[j].Y-c.Y, pts[j].X-c.X)
+	})
+	first := 0
+	for i, p := range pts {
+		if p.X+p.Y < pts[first].X+pts[first].Y {
+			first = i
+		}
+	}
+
+	var q Quad
+	for i := range q {
+		q[i] = pts[(first+i)%4]
+	}
+	return q
+}
+
+// BoxQuad returns the corners of an upright box turned by angle degrees
+// about its center, clockwise on screen as filmcrop.RotatedRect angles are
+func BoxQuad(left, top, right, bottom, angle float64) Quad {
+	c := Point{X: (left + right) / 2, Y: (top + bottom) / 2}
+	cos := math.Cos(angle * math.Pi / 180)
+	sin := math.Sin(angle * math.Pi / 180)
+
+	var q Quad
+	for i, p := range [4]Point{{left, top}, {right, top}, {right, bottom}, {left, bottom}} {
+		d := p.sub(c)
+		q[i] = Point{X: c.X + d.X*cos - d.Y*sin, Y: c.Y + d.X*sin + d.Y*cos}
+	}
+	return q
+}
+
+// Area returns the area enclosed by q
+func (q Quad) Area() float64 {
+	return math.Abs(area(q[:]))
+}
+
+// Angle returns the mean slope of the top and bottom edges in degrees,
+// positive when they fall to the right
+func (q Quad) Angle() float64 {
+	top := q[1].sub(q[0])
+	bottom := q[2].sub(q[3])
+	return (math.Atan2(top.Y, top.X) + math.Atan2(bottom.Y, bottom.X)) / 2 * 180 / math.Pi
+}
+
+// edges returns the sides of q as left, top, right and bottom
+func (q Quad) edges() [4][2]Point {
+	return [4][2]Point{{q[3], q[0]}, {q[0], q[1]}, {q[1], q[2]}, {q[2], q[3]}}
+}
+
+// IoU returns the intersection over union of two convex quads
+func IoU(a, b Quad) float64 {
+	inter := math.Abs(area(clip(a[:], b[:])))
+	union := a.Area() + b.Area() - inter
+	if union <= 0 {
+		return 0
+	}
+	return inter / union
+}
+
+// area is the signed shoelace area of a polygon, positive for corners that
+// run clockwise on screen
+func area(poly []Point) float64 {
+	var s float64
+	for i := range poly {
+		s += cross(poly[i], poly[(i+1)%len(poly)])
+	}
+	return s / 2
+}
+
+// clip returns the part of the polygon subject inside the convex polygon by,
+// by Sutherland-Hodgman clipping
+func clip(subject, by []Point) []Point {
+	if area(by) < 0 {
+		rev := make([]Point, len(by))
+		for i, p := range by {
+			rev[len(by)-1-i] = p
+		}
+		by = rev
+	}
+
+	out := subject
+	for i := range by {
+		a, b := by[i], by[(i+1)%len(by)]
+		inside := func(p Point) bool { return cross(b.sub(a), p.sub(a)) >= 0 }
+
+		in := out
+		out = nil
+		for j := range in {
+			p, q := in[j], in[(j+1)%len(in)]
+			if inside(p) {
+				out = append(out, p)
+			}
+			if inside(p) != inside(q) {
+				out = append(out, intersect(p, q, a, b))
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+	}
+	return out
+}
+
+// intersect returns where segment p-q crosses the line through a and b
+func intersect(p, q, a, b Point) Point {
+	d := q.sub(p)
+	e := b.sub(a)
+	t := cross(a.sub(p), e) / cross(d, e)
+	return Point{X: p.X + t*d.X, Y: p.Y + t*d.Y}
+}
+
+// lineDistance returns the distance from p to the line through a and b
+func lineDistance(p, a, b Point) float64 {
+	d := b.sub(a)
+	n := math.Hypot(d.X, d.Y)
+	if n == 0 {
+		return math.Hypot(p.X-a.X, p.Y-a.Y)
+	}
+	return math.Abs(cross(d, p.sub(a))) / n
+}
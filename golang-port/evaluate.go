@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"film-crop-detector/eval"
+	"film-crop-detector/filmcrop"
+)
+
+// runEval implements the eval command: it detects the frame on every scan
+// of an annotation file, scores it against the annotation and optionally
+// checks the scores against a baseline. It returns the exit code.
+func runEval(args []string) int {
+	fs := flag.NewFlagSet(os.Args[0]+" eval", flag.ExitOnError)
+
+	f := addDetectFlags(fs)
+	var outputFormat string
+	var baselinePath string
+	var writePath string
+	tol := eval.DefaultTolerance
+
+	fs.StringVar(&outputFormat, "output-format", "text", "Score output on stdout: text or json")
+	fs.StringVar(&baselinePath, "baseline", "", "Compare the scores with this baseline and fail on regressions")
+	fs.StringVar(&writePath, "write-baseline", "", "Write the scores of this run as a baseline to this file")
+	fs.Float64Var(&tol.IoU, "tolerance-iou", tol.IoU, "IoU a file may lose against the baseline")
+	fs.Float64Var(&tol.EdgePixels, "tolerance-px", tol.EdgePixels, "Pixels the worst edge error of a file may grow against the baseline")
+	fs.Float64Var(&tol.AngleDegrees, "tolerance-angle", tol.AngleDegrees, "Degrees the angle error of a file may grow against the baseline")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s eval [options] annotations.json\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Detects the frame on every annotated scan and scores the detected frame,\nbefore inset and aspect correction, against the annotation.\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+The annotation file lists each scan with either its four frame corners or
+an upright box turned by an angle, in pixels of the upright image:
+  {"images": [
+    {"file": "a.jpg", "corners": [[36, 51], [4130, 20], [4133, 2718], [53, 2751]]},
+    {"file": "b.tif", "left": 110, "top": 94, "right": 2800, "bottom": 4165, "angle": 0.1}
+  ]}
+File names are relative to the annotation file. Angles are in degrees,
+clockwise on screen.
+
+The exit code is %d when a detection failed or a score fell behind the
+baseline by more than the tolerance.
+`, ExitPartial)
+	}
+	fs.Parse(args)
+
+	if outputFormat != "text" && outputFormat != "json" {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown output format %q (known: text, json)\n", outputFormat)
+		return ExitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	annotations, err := eval.ReadAnnotations(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+	var base eval.Baseline
+	if baselinePath != "" {
+		base, err = eval.ReadBaseline(baselinePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return ExitUsage
+		}
+	}
+
+	opts, err := f.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+	detector := filmcrop.NewDetector(opts)
+
+	// Scores own stdout in json mode, so progress moves to stderr
+	progress := io.Writer(os.Stdout)
+	if outputFormat == "json" {
+		progress = os.Stderr
+	}
+	scores := evaluateAll(detector, annotations, func(i int, s eval.Score) {
+		fmt.Fprintf(progress, "[%d/%d] %s\n", i+1, len(annotations), scoreLine(s))
+	})
+
+	run := eval.NewBaseline(scores)
+	var regressions []eval.Regression
+	if baselinePath != "" {
+		regressions = eval.Compare(base, scores, tol)
+	}
+
+	if outputFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			eval.Baseline
+			Regressions []string `json:"regressions"`
+		}{run, regressionList(regressions)})
+	} else {
+		printEvalSummary(os.Stdout, run.Summary)
+	}
+
+	if writePath != "" {
+		if err := eval.WriteBaseline(writePath, run); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return ExitPartial
+		}
+		fmt.Fprintf(progress, "Wrote baseline %s\n", writePath)
+	}
+
+	if len(regressions) > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d regression(s) against %s:\n", len(regressions), baselinePath)
+		for _, r := range regressions {
+			fmt.Fprintf(os.Stderr, "  %s\n", r)
+		}
+		return ExitPartial
+	}
+	return exitCode(run.Summary.Images-run.Summary.Failures, run.Summary.Failures)
+}
+
+// evaluateAll detects the frame on the scan of every annotation and scores
+// it, calling done after each
+func evaluateAll(detector *filmcrop.Detector, annotations []eval.Annotation, done func(int, eval.Score)) []eval.Score {
+	scores := make([]eval.Score, len(annotations))
+	for i, a := range annotations {
+		quad, err := detectQuad(detector, a.Path())
+		scores[i] = eval.Evaluate(a, quad, err)
+		if done != nil {
+			done(i, scores[i])
+		}
+	}
+	return scores
+}
+
+// detectQuad returns the raw frame detected on the scan at path, or the
+// refined quadrilateral with --quad
+func detectQuad(detector *filmcrop.Detector, path string) (eval.Quad, error) {
+	img, err := filmcrop.ReadImage(path)
+	defer img.Close()
+	if err != nil {
+		return eval.Quad{}, err
+	}
+
+	res, err := detector.Detect(img)
+	if err != nil {
+		return eval.Quad{}, err
+	}
+	found := res.RawRect.Corners()
+	if res.Quad != nil {
+		found = *res.Quad
+	}
+	var corners [4]eval.Point
+	for i, c := range found {
+		corners[i] = eval.Point{X: float64(c.X), Y: float64(c.Y)}
+	}
+	return eval.NewQuad(corners), nil
+}
+
+func scoreLine(s eval.Score) string {
+	if s.Failed() {
+		return fmt.Sprintf("%s: FAILED: %s", s.File, s.Error)
+	}
+	e := s.EdgeError
+	return fmt.Sprintf("%s: IoU %.4f  edges L %.1f T %.1f R %.1f B %.1f px  angle %+.2f°",
+		s.File, s.IoU, e.Left, e.Top, e.Right, e.Bottom, s.AngleError)
+}
+
+func printEvalSummary(w io.Writer, s eval.Summary) {
+	fmt.Fprintf(w, "\nImages:     %d (%d failed)\n", s.Images, s.Failures)
+	if s.Images > s.Failures {
+		fmt.Fprintf(w, "IoU:        mean %.4f  min %.4f\n", s.MeanIoU, s.MinIoU)
+		fmt.Fprintf(w, "Edge error: mean %.1f px  max %.1f px\n", s.MeanEdgeError, s.MaxEdgeError)
+		fmt.Fprintf(w, "Angle:      mean %.2f°  max %.2f°\n", s.MeanAngleError, s.MaxAngleError)
+	}
+}
+
+func regressionList(regressions []eval.Regression) []string {
+	list := make([]string, len(regressions))
+	for i, r := range regressions {
+		list[i] = r.String()
+	}
+	return list
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"testing"
+
+	"film-crop-detector/eval"
+	"film-crop-detector/filmcrop"
+)
+
+const (
+	annotationsPath = "../test-images/annotations.json"
+	baselinePath    = "../test-images/baseline.json"
+)
+
+var updateBaseline = flag.Bool("update", false, "rewrite "+baselinePath+" from this run")
+
+// TestEvalBaseline runs detection on the annotated test images and fails
+// when any score falls behind the stored baseline by more than the default
+// tolerance. After an intended change in detection, rewrite the baseline
+// with go test -run TestEvalBaseline -update.
+func TestEvalBaseline(t *testing.T) {
+	annotations, err := eval.ReadAnnotations(annotationsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	detector := filmcrop.NewDetector(filmcrop.DefaultOptions())
+	scores := evaluateAll(detector, annotations, func(_ int, s eval.Score) {
+		t.Log(scoreLine(s))
+	})
+
+	if *updateBaseline {
+		if err := eval.WriteBaseline(baselinePath, eval.NewBaseline(scores)); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("wrote %s", baselinePath)
+		return
+	}
+
+	// A missing baseline fails rather than skips, so the gate cannot go
+	// quietly unused
+	base, err := eval.ReadBaseline(baselinePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("no baseline at %s; create it with go test -run TestEvalBaseline -update and commit it", baselinePath)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range eval.Compare(base, scores, eval.DefaultTolerance) {
+		t.Error(r)
+	}
+}
@@ -0,0 +1,257 @@
+package filmcrop
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"gocv.io/x/gocv"
+)
+
+// findExposureBounds prepares img for frame detection and runs the
+// FrameDetector of the options on it. scale is the size of img relative to
+// the full resolution scan, used to keep filter and kernel sizes consistent
+// on downsampled proxies.
+func (d *Detector) findExposureBounds(img gocv.Mat, scale float64) (*RotatedRect, Classification, Candidate) {
+	// Classify the film and optionally invert for processing
+	film := d.classifyFilm(img)
+	workImg := img.Clone()
+	defer workImg.Close()
+
+	if film.Type.Polarity() == "positive" {
+		// Invert positive to negative-like for processing
+		gocv.BitwiseNot(workImg, &workImg)
+		d.debugf("inverted positive image for processing\n")
+	}
+
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(workImg, &gray, gocv.ColorBGRToGray)
+
+	// Smooth out noise and maximize brightness range
+	bilateralFiltered := gocv.NewMat()
+	defer bilateralFiltered.Close()
+	gocv.BilateralFilter(gray, &bilateralFiltered, scaledKernel(11, scale), 17, 17*scale)
+
+	equalized := gocv.NewMat()
+	defer equalized.Close()
+	gocv.EqualizeHist(bilateralFiltered, &equalized)
+
+	ignoreMask := createIgnoreMask(workImg, equalized, film.Type, scale)
+	defer ignoreMask.Close()
+
+	fd := d.frameDetector()
+	c := fd.FindFrame(&Scan{
+		Image:   workImg,
+		Gray:    equalized,
+		Mask:    ignoreMask,
+		Film:    film,
+		Scale:   scale,
+		Options: d.opts,
+	})
+	if c.Rect != nil && len(c.Detectors) == 0 {
+		c.Detectors = []string{fd.Name()}
+	}
+	return c.Rect, film, c
+}
+
+// frameDetector returns the FrameDetector of the options, the threshold
+// sweep when none is set
+func (d *Detector) frameDetector() FrameDetector {
+	if d.opts.FrameDetector == nil {
+		return ThresholdDetector{}
+	}
+	return d.opts.FrameDetector
+}
+
+// ThresholdDetector sweeps a threshold over the equalized scan and takes the
+// largest contour at each level. The frame is the median of the contours
+// that reached a plausible capture area; it works as long as the frame is
+// clearly darker than the rebate.
+type ThresholdDetector struct{}
+
+// Name returns "threshold"
+func (ThresholdDetector) Name() string {
+	return "threshold"
+}
+
+// FindFrame runs the threshold sweep on s
+func (ThresholdDetector) FindFrame(s *Scan) Candidate {
+	maxArea := s.maxArea()
+	minCaptureArea := s.minCaptureArea()
+
+	var results []*RotatedRect
+	var bestRect *RotatedRect
+	bestArea := 0.0
+	passes := 0
+
+	k := scaledKernel(5, s.Scale)
+	kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Point{k, k})
+	defer kernel.Close()
+
+	for lowerThreshold := 0; lowerThreshold < 240; lowerThreshold += 5 {
+		passes++
+		// Use negative logic (THRESH_BINARY_INV) since we invert positives
+		binary := gocv.NewMat()
+		gocv.Threshold(s.Gray, &binary, float32(lowerThreshold), 255, gocv.ThresholdBinaryInv)
+
+		masked := gocv.NewMat()
+		gocv.BitwiseAnd(s.Mask, binary, &masked)
+		binary.Close()
+
+		// Morphology
+		dilated := gocv.NewMat()
+		gocv.Dilate(masked, &dilated, kernel)
+		masked.Close()
+
+		eroded := gocv.NewMat()
+		gocv.Erode(dilated, &eroded, kernel)
+		dilated.Close()
+
+		rect, area := findLargestContourRect(eroded)
+
+		s.debugf("threshold= %d area= %f rect= %+v\n", lowerThreshold, area, rect)
+
+		// Track best seen rect by area
+		if rect != nil && area > bestArea {
+			bestArea = area
+			bestRect = rect
+		}
+
+		// Stop once a valid result is returned
+		if rect != nil && area >= maxArea {
+			eroded.Close()
+			break
+		}
+
+		if rect != nil && area >= minCaptureArea {
+			results = append(results, rect)
+		}
+
+		if s.Options.ShowWindows {
+			debugImg := gocv.NewMat()
+			gocv.CvtColor(eroded, &debugImg, gocv.ColorGrayToBGR)
+
+			if rect != nil {
+				drawRotatedRect(debugImg, rect, color.RGBA{0, 255, 0, 255}, 3) // Green for collected
+			}
+
+			// Draw threshold text
+			gocv.PutText(&debugImg, fmt.Sprintf("Threshold: %d", lowerThreshold),
+				image.Point{20, 30}, gocv.FontHersheyPlain, 2,
+				color.RGBA{0, 150, 255, 255}, 2)
+
+			window := gocv.NewWindow("image")
+			resized := gocv.NewMat()
+			gocv.Resize(debugImg, &resized, image.Point{}, 0.75, 0.75, gocv.InterpolationLinear)
+			window.IMShow(resized)
+			window.WaitKey(1)
+			window.Close()
+			resized.Close()
+			debugImg.Close()
+		}
+
+		eroded.Close()
+	}
+
+	// Prefer median of good results; fall back to best seen rect
+	median := medianRect(results)
+	if median != nil {
+		return Candidate{Rect: median, Agreeing: results, Passes: passes}
+	}
+	s.debugf("no threshold reached the capture area, using best rect\n")
+	return Candidate{Rect: bestRect, Passes: passes}
+}
+
+// createIgnoreMask returns the mask of pixels that may belong to the frame.
+// img is the working image, already inverted for positive film types. Clipped
+// highlights are always excluded; each film type then excludes what cannot
+// be film of that type.
+func createIgnoreMask(img, gray gocv.Mat, film FilmType, scale float64) gocv.Mat {
+	// Mask brightest spots
+	ignoreMask := gocv.NewMat()
+	gocv.Threshold(gray, &ignoreMask, 240, 255, gocv.ThresholdBinary)
+
+	k := scaledKernel(3, scale)
+	kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Point{k, k})
+	defer kernel.Close()
+
+	dilated := gocv.NewMat()
+	defer dilated.Close()
+	gocv.Dilate(ignoreMask, &dilated, kernel)
+	ignoreMask.Close()
+
+	var lower, upper gocv.Scalar
+	switch film {
+	case FilmC41:
+		// Everything on the film carries the orange mask, so areas of low
+		// saturation are holder or backlight
+		lower, upper = gocv.NewScalar(0, 0, 0, 0), gocv.NewScalar(255, 7, 255, 0)
+	case FilmBW:
+		// The film is neutral, so strongly colored areas are holder, light
+		// leaks or a tinted light source
+		lower, upper = gocv.NewScalar(0, 60, 0, 0), gocv.NewScalar(255, 255, 255, 0)
+	case FilmE6:
+		// Bare light table around the slide turns black once inverted
+		lower, upper = gocv.NewScalar(0, 0, 0, 0), gocv.NewScalar(255, 30, 255-clippedLevel, 0)
+	default:
+		// A print has nothing to exclude beyond its highlights
+		final := gocv.NewMat()
+		gocv.BitwiseNot(dilated, &final)
+		return final
+	}
+
+	filmMask := hsvMask(img, lower, upper, scale)
+	defer filmMask.Close()
+
+	combined := gocv.NewMat()
+	defer combined.Close()
+	gocv.BitwiseOr(dilated, filmMask, &combined)
+
+	// Flip to create keep mask
+	final := gocv.NewMat()
+	gocv.BitwiseNot(combined, &final)
+	return final
+}
+
+// hsvMask marks the pixels of img whose smoothed HSV values lie between lower
+// and upper
+func hsvMask(img gocv.Mat, lower, upper gocv.Scalar, scale float64) gocv.Mat {
+	hsv := gocv.NewMat()
+	defer hsv.Close()
+	gocv.CvtColor(img, &hsv, gocv.ColorBGRToHSV)
+
+	blurred := gocv.NewMat()
+	defer blurred.Close()
+	blur := scaledKernel(5, scale)
+	gocv.GaussianBlur(hsv, &blurred, image.Point{blur, blur}, 0, 0, gocv.BorderDefault)
+
+	mask := gocv.NewMat()
+	gocv.InRangeWithScalar(blurred, lower, upper, &mask)
+	return mask
+}
+
+func findLargestContourRect(binary gocv.Mat) (*RotatedRect, float64) {
+	contours := gocv.FindContours(binary, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+	defer contours.Close()
+
+	var largestArea float64
+	var largestRect *RotatedRect
+
+	for i := 0; i < contours.Size(); i++ {
+		contour := contours.At(i)
+		area := gocv.ContourArea(contour)
+
+		if area > largestArea {
+			largestArea = area
+			rotRect := gocv.MinAreaRect(contour)
+			largestRect = &RotatedRect{
+				Center: Point2f{X: float32(rotRect.Center.X), Y: float32(rotRect.Center.Y)},
+				Size:   Point2f{X: float32(rotRect.Width), Y: float32(rotRect.Height)},
+				Angle:  rotRect.Angle,
+			}
+		}
+	}
+
+	return largestRect, largestArea
+}
@@ -0,0 +1,97 @@
+package filmcrop
+
+import "math"
+
+// Confidence rates how much a detection can be trusted. Every field is in
+// [0,1], where 1 is best.
+type Confidence struct {
+	// Score is the weighted combination of the signals below
+	Score float64
+	// Agreement is how many estimates of the frame detector, such as the
+	// threshold passes, found a frame of plausible size
+	Agreement float64
+	// Consistency is how tightly those frames cluster around their median
+	Consistency float64
+	// Aspect is how close the frame is to the aspect ratio of its format
+	Aspect float64
+	// Margin is how far the frame stays from the image border
+	Margin float64
+}
+
+const (
+	// agreementPasses is the number of agreeing estimates, such as 8 of the
+	// 48 passes of the threshold sweep, that counts as full agreement. A
+	// detector making fewer estimates needs all of them to agree.
+	agreementPasses = 8
+	// maxSpread is the median deviation from the median frame, relative to
+	// its size, at which consistency drops to zero
+	maxSpread = 0.05
+	// minMargin is the distance from the image border, relative to the
+	// shorter image side, below which the margin signal starts to drop
+	minMargin = 0.01
+)
+
+// confidence weights the signals that separate a solid detection from a guess
+func (d *Detector) confidence(found Candidate, raw *RotatedRect, aspect float64, w, h int) Confidence {
+	full := agreementPasses
+	if found.Passes > 0 {
+		full = min(full, found.Passes)
+	}
+	c := Confidence{
+		Agreement:   clamp01(float64(len(found.Agreeing)) / float64(full)),
+		Consistency: 1 - clamp01(rectSpread(found.Agreeing)/maxSpread),
+		Aspect:      1,
+		Margin:      clamp01(borderMargin(raw, w, h) / minMargin),
+	}
+	if aspect > 0 {
+		long := math.Max(float64(raw.Size.X), float64(raw.Size.Y))
+		short := math.Min(float64(raw.Size.X), float64(raw.Size.Y))
+		if short > 0 {
+			c.Aspect = 1 - clamp01(math.Abs(long/short-aspect)/d.opts.MaxAspectDifference)
+		} else {
+			c.Aspect = 0
+		}
+	}
+
+	c.Score = 0.35*c.Agreement + 0.25*c.Consistency + 0.2*c.Aspect + 0.2*c.Margin
+	return c
+}
+
+// rectSpread is the median deviation of rects from their median rect, as a
+// fraction of the median's mean side
+func rectSpread(rects []*RotatedRect) float64 {
+	m := medianRect(rects)
+	if m == nil {
+		return math.Inf(1)
+	}
+	side := float64(m.Size.X+m.Size.Y) / 2
+	if side <= 0 {
+		return math.Inf(1)
+	}
+
+	var deviations []float64
+	for _, r := range normalizeRectRotation(rects) {
+		dev := math.Max(
+			math.Hypot(float64(r.Center.X-m.Center.X), float64(r.Center.Y-m.Center.Y)),
+			math.Max(math.Abs(float64(r.Size.X-m.Size.X)), math.Abs(float64(r.Size.Y-m.Size.Y))),
+		)
+		deviations = append(deviations, dev)
+	}
+	return median(deviations) / side
+}
+
+// borderMargin is the smallest distance between rect and the edge of a
+// w x h image, as a fraction of the shorter image side. It is zero or
+// negative when the frame touches or leaves the image.
+func borderMargin(rect *RotatedRect, w, h int) float64 {
+	margin := math.Inf(1)
+	for _, p := range rect.Corners() {
+		x, y := float64(p.X), float64(p.Y)
+		margin = math.Min(margin, math.Min(math.Min(x, float64(w)-x), math.Min(y, float64(h)-y)))
+	}
+	return margin / float64(min(w, h))
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
@@ -0,0 +1,142 @@
+package filmcrop
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+var interpolations = map[string]gocv.InterpolationFlags{
+	"nearest": gocv.InterpolationNearestNeighbor,
+	"linear":  gocv.InterpolationLinear,
+	"cubic":   gocv.InterpolationCubic,
+	"area":    gocv.InterpolationArea,
+	"lanczos": gocv.InterpolationLanczos4,
+}
+
+// ParseInterpolation maps an interpolation name (nearest, linear, cubic,
+// area or lanczos) to the matching OpenCV flag
+func ParseInterpolation(name string) (gocv.InterpolationFlags, error) {
+	flag, ok := interpolations[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown interpolation %q", name)
+	}
+	return flag, nil
+}
+
+// DeskewFrame returns the straightened frame the deskewed output covers: the
+// corrected rect with an angle within ±45°, reduced to the format aspect when
+// EnforceAspect is set, shrunk by FinalShrink and then scaled down until it lies entirely
+// inside the scan.
+func (d *Detector) DeskewFrame(res Result) (*RotatedRect, error) {
+	if !res.Found() {
+		return nil, ErrNoFrameDetected
+	}
+
+	// Pick the representation closest to upright so we never rotate by 90°
+	w, h := float64(res.Rect.Size.X), float64(res.Rect.Size.Y)
+	angle := res.Rect.Angle
+	for angle > 45 {
+		angle -= 90
+		w, h = h, w
+	}
+	for angle < -45 {
+		angle += 90
+		w, h = h, w
+	}
+
+	format, err := LookupFormat(res.Format)
+	if d.opts.EnforceAspect && err == nil && !format.isAuto() && w > 0 && h > 0 {
+		target := format.Aspect
+		if h > w {
+			target = 1.0 / format.Aspect
+		}
+		if w/h > target {
+			w = h * target
+		} else {
+			h = w / target
+		}
+	}
+
+	scale := math.Max(0.0, 1.0-d.opts.FinalShrink)
+
+	// Keep every corner of the frame inside the source image
+	cx, cy := float64(res.Rect.Center.X), float64(res.Rect.Center.Y)
+	cos := math.Cos(angle * math.Pi / 180)
+	sin := math.Sin(angle * math.Pi / 180)
+	for _, sx := range []float64{-1, 1} {
+		for _, sy := range []float64{-1, 1} {
+			ox := sx*w/2*cos - sy*h/2*sin
+			oy := sx*w/2*sin + sy*h/2*cos
+			scale = math.Min(scale, fitScale(cx, ox, float64(res.Width)))
+			scale = math.Min(scale, fitScale(cy, oy, float64(res.Height)))
+		}
+	}
+
+	frame := &RotatedRect{
+		Center: res.Rect.Center,
+		Size:   Point2f{X: float32(w * scale), Y: float32(h * scale)},
+		Angle:  angle,
+	}
+	d.debugf("deskew frame= %+v\n", frame)
+	return frame, nil
+}
+
+// fitScale returns the largest factor s for which center+s*offset stays
+// within [0, limit]
+func fitScale(center, offset, limit float64) float64 {
+	switch {
+	case offset > 0:
+		return math.Max(0, (limit-center)/offset)
+	case offset < 0:
+		return math.Max(0, -center/offset)
+	default:
+		return math.Inf(1)
+	}
+}
+
+// Deskew rotates img so the detected frame is level and returns the largest
+// axis-aligned rectangle inside it. img may be a different resolution from the
+// image res was detected on; the frame is scaled to match.
+func (d *Detector) Deskew(img gocv.Mat, res Result, interp gocv.InterpolationFlags) (gocv.Mat, error) {
+	frame, err := d.DeskewFrame(res)
+	if err != nil {
+		return gocv.NewMat(), err
+	}
+
+	sx := float64(img.Cols()) / float64(res.Width)
+	sy := float64(img.Rows()) / float64(res.Height)
+	outW := int(math.Round(float64(frame.Size.X) * sx))
+	outH := int(math.Round(float64(frame.Size.Y) * sy))
+	if outW <= 0 || outH <= 0 {
+		return gocv.NewMat(), ErrNoFrameDetected
+	}
+
+	// Affine transform taking the frame centre to the middle of the output
+	// and undoing its rotation
+	cx, cy := float64(frame.Center.X)*sx, float64(frame.Center.Y)*sy
+	cos := math.Cos(frame.Angle * math.Pi / 180)
+	sin := math.Sin(frame.Angle * math.Pi / 180)
+
+	m := gocv.NewMatWithSize(2, 3, gocv.MatTypeCV64F)
+	defer m.Close()
+	m.SetDoubleAt(0, 0, cos)
+	m.SetDoubleAt(0, 1, sin)
+	m.SetDoubleAt(0, 2, float64(outW)/2-(cos*cx+sin*cy))
+	m.SetDoubleAt(1, 0, -sin)
+	m.SetDoubleAt(1, 1, cos)
+	m.SetDoubleAt(1, 2, float64(outH)/2-(-sin*cx+cos*cy))
+
+	out := gocv.NewMat()
+	err = gocv.WarpAffineWithParams(img, &out, m, image.Point{X: outW, Y: outH},
+		interp, gocv.BorderReplicate, color.RGBA{})
+	if err != nil {
+		out.Close()
+		return gocv.NewMat(), err
+	}
+	return out, nil
+}
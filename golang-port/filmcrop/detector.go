@@ -0,0 +1,276 @@
+// Package filmcrop finds the exposed frame on a film scan and computes the
+// crop and rotation needed to isolate it.
+package filmcrop
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"gocv.io/x/gocv"
+)
+
+// Options controls frame detection
+type Options struct {
+	// MaxCoverage is the largest fraction of each image dimension a frame may span
+	MaxCoverage float64
+	// InsetPercent shrinks the detected frame to stay clear of the rebate
+	InsetPercent float64
+	// Format is the film format whose aspect ratio frames are corrected
+	// towards, or Auto to pick the closest registered format per frame
+	Format Format
+	// EnforceAspect forces the final crop to the exact format aspect ratio
+	EnforceAspect bool
+	// MaxAspectDifference is how far off the format aspect a frame may be and still be corrected
+	MaxAspectDifference float64
+	// FinalShrink is the uniform inward crop applied last, as a fraction
+	FinalShrink float64
+	// ProxyLongEdge, when positive, downsamples larger images to this long
+	// edge in pixels before detection; the frame is scaled back afterwards
+	ProxyLongEdge int
+	// RefineEdges moves each side of a frame found on a proxy to the nearest
+	// strong edge in the full resolution image
+	RefineEdges bool
+	// RefineQuad fits each side of the frame to the image on its own, to a
+	// fraction of a pixel, so Result.Quad follows keystone and bowed holders
+	// rather than assuming a perfect rectangle
+	RefineQuad bool
+	// FilmType forces the film type instead of classifying each scan; ""
+	// and FilmAuto classify
+	FilmType FilmType
+	// FrameDetector finds the frame on the prepared scan; nil uses the
+	// threshold sweep
+	FrameDetector FrameDetector
+
+	// Verbose prints debug information to stderr
+	Verbose bool
+	// ShowWindows displays each threshold pass in a debug window
+	ShowWindows bool
+}
+
+// DefaultOptions returns the settings used by the command line tool
+func DefaultOptions() Options {
+	return Options{
+		MaxCoverage:         0.98,
+		InsetPercent:        0.005,
+		Format:              Formats[0],
+		MaxAspectDifference: 0.3,
+		FinalShrink:         0.01,
+	}
+}
+
+// Result describes the frame found on a scan
+type Result struct {
+	// Width and Height are the dimensions of the analysed image
+	Width, Height int
+	// Polarity is "negative" or "positive"
+	Polarity string
+	// Film is the film type the scan was processed as
+	Film Classification
+	// Format is the name of the film format the frame was corrected to
+	Format string
+	// Detectors names the frame detectors that found the frame, several
+	// when an ensemble agreed
+	Detectors []string
+
+	// RawRect is the detected frame, InsetRect the frame after the inset and
+	// Rect the frame after aspect correction. All are nil when no frame was found.
+	RawRect   *RotatedRect
+	InsetRect *RotatedRect
+	Rect      *RotatedRect
+	// Quad is the detected frame with each side fitted on its own when
+	// RefineQuad is set, and nil otherwise; RawRect is then the rectangle
+	// closest to it
+	Quad *Quad
+	// AspectCorrected reports whether Rect differs from InsetRect
+	AspectCorrected bool
+
+	// Crop is the final normalized crop box
+	Crop Crop
+	// Rotation is the straightening angle in degrees, as Lightroom expects it
+	Rotation float64
+	// Confidence rates the detection; it is zero when no frame was found
+	Confidence Confidence
+	// Base is the film base sampled by SampleBase, or nil when none was
+	// sampled
+	Base *BaseColor
+	// Roll is set when roll consensus found the frame to differ from the
+	// rest of its roll, and says what was done about it
+	Roll RollStatus
+}
+
+// Found reports whether a frame was detected
+func (r Result) Found() bool {
+	return r.RawRect != nil
+}
+
+// ResultFromCrop rebuilds a Result for a w x h image from a stored crop and
+// Lightroom rotation, such as one read back from an XMP sidecar. The frame
+// rectangles all cover the crop box, turned by the rotation.
+func ResultFromCrop(crop Crop, rotation float64, w, h int) Result {
+	rect := &RotatedRect{
+		Center: Point2f{
+			X: float32((crop.Left + crop.Right) / 2 * float64(w)),
+			Y: float32((crop.Top + crop.Bottom) / 2 * float64(h)),
+		},
+		Size: Point2f{
+			X: float32((crop.Right - crop.Left) * float64(w)),
+			Y: float32((crop.Bottom - crop.Top) * float64(h)),
+		},
+		Angle: -rotation,
+	}
+	return Result{
+		Width:     w,
+		Height:    h,
+		RawRect:   rect,
+		InsetRect: rect,
+		Rect:      rect,
+		Crop:      crop,
+		Rotation:  rotation,
+	}
+}
+
+// Detector finds film frames using a fixed set of options
+type Detector struct {
+	opts Options
+}
+
+// NewDetector returns a Detector using opts
+func NewDetector(opts Options) *Detector {
+	return &Detector{opts: opts}
+}
+
+// Options returns the detector settings
+func (d *Detector) Options() Options {
+	return d.opts
+}
+
+// Detect locates the exposed frame in img. Images other than 8-bit BGR are
+// analysed through a DetectionProxy, and images larger than ProxyLongEdge are
+// downsampled first; the Result is always in img coordinates. When no frame
+// is found it returns ErrNoFrameDetected along with a full-frame Result.
+func (d *Detector) Detect(img gocv.Mat) (Result, error) {
+	if img.Empty() {
+		return Result{}, fmt.Errorf("%w: empty image", ErrDecode)
+	}
+
+	if img.Type() != gocv.MatTypeCV8UC3 {
+		proxy, err := DetectionProxy(img)
+		if err != nil {
+			return Result{}, err
+		}
+		defer proxy.Close()
+		img = proxy
+	}
+
+	res := Result{
+		Width:  img.Cols(),
+		Height: img.Rows(),
+		Crop:   FullFrame,
+	}
+
+	proxy, scale := d.downsample(img)
+	if scale != 1 {
+		defer proxy.Close()
+	}
+
+	rawRect, film, found := d.findExposureBounds(proxy, scale)
+	res.Polarity = film.Type.Polarity()
+	res.Film = film
+	res.Detectors = found.Detectors
+	if rawRect != nil && scale != 1 {
+		rawRect = scaleRect(rawRect, 1/scale)
+		if d.opts.RefineEdges {
+			// Search two proxy pixels either side of each edge
+			rawRect = d.refineEdges(img, rawRect, int(math.Ceil(2/scale)))
+		}
+	}
+	if rawRect != nil && d.opts.RefineQuad {
+		// Search at least two proxy pixels either side of each edge
+		band := max(int(math.Ceil(2/scale)), int(quadBand*float64(min(res.Width, res.Height))))
+		quad := d.refineQuad(img, rawRect, band)
+		res.Quad = &quad
+		rawRect = quad.Rect()
+	}
+	d.debugf("rawRect= %+v\n", rawRect)
+	if rawRect == nil {
+		return res, ErrNoFrameDetected
+	}
+
+	format := d.frameFormat(rawRect)
+	res.Confidence = d.confidence(found, rawRect, format.Aspect, res.Width, res.Height)
+	d.debugf("confidence= %+v\n", res.Confidence)
+	return d.frame(res, rawRect, format), nil
+}
+
+// frameFormat returns the format a frame is corrected towards: the
+// configured one, or with Auto the registered format closest to the frame
+// after the inset
+func (d *Detector) frameFormat(rawRect *RotatedRect) Format {
+	format := d.opts.Format
+	if format.isAuto() {
+		insetRect := d.inset(rawRect)
+		format = ClosestFormat(float64(insetRect.Size.X), float64(insetRect.Size.Y))
+		d.debugf("auto format= %s (%s)\n", format.Name, format.Description)
+	}
+	return format
+}
+
+// inset shrinks rawRect by InsetPercent of its mean side
+func (d *Detector) inset(rawRect *RotatedRect) *RotatedRect {
+	// Average height and width to get constant inset
+	insetPixels := ((rawRect.Size.X + rawRect.Size.Y) / 2.0) * float32(d.opts.InsetPercent)
+
+	return &RotatedRect{
+		Center: rawRect.Center,
+		Size:   Point2f{X: rawRect.Size.X - insetPixels, Y: rawRect.Size.Y - insetPixels},
+		Angle:  rawRect.Angle,
+	}
+}
+
+// frame completes res from the detected frame rawRect: the inset, the
+// correction towards format, the crop box and the rotation
+func (d *Detector) frame(res Result, rawRect *RotatedRect, format Format) Result {
+	insetRect := d.inset(rawRect)
+	res.Format = format.Name
+
+	rect, aspectChanged := d.correctAspectRatio(insetRect, format.Aspect, d.opts.MaxAspectDifference)
+	d.debugf("insetRect= %+v rectCorrected= %+v aspectChanged= %v\n", insetRect, rect, aspectChanged)
+
+	crop := calculateCropCoordinates(rect, res.Height, res.Width)
+
+	// Enforce the exact format aspect ratio if requested
+	if d.opts.EnforceAspect {
+		crop = d.enforceAspectRatio(crop, format.Aspect, res.Width, res.Height)
+	}
+
+	// Final inward crop preserving aspect ratio
+	prev := crop
+	crop = shrinkCropUniform(crop, d.opts.FinalShrink)
+	d.debugf("final %g%% shrink from %v to %v\n", d.opts.FinalShrink*100, prev, crop)
+
+	// Rotation for Lightroom
+	rotation := -rect.Angle
+	if rotation > 45 {
+		rotation -= 90
+	} else if rotation < -90 {
+		rotation += 45
+	}
+
+	d.debugf("rotation= %f\n", rotation)
+	d.debugf("crops LRTB= %f %f %f %f\n", crop.Left, crop.Right, crop.Top, crop.Bottom)
+
+	res.RawRect = rawRect
+	res.InsetRect = insetRect
+	res.Rect = rect
+	res.AspectCorrected = aspectChanged
+	res.Crop = crop
+	res.Rotation = rotation
+	return res
+}
+
+func (d *Detector) debugf(format string, args ...interface{}) {
+	if d.opts.Verbose {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
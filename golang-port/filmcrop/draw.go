@@ -0,0 +1,54 @@
+package filmcrop
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// DrawOverlay draws the detected, refined, inset and corrected frames onto img
+func DrawOverlay(img gocv.Mat, res Result) {
+	if !res.Found() {
+		return
+	}
+
+	// Draw original detected area in blue
+	drawRotatedRect(img, res.RawRect, color.RGBA{255, 0, 0, 255}, 1)
+
+	// Draw the refined quadrilateral in magenta
+	if res.Quad != nil {
+		points := make([]image.Point, len(res.Quad))
+		for i, c := range res.Quad {
+			points[i] = image.Point{X: int(math.Round(float64(c.X))), Y: int(math.Round(float64(c.Y)))}
+		}
+		for i := range points {
+			gocv.Line(&img, points[i], points[(i+1)%len(points)], color.RGBA{255, 0, 255, 255}, 1)
+		}
+	}
+
+	// Draw inset area in cyan
+	drawRotatedRect(img, res.InsetRect, color.RGBA{0, 255, 255, 255}, 1)
+
+	// Draw adjusted aspect ratio area in green
+	drawRotatedRect(img, res.Rect, color.RGBA{0, 255, 0, 255}, 2)
+
+	// Draw center point
+	center := image.Point{X: int(res.Rect.Center.X), Y: int(res.Rect.Center.Y)}
+	gocv.Circle(&img, center, 3, color.RGBA{0, 255, 0, 255}, 3)
+}
+
+func drawRotatedRect(img gocv.Mat, rect *RotatedRect, clr color.RGBA, thickness int) {
+	if rect == nil {
+		return
+	}
+
+	// Draw lines between consecutive points
+	points := rect.intCorners()
+	for i := 0; i < len(points); i++ {
+		start := points[i]
+		end := points[(i+1)%len(points)]
+		gocv.Line(&img, start, end, clr, thickness)
+	}
+}
@@ -0,0 +1,21 @@
+package filmcrop
+
+import "errors"
+
+// Errors reported while processing a scan. Callers should test for them with
+// errors.Is, as they are usually wrapped with the offending path.
+var (
+	// ErrNotFound means the input file does not exist
+	ErrNotFound = errors.New("file not found")
+	// ErrDecode means the input could not be read as an image
+	ErrDecode = errors.New("failed to decode image")
+	// ErrNoFrameDetected means no exposed frame could be found on the scan
+	ErrNoFrameDetected = errors.New("no frame detected")
+	// ErrWriteFailed means an output file could not be written
+	ErrWriteFailed = errors.New("failed to write output")
+	// ErrNoFilmBase means no bare film base could be sampled around a frame
+	ErrNoFilmBase = errors.New("no film base found")
+	// ErrNoQuad means a perspective correction was asked for on a result
+	// whose frame was not refined to a quadrilateral
+	ErrNoQuad = errors.New("frame was not refined to a quadrilateral")
+)
@@ -0,0 +1,247 @@
+package filmcrop
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// FilmType is the kind of film on a scan. It decides whether the scan is
+// inverted for detection and which areas are masked out as holder or
+// backlight.
+type FilmType string
+
+const (
+	// FilmAuto classifies every scan from the colors of its border
+	FilmAuto FilmType = "auto"
+	// FilmC41 is color negative film with an orange mask
+	FilmC41 FilmType = "c41"
+	// FilmBW is black and white negative film with a clear or grey base
+	FilmBW FilmType = "bw"
+	// FilmE6 is slide film, whose unexposed rebate is black
+	FilmE6 FilmType = "e6"
+	// FilmPrint is a print or other positive scanned against a dark background
+	FilmPrint FilmType = "print"
+)
+
+// filmTypes lists the types a scan can be classified as, in tie-break order
+var filmTypes = []FilmType{FilmC41, FilmBW, FilmE6, FilmPrint}
+
+// ParseFilmType maps a film type name (auto, c41, bw, e6 or print) to its
+// FilmType. Names are matched case-insensitively.
+func ParseFilmType(name string) (FilmType, error) {
+	t := FilmType(strings.ToLower(name))
+	if t == FilmAuto {
+		return t, nil
+	}
+	for _, known := range filmTypes {
+		if t == known {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown film type %q (known: %s)", name, strings.Join(FilmTypeNames(), ", "))
+}
+
+// FilmTypeNames returns the names accepted by ParseFilmType
+func FilmTypeNames() []string {
+	names := []string{string(FilmAuto)}
+	for _, t := range filmTypes {
+		names = append(names, string(t))
+	}
+	return names
+}
+
+// Polarity is "negative" for negative films and "positive" otherwise
+func (t FilmType) Polarity() string {
+	if t == FilmC41 || t == FilmBW {
+		return "negative"
+	}
+	return "positive"
+}
+
+// Classification is the film type found for a scan
+type Classification struct {
+	Type FilmType
+	// Confidence is in [0,1]: how clearly the best type beat the runner-up.
+	// It is 1 when the type was forced.
+	Confidence float64
+}
+
+// borderStats describes the film visible around the frame, ignoring clipped
+// backlight
+type borderStats struct {
+	// gray and saturation are means on the 0-255 scale
+	gray, saturation float64
+	// hue is the saturation-weighted mean hue in degrees, 0-360
+	hue float64
+	// dark is the fraction of pixels dark enough to be an unexposed slide rebate
+	dark float64
+	// pixels is the number of pixels measured
+	pixels int
+}
+
+const (
+	// clippedLevel is the channel value from which every channel counts as
+	// bare backlight rather than film
+	clippedLevel = 245
+	// rebateLevel is the gray level below which a pixel counts as black rebate
+	rebateLevel = 40
+)
+
+// classifyFilm decides the film type of img, an 8-bit BGR scan, unless
+// Options.FilmType forces one
+func (d *Detector) classifyFilm(img gocv.Mat) Classification {
+	if t := d.opts.FilmType; t != "" && t != FilmAuto {
+		return Classification{Type: t, Confidence: 1}
+	}
+
+	st := measureBorder(img)
+	scores := map[FilmType]float64{
+		// The orange mask keeps the base saturated and orange even where
+		// the film is underexposed
+		FilmC41: ramp(st.saturation, 30, 80) * orangeness(st.hue) * ramp(st.gray, 60, 120),
+		// A clear or grey base is neutral and lets most light through
+		FilmBW: (1 - ramp(st.saturation, 15, 45)) * ramp(st.gray, 70, 140),
+		// Unexposed slide film is nearly opaque
+		FilmE6: ramp(st.dark, 0.3, 0.8),
+		// A print lies on a dark but not black scanner lid or mat
+		FilmPrint: (1 - ramp(st.gray, 70, 140)) * (1 - ramp(st.dark, 0.3, 0.8)),
+	}
+
+	ranked := append([]FilmType(nil), filmTypes...)
+	sort.SliceStable(ranked, func(i, j int) bool { return scores[ranked[i]] > scores[ranked[j]] })
+	best, second := scores[ranked[0]], scores[ranked[1]]
+
+	c := Classification{Type: ranked[0], Confidence: clamp01(best - second)}
+	if best < 0.2 {
+		// Nothing fits: fall back to the brightness of the border
+		c = Classification{Type: FilmPrint}
+		if st.gray >= 150 {
+			c.Type = FilmBW
+			if st.saturation > 30 {
+				c.Type = FilmC41
+			}
+		}
+	}
+
+	d.debugf("film border gray= %.1f sat= %.1f hue= %.0f dark= %.2f pixels= %d\n", st.gray, st.saturation, st.hue, st.dark, st.pixels)
+	d.debugf("film scores c41= %.2f bw= %.2f e6= %.2f print= %.2f => %s (%.2f)\n",
+		scores[FilmC41], scores[FilmBW], scores[FilmE6], scores[FilmPrint], c.Type, c.Confidence)
+	return c
+}
+
+// measureBorder collects color statistics from bands along the edges of img.
+// Clipped backlight, such as a light table around a slide, says nothing about
+// the film, so the bands widen until enough other pixels are found.
+func measureBorder(img gocv.Mat) borderStats {
+	h, w := img.Rows(), img.Cols()
+	short := float64(min(h, w))
+
+	var st borderStats
+	for _, depth := range []float64{0.02, 0.05, 0.1, 0.2} {
+		band := int(math.Max(5, short*depth))
+		band = min(band, h/2, w/2)
+
+		var acc borderAccumulator
+		for _, r := range []image.Rectangle{
+			image.Rect(0, 0, w, band),
+			image.Rect(0, h-band, w, h),
+			image.Rect(0, band, band, h-band),
+			image.Rect(w-band, band, w, h-band),
+		} {
+			acc.add(img, r)
+		}
+		st = acc.stats()
+		if float64(st.pixels) >= 0.25*float64(acc.total) {
+			break
+		}
+	}
+	return st
+}
+
+type borderAccumulator struct {
+	total, pixels    int
+	gray, saturation float64
+	hueX, hueY, dark float64
+}
+
+func (a *borderAccumulator) add(img gocv.Mat, rect image.Rectangle) {
+	if rect.Empty() {
+		return
+	}
+	region := img.Region(rect)
+	band := region.Clone()
+	region.Close()
+	defer band.Close()
+
+	data := band.ToBytes()
+	for i := 0; i+2 < len(data); i += 3 {
+		b, g, r := float64(data[i]), float64(data[i+1]), float64(data[i+2])
+		a.total++
+
+		hi := math.Max(b, math.Max(g, r))
+		lo := math.Min(b, math.Min(g, r))
+		if lo >= clippedLevel {
+			continue
+		}
+		a.pixels++
+
+		gray := 0.114*b + 0.587*g + 0.299*r
+		a.gray += gray
+		if gray < rebateLevel {
+			a.dark++
+		}
+
+		if hi == 0 || hi == lo {
+			continue
+		}
+		sat := (hi - lo) / hi * 255
+		a.saturation += sat
+
+		var hue float64
+		switch hi {
+		case r:
+			hue = 60 * (g - b) / (hi - lo)
+		case g:
+			hue = 120 + 60*(b-r)/(hi-lo)
+		default:
+			hue = 240 + 60*(r-g)/(hi-lo)
+		}
+		rad := hue * math.Pi / 180
+		a.hueX += sat * math.Cos(rad)
+		a.hueY += sat * math.Sin(rad)
+	}
+}
+
+func (a *borderAccumulator) stats() borderStats {
+	if a.pixels == 0 {
+		return borderStats{gray: 255}
+	}
+	n := float64(a.pixels)
+	hue := math.Atan2(a.hueY, a.hueX) * 180 / math.Pi
+	if hue < 0 {
+		hue += 360
+	}
+	return borderStats{
+		gray:       a.gray / n,
+		saturation: a.saturation / n,
+		hue:        hue,
+		dark:       a.dark / n,
+		pixels:     a.pixels,
+	}
+}
+
+// orangeness is 1 for the hue of a typical orange mask, around 30°, falling
+// to 0 at red and yellow-green
+func orangeness(hue float64) float64 {
+	return clamp01(1 - math.Abs(hue-30)/30)
+}
+
+// ramp rises linearly from 0 at lo to 1 at hi
+func ramp(v, lo, hi float64) float64 {
+	return clamp01((v - lo) / (hi - lo))
+}
@@ -0,0 +1,80 @@
+package filmcrop
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Format is a film format with the aspect ratio of its image area
+type Format struct {
+	// Name is the identifier used on the command line
+	Name string
+	// Description is a human readable summary
+	Description string
+	// Aspect is the long side divided by the short side
+	Aspect float64
+}
+
+// Auto is not a real format: it asks the detector to pick the registered
+// format closest to each detected frame
+var Auto = Format{Name: "auto", Description: "closest registered format"}
+
+// Formats lists the registered film formats. When two formats share an
+// aspect ratio auto detection prefers the one listed first.
+var Formats = []Format{
+	{Name: "135", Description: "35mm full frame, 36x24mm", Aspect: 36.0 / 24.0},
+	{Name: "half-frame", Description: "35mm half frame, 24x18mm", Aspect: 24.0 / 18.0},
+	{Name: "645", Description: "120 6x4.5, 56x41.5mm", Aspect: 56.0 / 41.5},
+	{Name: "6x6", Description: "120 6x6, 56x56mm", Aspect: 1.0},
+	{Name: "6x7", Description: "120 6x7, 69.5x56mm", Aspect: 69.5 / 56.0},
+	{Name: "6x9", Description: "120 6x9, 84x56mm", Aspect: 84.0 / 56.0},
+	{Name: "xpan", Description: "Hasselblad XPan panorama, 65x24mm", Aspect: 65.0 / 24.0},
+	{Name: "4x5", Description: "4x5 inch sheet film, 120x95mm", Aspect: 120.0 / 95.0},
+}
+
+// LookupFormat returns the registered format called name, or Auto for "auto".
+// Names are matched case-insensitively.
+func LookupFormat(name string) (Format, error) {
+	name = strings.ToLower(name)
+	if name == Auto.Name {
+		return Auto, nil
+	}
+	for _, f := range Formats {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return Format{}, fmt.Errorf("unknown film format %q (known: %s)", name, strings.Join(FormatNames(), ", "))
+}
+
+// FormatNames returns the names accepted by LookupFormat
+func FormatNames() []string {
+	names := []string{Auto.Name}
+	for _, f := range Formats {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// ClosestFormat returns the registered format whose aspect ratio is nearest
+// to that of a w x h frame, in either orientation
+func ClosestFormat(w, h float64) Format {
+	aspect := math.Max(w, h) / math.Min(w, h)
+
+	best := Formats[0]
+	bestErr := math.Inf(1)
+	for _, f := range Formats {
+		// Compare on a log scale so 1.1 vs 1.0 weighs like 2.2 vs 2.0
+		e := math.Abs(math.Log(aspect / f.Aspect))
+		if e < bestErr {
+			best, bestErr = f, e
+		}
+	}
+	return best
+}
+
+// isAuto reports whether f asks for automatic format selection
+func (f Format) isAuto() bool {
+	return f.Name == Auto.Name
+}
@@ -0,0 +1,281 @@
+package filmcrop
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// FrameDetector is one way of finding the frame on a scan. Detect prepares
+// the scan, classifying the film and building the masks, and hands it to
+// the FrameDetector of its Options.
+type FrameDetector interface {
+	// Name identifies the detector on the command line
+	Name() string
+	// FindFrame returns the frame found on s. Candidate.Rect is nil when
+	// there is none.
+	FindFrame(s *Scan) Candidate
+}
+
+// Scan is a scan prepared for a FrameDetector. All images have the size of
+// the analysed image and are only valid during FindFrame.
+type Scan struct {
+	// Image is the 8-bit BGR scan, inverted for positive film so that the
+	// rebate is brighter than the frame on every scan
+	Image gocv.Mat
+	// Gray is Image in gray, smoothed and equalized
+	Gray gocv.Mat
+	// Mask is 255 where a pixel may be film and 0 on clipped backlight and
+	// on whatever the film type rules out, such as a holder
+	Mask gocv.Mat
+	// Film is the film type the scan is processed as
+	Film Classification
+	// Scale is the size of the images relative to the full resolution scan,
+	// used to keep filter and kernel sizes consistent on proxies
+	Scale float64
+	// Options are the settings of the Detector
+	Options Options
+}
+
+// maxArea is the area of the largest frame allowed by MaxCoverage
+func (s *Scan) maxArea() float64 {
+	return float64(s.Gray.Rows()) * s.Options.MaxCoverage * float64(s.Gray.Cols()) * s.Options.MaxCoverage
+}
+
+// minCaptureArea is the area below which a frame is too small to be the
+// exposure rather than part of the picture
+func (s *Scan) minCaptureArea() float64 {
+	return s.maxArea() * 0.65
+}
+
+func (s *Scan) debugf(format string, args ...interface{}) {
+	if s.Options.Verbose {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// Candidate is the frame found by a FrameDetector with the evidence behind
+// it, from which the confidence of the detection is rated
+type Candidate struct {
+	Rect *RotatedRect
+	// Agreeing holds the estimates that support Rect, such as the threshold
+	// passes that found a frame of plausible size. It is empty when Rect is
+	// a fallback.
+	Agreeing []*RotatedRect
+	// Passes is the number of estimates the detector made
+	Passes int
+	// Detectors names the detectors that found Rect, more than one when an
+	// Ensemble voted
+	Detectors []string
+}
+
+// FrameDetectors lists the detectors selectable by name, the default first
+var FrameDetectors = []FrameDetector{
+	ThresholdDetector{},
+	HoughDetector{},
+	ProjectionDetector{},
+	Ensemble{Members: []FrameDetector{ThresholdDetector{}, HoughDetector{}, ProjectionDetector{}}},
+}
+
+// LookupFrameDetector returns the detector called name from FrameDetectors.
+// Names are matched case-insensitively.
+func LookupFrameDetector(name string) (FrameDetector, error) {
+	name = strings.ToLower(name)
+	for _, fd := range FrameDetectors {
+		if fd.Name() == name {
+			return fd, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown frame detector %q (known: %s)", name, strings.Join(FrameDetectorNames(), ", "))
+}
+
+// FrameDetectorNames returns the names accepted by LookupFrameDetector
+func FrameDetectorNames() []string {
+	names := make([]string, len(FrameDetectors))
+	for i, fd := range FrameDetectors {
+		names[i] = fd.Name()
+	}
+	return names
+}
+
+// Ensemble runs several detectors and votes: the frame found by the most
+// members that agree with each other wins, and the agreeing frames are
+// merged. Ties go to the member whose own estimates agreed best, then to
+// the member listed first.
+type Ensemble struct {
+	Members []FrameDetector
+}
+
+// Name returns "ensemble"
+func (e Ensemble) Name() string {
+	return "ensemble"
+}
+
+// FindFrame runs every member on s and returns the frame most of them agree on
+func (e Ensemble) FindFrame(s *Scan) Candidate {
+	var found []Candidate
+	passes := 0
+	for _, m := range e.Members {
+		c := m.FindFrame(s)
+		s.debugf("ensemble: %s found %+v\n", m.Name(), c.Rect)
+		passes += c.Passes
+		if c.Rect != nil {
+			if len(c.Detectors) == 0 {
+				c.Detectors = []string{m.Name()}
+			}
+			found = append(found, c)
+		}
+	}
+	best := voteCandidates(found)
+	if best < 0 {
+		return Candidate{Passes: passes}
+	}
+
+	out := Candidate{Passes: passes}
+	var rects []*RotatedRect
+	for _, c := range found {
+		if rectsAgree(found[best].Rect, c.Rect) {
+			rects = append(rects, uprightRect(c.Rect))
+			for _, r := range c.Agreeing {
+				out.Agreeing = append(out.Agreeing, uprightRect(r))
+			}
+			out.Detectors = append(out.Detectors, c.Detectors...)
+		}
+	}
+	out.Rect = medianRect(rects)
+	s.debugf("ensemble: %s agree on %+v\n", strings.Join(out.Detectors, ", "), out.Rect)
+	return out
+}
+
+// voteCandidates returns the index of the candidate that agrees with the
+// most others, or -1 when there are none
+func voteCandidates(found []Candidate) int {
+	best, bestVotes, bestSupport := -1, 0, -1.0
+	for i, c := range found {
+		votes := 0
+		for _, other := range found {
+			if rectsAgree(c.Rect, other.Rect) {
+				votes++
+			}
+		}
+		support := float64(len(c.Agreeing)) / float64(max(1, c.Passes))
+		if votes > bestVotes || (votes == bestVotes && support > bestSupport) {
+			best, bestVotes, bestSupport = i, votes, support
+		}
+	}
+	return best
+}
+
+// maxAgreeAngle is the largest difference in degrees between the angles of
+// two frames that agree
+const maxAgreeAngle = 1.0
+
+// rectsAgree reports whether a and b describe the same frame: their
+// centers and sizes differ by less than maxSpread of their mean side and
+// their angles by less than maxAgreeAngle
+func rectsAgree(a, b *RotatedRect) bool {
+	a, b = uprightRect(a), uprightRect(b)
+	limit := maxSpread * float64(a.Size.X+a.Size.Y+b.Size.X+b.Size.Y) / 4
+	return math.Hypot(float64(a.Center.X-b.Center.X), float64(a.Center.Y-b.Center.Y)) <= limit &&
+		math.Abs(float64(a.Size.X-b.Size.X)) <= limit &&
+		math.Abs(float64(a.Size.Y-b.Size.Y)) <= limit &&
+		math.Abs(a.Angle-b.Angle) <= maxAgreeAngle
+}
+
+// uprightRect returns rect turned by whole quarter turns, swapping its sides,
+// so that its angle lies in (-45, 45]. Frames found by different detectors
+// can only be compared or merged in this form.
+func uprightRect(rect *RotatedRect) *RotatedRect {
+	out := *rect
+	for out.Angle > 45 {
+		out.Angle -= 90
+		out.Size.X, out.Size.Y = out.Size.Y, out.Size.X
+	}
+	for out.Angle <= -45 {
+		out.Angle += 90
+		out.Size.X, out.Size.Y = out.Size.Y, out.Size.X
+	}
+	return &out
+}
+
+// edgeLine is a straight frame edge through a point, with a unit direction
+type edgeLine struct {
+	x, y   float64
+	dx, dy float64
+}
+
+// fitLine fits a line to points by total least squares, each point weighted
+// by the matching entry of weights. ok is false when the points do not set
+// a direction.
+func fitLine(xs, ys, weights []float64) (edgeLine, bool) {
+	var sw, mx, my float64
+	for i := range xs {
+		sw += weights[i]
+		mx += weights[i] * xs[i]
+		my += weights[i] * ys[i]
+	}
+	if sw <= 0 {
+		return edgeLine{}, false
+	}
+	mx /= sw
+	my /= sw
+
+	var sxx, syy, sxy float64
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		sxx += weights[i] * dx * dx
+		syy += weights[i] * dy * dy
+		sxy += weights[i] * dx * dy
+	}
+	if sxx+syy == 0 {
+		return edgeLine{}, false
+	}
+	// Direction of the largest spread
+	theta := 0.5 * math.Atan2(2*sxy, sxx-syy)
+	return edgeLine{x: mx, y: my, dx: math.Cos(theta), dy: math.Sin(theta)}, true
+}
+
+// intersect returns the point where a and b cross; ok is false for nearly
+// parallel lines
+func intersect(a, b edgeLine) (Point2f, bool) {
+	det := a.dx*b.dy - a.dy*b.dx
+	if math.Abs(det) < 1e-6 {
+		return Point2f{}, false
+	}
+	t := ((b.x-a.x)*b.dy - (b.y-a.y)*b.dx) / det
+	return Point2f{X: float32(a.x + t*a.dx), Y: float32(a.y + t*a.dy)}, true
+}
+
+// rectFromEdges returns the rectangle closest to the quadrilateral bounded
+// by four edges, or nil when two neighbouring edges do not cross
+func rectFromEdges(left, top, right, bottom edgeLine) *RotatedRect {
+	q, ok := quadFromEdges(left, top, right, bottom)
+	if !ok {
+		return nil
+	}
+	return q.Rect()
+}
+
+// weightedMedian returns the value below and above which half the weight
+// lies
+func weightedMedian(values, weights []float64) float64 {
+	idx := make([]int, len(values))
+	total := 0.0
+	for i := range idx {
+		idx[i] = i
+		total += weights[i]
+	}
+	sort.Slice(idx, func(a, b int) bool { return values[idx[a]] < values[idx[b]] })
+	acc := 0.0
+	for _, i := range idx {
+		acc += weights[i]
+		if acc >= total/2 {
+			return values[i]
+		}
+	}
+	return 0
+}
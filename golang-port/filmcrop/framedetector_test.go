@@ -0,0 +1,126 @@
+package filmcrop
+
+import (
+	"math"
+	"testing"
+)
+
+// testFrame is the frame the detector tests look for on a 400 x 300 image
+var testFrame = &RotatedRect{Center: Point2f{X: 203.5, Y: 148}, Size: Point2f{X: 320, Y: 220}, Angle: 1.5}
+
+func closeRects(t *testing.T, got, want *RotatedRect, px, deg float64) {
+	t.Helper()
+	if got == nil {
+		t.Fatal("no frame found")
+	}
+	got = uprightRect(got)
+	if math.Hypot(float64(got.Center.X-want.Center.X), float64(got.Center.Y-want.Center.Y)) > px ||
+		math.Abs(float64(got.Size.X-want.Size.X)) > px || math.Abs(float64(got.Size.Y-want.Size.Y)) > px ||
+		math.Abs(got.Angle-want.Angle) > deg {
+		t.Errorf("found %+v, want %+v", got, want)
+	}
+}
+
+func TestRectFromSegments(t *testing.T) {
+	c := testFrame.Corners()
+	// Corners runs bottom right, bottom left, top left, top right
+	br, bl, tl, tr := c[0], c[1], c[2], c[3]
+	along := func(a, b Point2f, from, to float64) segment {
+		return segment{
+			float64(a.X) + from*float64(b.X-a.X), float64(a.Y) + from*float64(b.Y-a.Y),
+			float64(a.X) + to*float64(b.X-a.X), float64(a.Y) + to*float64(b.Y-a.Y),
+		}
+	}
+
+	var segs []segment
+	for _, side := range [][2]Point2f{{tl, tr}, {tr, br}, {br, bl}, {bl, tl}} {
+		// Broken edges, as dust and dark content leave them
+		segs = append(segs, along(side[0], side[1], 0.02, 0.4), along(side[0], side[1], 0.45, 0.98))
+	}
+	segs = append(segs,
+		// A horizon inside the frame, shorter than the edges
+		segment{150, 170, 240, 172},
+		// The film edge outside the frame, tilted differently
+		segment{10, 5, 390, 40},
+		// A diagonal
+		segment{100, 100, 200, 200},
+	)
+
+	closeRects(t, rectFromSegments(segs, 400, 300), testFrame, 0.5, 0.05)
+
+	// Without a bottom edge there is no frame
+	if rect := rectFromSegments(segs[:4], 400, 300); rect != nil {
+		t.Errorf("found %+v from two edges", rect)
+	}
+}
+
+func TestProjectionFrame(t *testing.T) {
+	const w, h = 400, 300
+	gray := make([]float64, w*h)
+	cos := math.Cos(testFrame.Angle * math.Pi / 180)
+	sin := math.Sin(testFrame.Angle * math.Pi / 180)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Signed distance to the frame, turned into a pixel coverage
+			dx, dy := float64(x)-float64(testFrame.Center.X), float64(y)-float64(testFrame.Center.Y)
+			u, v := dx*cos+dy*sin, -dx*sin+dy*cos
+			d := math.Max(math.Abs(u)-float64(testFrame.Size.X)/2, math.Abs(v)-float64(testFrame.Size.Y)/2)
+			inside := math.Max(0, math.Min(1, 0.5-d))
+			// A faint frame: only 12 levels darker than the rebate
+			gray[y*w+x] = 180 - 12*inside + 4*math.Sin(float64(x*7+y*3))
+		}
+	}
+
+	g := &gradientField{w: w, h: h, gx: make([]float32, w*h), gy: make([]float32, w*h), mask: make([]byte, w*h)}
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			at := func(dx, dy int) float64 { return gray[(y+dy)*w+x+dx] }
+			g.gx[y*w+x] = float32(at(1, -1) + 2*at(1, 0) + at(1, 1) - at(-1, -1) - 2*at(-1, 0) - at(-1, 1))
+			g.gy[y*w+x] = float32(at(-1, 1) + 2*at(0, 1) + at(1, 1) - at(-1, -1) - 2*at(0, -1) - at(1, -1))
+			g.mask[y*w+x] = 255
+		}
+	}
+
+	for _, bands := range projectionBands {
+		closeRects(t, g.frame(bands, 3), testFrame, 1, 0.3)
+	}
+}
+
+func TestUprightRect(t *testing.T) {
+	turned := &RotatedRect{Center: testFrame.Center, Size: Point2f{X: testFrame.Size.Y, Y: testFrame.Size.X}, Angle: testFrame.Angle + 90}
+	got := uprightRect(turned)
+	if got.Size != testFrame.Size || math.Abs(got.Angle-testFrame.Angle) > 1e-9 {
+		t.Errorf("uprightRect(%+v) = %+v, want %+v", turned, got, testFrame)
+	}
+	if !rectsAgree(turned, testFrame) {
+		t.Error("a frame turned a quarter does not agree with itself")
+	}
+}
+
+func TestVoteCandidates(t *testing.T) {
+	moved := func(dx float32, angle float64) *RotatedRect {
+		r := *testFrame
+		r.Center.X += dx
+		r.Angle += angle
+		return &r
+	}
+	found := []Candidate{
+		{Rect: moved(60, 0), Agreeing: make([]*RotatedRect, 40), Passes: 48},
+		{Rect: moved(1, 0.2), Agreeing: make([]*RotatedRect, 2), Passes: 4},
+		{Rect: moved(-1, 0), Agreeing: make([]*RotatedRect, 3), Passes: 3},
+	}
+	// The two that agree outvote the one with the most passes
+	if best := voteCandidates(found); best != 2 {
+		t.Errorf("voted for %d, want 2", best)
+	}
+
+	// With no agreement the best supported candidate wins
+	found[2].Rect = moved(-60, 0)
+	found[2].Agreeing = found[2].Agreeing[:1]
+	if best := voteCandidates(found); best != 0 {
+		t.Errorf("voted for %d, want 0", best)
+	}
+	if best := voteCandidates(nil); best != -1 {
+		t.Errorf("voted for %d without candidates", best)
+	}
+}
@@ -0,0 +1,331 @@
+package filmcrop
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// Point2f represents a 2D point with float coordinates
+type Point2f struct {
+	X, Y float32
+}
+
+// RotatedRect represents a rotated rectangle
+type RotatedRect struct {
+	Center Point2f
+	Size   Point2f
+	Angle  float64
+}
+
+// Crop is a crop box in normalized [0,1] image coordinates
+type Crop struct {
+	Left, Right, Top, Bottom float64
+}
+
+// FullFrame is the crop that keeps the whole image
+var FullFrame = Crop{Left: 0, Right: 1, Top: 0, Bottom: 1}
+
+// Retained returns the fraction of the image area kept by the crop
+func (c Crop) Retained() float64 {
+	return math.Max(0.0, (c.Right-c.Left)*(c.Bottom-c.Top))
+}
+
+// Pixels converts the crop to a pixel rectangle clamped to a w x h image
+func (c Crop) Pixels(w, h int) image.Rectangle {
+	x0 := int(math.Max(0, math.Min(float64(w-1), c.Left*float64(w))))
+	x1 := int(math.Max(0, math.Min(float64(w), c.Right*float64(w))))
+	y0 := int(math.Max(0, math.Min(float64(h-1), c.Top*float64(h))))
+	y1 := int(math.Max(0, math.Min(float64(h), c.Bottom*float64(h))))
+	return image.Rect(x0, y0, x1, y1)
+}
+
+// Corners returns the four corners of the rectangle
+func (r *RotatedRect) Corners() [4]Point2f {
+	cos := math.Cos(r.Angle * math.Pi / 180)
+	sin := math.Sin(r.Angle * math.Pi / 180)
+
+	halfW := float64(r.Size.X) / 2
+	halfH := float64(r.Size.Y) / 2
+
+	cx := float64(r.Center.X)
+	cy := float64(r.Center.Y)
+
+	return [4]Point2f{
+		{X: float32(cx + halfW*cos - halfH*sin), Y: float32(cy + halfW*sin + halfH*cos)},
+		{X: float32(cx - halfW*cos - halfH*sin), Y: float32(cy - halfW*sin + halfH*cos)},
+		{X: float32(cx - halfW*cos + halfH*sin), Y: float32(cy - halfW*sin - halfH*cos)},
+		{X: float32(cx + halfW*cos + halfH*sin), Y: float32(cy + halfW*sin - halfH*cos)},
+	}
+}
+
+func (r *RotatedRect) intCorners() []image.Point {
+	corners := r.Corners()
+	points := make([]image.Point, len(corners))
+	for i, c := range corners {
+		points[i] = image.Point{X: int(c.X), Y: int(c.Y)}
+	}
+	return points
+}
+
+func normalizeRectRotation(rawRects []*RotatedRect) []*RotatedRect {
+	var rects []*RotatedRect
+	for _, rect := range rawRects {
+		newRect := &RotatedRect{
+			Center: rect.Center,
+			Size:   rect.Size,
+			Angle:  rect.Angle,
+		}
+
+		if newRect.Angle < -45 {
+			newRect.Size = Point2f{X: rect.Size.Y, Y: rect.Size.X}
+			newRect.Angle = rect.Angle + 90
+		}
+		rects = append(rects, newRect)
+	}
+	return rects
+}
+
+func medianRect(rects []*RotatedRect) *RotatedRect {
+	if len(rects) == 0 {
+		return nil
+	}
+
+	normalized := normalizeRectRotation(rects)
+
+	// Sort by area
+	sort.Slice(normalized, func(i, j int) bool {
+		areaI := float64(normalized[i].Size.X * normalized[i].Size.Y)
+		areaJ := float64(normalized[j].Size.X * normalized[j].Size.Y)
+		return areaI < areaJ
+	})
+
+	// Calculate medians
+	var centerX, centerY, sizeX, sizeY, angles []float64
+	for _, r := range normalized {
+		centerX = append(centerX, float64(r.Center.X))
+		centerY = append(centerY, float64(r.Center.Y))
+		sizeX = append(sizeX, float64(r.Size.X))
+		sizeY = append(sizeY, float64(r.Size.Y))
+		angles = append(angles, r.Angle)
+	}
+
+	return &RotatedRect{
+		Center: Point2f{X: float32(median(centerX)), Y: float32(median(centerY))},
+		Size:   Point2f{X: float32(median(sizeX)), Y: float32(median(sizeY))},
+		Angle:  median(angles),
+	}
+}
+
+func (d *Detector) correctAspectRatio(rect *RotatedRect, targetRatio, maxDifference float64) (*RotatedRect, bool) {
+	size := rect.Size
+	aspectRatio := math.Max(float64(size.X), float64(size.Y)) / math.Min(float64(size.X), float64(size.Y))
+	aspectError := targetRatio - aspectRatio
+
+	// Factor out orientation to simplify logic
+	var rectWidth, rectHeight float32
+	var widthIsX bool
+
+	if size.X >= size.Y {
+		rectWidth = size.X
+		rectHeight = size.Y
+		widthIsX = true
+	} else {
+		rectHeight = size.X
+		rectWidth = size.Y
+		widthIsX = false
+	}
+
+	// Only attempt to correct aspect ratio where the ROI is roughly right already
+	if math.Abs(aspectError) > maxDifference {
+		return rect, false
+	}
+
+	// Adjust dimensions
+	if aspectRatio > targetRatio {
+		d.debugf("ratio too large %f\n", aspectError)
+		rectWidth = rectHeight * float32(targetRatio)
+	} else if aspectRatio < targetRatio {
+		d.debugf("ratio too small %f\n", aspectError)
+		rectHeight = rectWidth / float32(targetRatio)
+	}
+
+	// Apply new width/height in the original orientation
+	var newSize Point2f
+	if widthIsX {
+		newSize = Point2f{X: rectWidth, Y: rectHeight}
+	} else {
+		newSize = Point2f{X: rectHeight, Y: rectWidth}
+	}
+
+	newRect := &RotatedRect{
+		Center: rect.Center,
+		Size:   newSize,
+		Angle:  rect.Angle,
+	}
+
+	return newRect, true
+}
+
+func calculateCropCoordinates(rect *RotatedRect, imgHeight, imgWidth int) Crop {
+	cx := float64(rect.Center.X)
+	cy := float64(rect.Center.Y)
+
+	// Find bounding box, keeping the corners to a fraction of a pixel
+	var left, right, top, bottom []float64
+	for _, point := range rect.Corners() {
+		x, y := float64(point.X), float64(point.Y)
+		if x > cx {
+			right = append(right, x)
+		} else {
+			left = append(left, x)
+		}
+
+		if y > cy {
+			bottom = append(bottom, y)
+		} else {
+			top = append(top, y)
+		}
+	}
+
+	return Crop{
+		Left:   maxFloat(left) / float64(imgWidth),
+		Right:  minFloat(right) / float64(imgWidth),
+		Top:    maxFloat(top) / float64(imgHeight),
+		Bottom: minFloat(bottom) / float64(imgHeight),
+	}
+}
+
+func (d *Detector) enforceAspectRatio(crop Crop, aspect float64, imgWidth, imgHeight int) Crop {
+	// Convert normalized crop bounds to pixel units
+	x0 := crop.Left * float64(imgWidth)
+	x1 := crop.Right * float64(imgWidth)
+	y0 := crop.Top * float64(imgHeight)
+	y1 := crop.Bottom * float64(imgHeight)
+
+	// Current crop width/height in pixels
+	w := math.Max(0.0, x1-x0)
+	h := math.Max(0.0, y1-y0)
+	if w <= 0.0 || h <= 0.0 {
+		return crop
+	}
+
+	r := w / h
+	landscape := aspect
+	portrait := 1.0 / aspect
+
+	// Choose the nearest target ratio
+	var target float64
+	if math.Abs(r-landscape) <= math.Abs(r-portrait) {
+		target = landscape
+	} else {
+		target = portrait
+	}
+
+	// Option A: keep height, reduce width to target
+	wKeepH := math.Min(w, target*h)
+	areaA := wKeepH * h
+
+	// Option B: keep width, reduce height to target
+	hKeepW := math.Min(h, w/target)
+	areaB := w * hKeepW
+
+	// Pick option that preserves larger area
+	var decision string
+	if areaA >= areaB {
+		newW := wKeepH
+		deltaW := w - newW
+		x0 += deltaW / 2.0
+		x1 -= deltaW / 2.0
+		decision = "reduce-width"
+	} else {
+		newH := hKeepW
+		deltaH := h - newH
+		y0 += deltaH / 2.0
+		y1 -= deltaH / 2.0
+		decision = "reduce-height"
+	}
+
+	// Convert back to normalized [0,1]
+	crop = Crop{
+		Left:   math.Max(0.0, math.Min(1.0, x0/float64(imgWidth))),
+		Right:  math.Max(0.0, math.Min(1.0, x1/float64(imgWidth))),
+		Top:    math.Max(0.0, math.Min(1.0, y0/float64(imgHeight))),
+		Bottom: math.Max(0.0, math.Min(1.0, y1/float64(imgHeight))),
+	}
+
+	if d.opts.Verbose {
+		newWPx := math.Max(0.0, (crop.Right-crop.Left)*float64(imgWidth))
+		newHPx := math.Max(0.0, (crop.Bottom-crop.Top)*float64(imgHeight))
+		var newR float64
+		if newHPx == 0 {
+			newR = math.Inf(1)
+		} else {
+			newR = newWPx / newHPx
+		}
+		d.debugf("aspect enforce (px): current= %f target= %f decision= %s new_ratio= %f\n", r, target, decision, newR)
+	}
+	return crop
+}
+
+func shrinkCropUniform(crop Crop, percent float64) Crop {
+	width := math.Max(0.0, crop.Right-crop.Left)
+	height := math.Max(0.0, crop.Bottom-crop.Top)
+	if width <= 0.0 || height <= 0.0 {
+		return crop
+	}
+
+	scale := math.Max(0.0, 1.0-percent)
+	cx := (crop.Left + crop.Right) / 2.0
+	cy := (crop.Top + crop.Bottom) / 2.0
+	halfW := (width * scale) / 2.0
+	halfH := (height * scale) / 2.0
+
+	// Clamp
+	return Crop{
+		Left:   math.Max(0.0, math.Min(1.0, cx-halfW)),
+		Right:  math.Max(0.0, math.Min(1.0, cx+halfW)),
+		Top:    math.Max(0.0, math.Min(1.0, cy-halfH)),
+		Bottom: math.Max(0.0, math.Min(1.0, cy+halfH)),
+	}
+}
+
+// Utility functions
+
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[n/2]
+}
+
+func minFloat(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func maxFloat(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	max := values[0]
+	for _, v := range values[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
@@ -0,0 +1,208 @@
+package filmcrop
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"gocv.io/x/gocv"
+)
+
+// HoughDetector finds the four straight edges of the frame in a Canny edge
+// map with a probabilistic Hough transform. It needs no contrast between the
+// whole frame and the rebate, only a straight step along each side, so it
+// copes with low contrast frames whose content blends into the rebate.
+type HoughDetector struct{}
+
+// Name returns "hough"
+func (HoughDetector) Name() string {
+	return "hough"
+}
+
+// cannyThresholds are the lower Canny thresholds tried, from faint to strong
+// edges; the upper threshold is three times the lower
+var cannyThresholds = []float32{15, 30, 50, 80}
+
+const (
+	// maxEdgeTilt is the largest angle in degrees between a line and the
+	// nearest image axis for it to count as a frame edge
+	maxEdgeTilt = 20
+	// maxTiltSpread is how far in degrees a line may tilt from the dominant
+	// tilt of all lines and still be used
+	maxTiltSpread = 1.5
+	// minEdgeSpan is the shortest total length of the lines making up an
+	// edge, as a fraction of the shorter image side
+	minEdgeSpan = 0.3
+)
+
+// FindFrame fits the four dominant edges on s at each Canny threshold and
+// returns the median of the frames of plausible size
+func (HoughDetector) FindFrame(s *Scan) Candidate {
+	w, h := s.Gray.Cols(), s.Gray.Rows()
+	short := float64(min(w, h))
+
+	// The border of the mask is the edge of the backlight or a holder
+	// rather than the frame, so keep clear of it
+	mask := erodedMask(s)
+	defer mask.Close()
+
+	var rects []*RotatedRect
+	for _, low := range cannyThresholds {
+		edges := gocv.NewMat()
+		gocv.Canny(s.Gray, &edges, low, 3*low)
+		gocv.BitwiseAnd(edges, mask, &edges)
+
+		lines := gocv.NewMat()
+		minLength := 0.2 * short
+		gocv.HoughLinesPWithParams(edges, &lines, 1, math.Pi/720, int(minLength/4), float32(minLength), float32(0.02*short))
+		segs := make([]segment, 0, lines.Rows())
+		for i := 0; i < lines.Rows(); i++ {
+			v := lines.GetVeciAt(i, 0)
+			segs = append(segs, segment{float64(v[0]), float64(v[1]), float64(v[2]), float64(v[3])})
+		}
+		lines.Close()
+		edges.Close()
+
+		rect := rectFromSegments(segs, w, h)
+		s.debugf("hough canny= %g segments= %d rect= %+v\n", low, len(segs), rect)
+		if rect != nil && float64(rect.Size.X*rect.Size.Y) >= s.minCaptureArea() {
+			rects = append(rects, rect)
+		}
+	}
+	return Candidate{Rect: medianRect(rects), Agreeing: rects, Passes: len(cannyThresholds)}
+}
+
+// erodedMask returns the mask of s shrunk away from the areas it excludes
+func erodedMask(s *Scan) gocv.Mat {
+	k := scaledKernel(7, s.Scale)
+	kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Point{k, k})
+	defer kernel.Close()
+	mask := gocv.NewMat()
+	gocv.Erode(s.Mask, &mask, kernel)
+	return mask
+}
+
+// segment is a line segment between two points
+type segment struct {
+	x1, y1, x2, y2 float64
+}
+
+func (s segment) length() float64 {
+	return math.Hypot(s.x2-s.x1, s.y2-s.y1)
+}
+
+// rectFromSegments picks the four frame edges of a w x h image from line
+// segments. Segments close to an axis vote for the tilt of the frame; those
+// that agree with it are grouped into parallel lines, and on each side of
+// the image center the line with the most length becomes the frame edge.
+// It returns nil unless all four edges are found.
+func rectFromSegments(segs []segment, w, h int) *RotatedRect {
+	var across, down []segment
+	var acrossTilts, downTilts []float64
+	var tilts, weights []float64
+	for _, s := range segs {
+		a := math.Atan2(s.y2-s.y1, s.x2-s.x1) * 180 / math.Pi
+		if a <= -90 {
+			a += 180
+		} else if a > 90 {
+			a -= 180
+		}
+		var tilt float64
+		switch {
+		case math.Abs(a) <= maxEdgeTilt:
+			tilt = a
+			across = append(across, s)
+			acrossTilts = append(acrossTilts, tilt)
+		case math.Abs(a) >= 90-maxEdgeTilt:
+			tilt = a + 90
+			if a > 0 {
+				tilt = a - 90
+			}
+			down = append(down, s)
+			downTilts = append(downTilts, tilt)
+		default:
+			continue
+		}
+		tilts = append(tilts, tilt)
+		weights = append(weights, s.length())
+	}
+	if len(tilts) == 0 {
+		return nil
+	}
+	tilt := weightedMedian(tilts, weights)
+	sin, cos := math.Sincos(tilt * math.Pi / 180)
+	cx, cy := float64(w)/2, float64(h)/2
+	short := float64(min(w, h))
+	tolerance := math.Max(2, 0.01*short)
+	minSpan := minEdgeSpan * short
+
+	// Signed distance of each segment from the image center, across the
+	// run of the edge
+	split := func(segs []segment, tilts []float64, nx, ny float64) (before, after []segment, beforeAt, afterAt []float64) {
+		for i, s := range segs {
+			if math.Abs(tilts[i]-tilt) > maxTiltSpread {
+				continue
+			}
+			d := ((s.x1+s.x2)/2-cx)*nx + ((s.y1+s.y2)/2-cy)*ny
+			if d < 0 {
+				before, beforeAt = append(before, s), append(beforeAt, d)
+			} else {
+				after, afterAt = append(after, s), append(afterAt, d)
+			}
+		}
+		return
+	}
+	topSegs, bottomSegs, topAt, bottomAt := split(across, acrossTilts, -sin, cos)
+	leftSegs, rightSegs, leftAt, rightAt := split(down, downTilts, cos, sin)
+
+	var edges [4]edgeLine
+	for i, side := range []struct {
+		segs []segment
+		at   []float64
+	}{{leftSegs, leftAt}, {topSegs, topAt}, {rightSegs, rightAt}, {bottomSegs, bottomAt}} {
+		line, ok := strongestLine(side.segs, side.at, tolerance, minSpan)
+		if !ok {
+			return nil
+		}
+		edges[i] = line
+	}
+	return rectFromEdges(edges[0], edges[1], edges[2], edges[3])
+}
+
+// strongestLine groups segments whose distances at lie within tolerance of
+// each other and fits a line to the group with the most total length. ok
+// is false when no group spans minSpan.
+func strongestLine(segs []segment, at []float64, tolerance, minSpan float64) (edgeLine, bool) {
+	order := make([]int, len(segs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return at[order[a]] < at[order[b]] })
+
+	var best []int
+	bestSpan := 0.0
+	for start := 0; start < len(order); {
+		end, span := start, 0.0
+		for end < len(order) && at[order[end]]-at[order[start]] <= tolerance {
+			span += segs[order[end]].length()
+			end++
+		}
+		if span > bestSpan {
+			best, bestSpan = order[start:end], span
+		}
+		start = end
+	}
+	if bestSpan < minSpan {
+		return edgeLine{}, false
+	}
+
+	var xs, ys, weights []float64
+	for _, i := range best {
+		s := segs[i]
+		half := s.length() / 2
+		xs = append(xs, s.x1, s.x2)
+		ys = append(ys, s.y1, s.y2)
+		weights = append(weights, half, half)
+	}
+	return fitLine(xs, ys, weights)
+}
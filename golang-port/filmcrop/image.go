@@ -0,0 +1,102 @@
+package filmcrop
+
+import (
+	"fmt"
+	"os"
+
+	"film-crop-detector/metadata"
+
+	"gocv.io/x/gocv"
+)
+
+// ReadImage loads path keeping 16-bit depth, single channel grayscale and
+// alpha so crops can be written back without loss. The EXIF orientation is
+// applied, so the returned pixels are always upright.
+func ReadImage(path string) (gocv.Mat, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return gocv.NewMat(), fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+
+	img := gocv.IMRead(path, gocv.IMReadUnchanged)
+	if img.Empty() {
+		return img, fmt.Errorf("%w: %s", ErrDecode, path)
+	}
+
+	// IMReadUnchanged ignores the orientation, so apply it ourselves
+	if meta, err := metadata.Read(path); err == nil {
+		img = applyOrientation(img, meta.Orientation())
+	}
+	return img, nil
+}
+
+// applyOrientation transforms img according to an EXIF orientation value,
+// closing img if a new Mat is returned
+func applyOrientation(img gocv.Mat, orientation int) gocv.Mat {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	out := gocv.NewMat()
+	switch orientation {
+	case 2:
+		gocv.Flip(img, &out, 1)
+	case 3:
+		gocv.Rotate(img, &out, gocv.Rotate180Clockwise)
+	case 4:
+		gocv.Flip(img, &out, 0)
+	case 5:
+		gocv.Transpose(img, &out)
+	case 6:
+		gocv.Rotate(img, &out, gocv.Rotate90Clockwise)
+	case 7:
+		transposed := gocv.NewMat()
+		gocv.Transpose(img, &transposed)
+		gocv.Rotate(transposed, &out, gocv.Rotate180Clockwise)
+		transposed.Close()
+	case 8:
+		gocv.Rotate(img, &out, gocv.Rotate90CounterClockwise)
+	}
+	img.Close()
+	return out
+}
+
+// Depth returns the per-channel depth of img, e.g. gocv.MatTypeCV16U
+func Depth(img gocv.Mat) gocv.MatType {
+	return img.Type() & 7
+}
+
+// DetectionProxy converts img to the 8-bit BGR image detection works on. The
+// result is always a new Mat that the caller must Close.
+func DetectionProxy(img gocv.Mat) (gocv.Mat, error) {
+	if img.Empty() {
+		return gocv.NewMat(), fmt.Errorf("%w: empty image", ErrDecode)
+	}
+
+	// Scale deeper samples down to 8 bits
+	eight := gocv.NewMat()
+	defer eight.Close()
+	switch Depth(img) {
+	case gocv.MatTypeCV8U:
+		img.CopyTo(&eight)
+	case gocv.MatTypeCV16U:
+		img.ConvertToWithParams(&eight, gocv.MatTypeCV8U, 1.0/257.0, 0)
+	case gocv.MatTypeCV32F, gocv.MatTypeCV64F:
+		img.ConvertToWithParams(&eight, gocv.MatTypeCV8U, 255, 0)
+	default:
+		return gocv.NewMat(), fmt.Errorf("%w: unsupported pixel type %v", ErrDecode, img.Type())
+	}
+
+	proxy := gocv.NewMat()
+	switch eight.Channels() {
+	case 1:
+		gocv.CvtColor(eight, &proxy, gocv.ColorGrayToBGR)
+	case 3:
+		eight.CopyTo(&proxy)
+	case 4:
+		gocv.CvtColor(eight, &proxy, gocv.ColorBGRAToBGR)
+	default:
+		proxy.Close()
+		return gocv.NewMat(), fmt.Errorf("%w: unsupported channel count %d", ErrDecode, eight.Channels())
+	}
+	return proxy, nil
+}
@@ -0,0 +1,261 @@
+package filmcrop
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// BaseColor is the color of bare film base, per channel on a 0-1 scale
+// whatever the depth of the scan
+type BaseColor struct {
+	B, G, R float64
+}
+
+// Balance selects how InvertNegative sets the levels of the positive
+type Balance string
+
+const (
+	// BalanceLevels stretches each channel between its own black and white
+	// points, which also neutralises most casts
+	BalanceLevels Balance = "levels"
+	// BalanceAuto applies per-channel levels and then a gray world white
+	// balance, scaling the channels to the same mean
+	BalanceAuto Balance = "auto"
+	// BalanceNone only removes the base and inverts
+	BalanceNone Balance = "none"
+)
+
+const (
+	// baseRing is the width of the band sampled outside the frame, as a
+	// fraction of the shorter frame side
+	baseRing = 0.03
+	// baseGap keeps the sample clear of the frame edge, as a fraction of the
+	// shorter frame side
+	baseGap = 0.005
+	// minBasePixels is the fewest base pixels a sample may be taken from
+	minBasePixels = 50
+	// levelClip is the fraction of pixels clipped at either end by levels
+	levelClip = 0.001
+)
+
+// ParseBalance maps a balance name (levels, auto or none) to its Balance
+func ParseBalance(name string) (Balance, error) {
+	b := Balance(strings.ToLower(name))
+	switch b {
+	case BalanceLevels, BalanceAuto, BalanceNone:
+		return b, nil
+	}
+	return "", fmt.Errorf("unknown balance %q (known: levels, auto, none)", name)
+}
+
+// SampleBase measures the film base in the rebate just outside the frame of
+// res. img is the scan res was detected on, at any depth. Clipped backlight
+// and the darker film holder are left out of the sample.
+func (d *Detector) SampleBase(img gocv.Mat, res Result) (BaseColor, error) {
+	if !res.Found() {
+		return BaseColor{}, ErrNoFrameDetected
+	}
+
+	norm, err := normalizedBGR(img)
+	if err != nil {
+		return BaseColor{}, err
+	}
+	defer norm.Close()
+
+	// The ring between the frame and a slightly larger copy of it
+	short := math.Min(float64(res.RawRect.Size.X), float64(res.RawRect.Size.Y))
+	gap := float32(math.Max(2, short*baseGap))
+	ring := float32(math.Max(4, short*baseRing))
+	mask := gocv.NewMatWithSize(img.Rows(), img.Cols(), gocv.MatTypeCV8U)
+	defer mask.Close()
+	mask.SetTo(gocv.NewScalar(0, 0, 0, 0))
+	fillRect(&mask, growRect(res.RawRect, gap+ring), 255)
+	fillRect(&mask, growRect(res.RawRect, gap), 0)
+
+	// Leave out bare backlight
+	clipped := gocv.NewMat()
+	defer clipped.Close()
+	level := float64(clippedLevel) / 255
+	gocv.InRangeWithScalar(norm, gocv.NewScalar(level, level, level, 0), gocv.NewScalar(2, 2, 2, 0), &clipped)
+	gocv.BitwiseNot(clipped, &clipped)
+	gocv.BitwiseAnd(mask, clipped, &mask)
+	if gocv.CountNonZero(mask) < minBasePixels {
+		return BaseColor{}, ErrNoFilmBase
+	}
+
+	// The base is the brightest film there is, so drop the holder and any
+	// other darker pixels below the mean of the ring
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(norm, &gray, gocv.ColorBGRToGray)
+	meanGray := gray.MeanWithMask(mask).Val1
+
+	bright := gocv.NewMat()
+	defer bright.Close()
+	gocv.Threshold(gray, &bright, float32(meanGray), 255, gocv.ThresholdBinary)
+	bright8 := gocv.NewMat()
+	defer bright8.Close()
+	bright.ConvertTo(&bright8, gocv.MatTypeCV8U)
+	gocv.BitwiseAnd(mask, bright8, &mask)
+	if gocv.CountNonZero(mask) < minBasePixels {
+		return BaseColor{}, ErrNoFilmBase
+	}
+
+	m := norm.MeanWithMask(mask)
+	base := BaseColor{B: m.Val1, G: m.Val2, R: m.Val3}
+	d.debugf("film base BGR= %.4f %.4f %.4f\n", base.B, base.G, base.R)
+	return base, nil
+}
+
+// InvertNegative turns a crop of a negative into a positive: it divides out
+// the film base, inverts and sets the levels according to balance. The
+// result has the depth of frame; grayscale stays grayscale and alpha is
+// dropped. It is always a new Mat that the caller must Close.
+func InvertNegative(frame gocv.Mat, base BaseColor, balance Balance) (gocv.Mat, error) {
+	if frame.Empty() {
+		return gocv.NewMat(), fmt.Errorf("%w: empty image", ErrDecode)
+	}
+	if base.B <= 0 || base.G <= 0 || base.R <= 0 {
+		return gocv.NewMat(), fmt.Errorf("film base %+v has an empty channel", base)
+	}
+
+	norm, err := normalizedBGR(frame)
+	if err != nil {
+		return gocv.NewMat(), err
+	}
+	defer norm.Close()
+
+	channels := gocv.Split(norm)
+	defer func() {
+		for _, c := range channels {
+			c.Close()
+		}
+	}()
+
+	for i, b := range []float64{base.B, base.G, base.R} {
+		c := &channels[i]
+		// Transmission relative to the base, then inverted
+		c.DivideFloat(float32(b))
+		gocv.Threshold(*c, c, 1, 1, gocv.ThresholdTrunc)
+		c.MultiplyFloat(-1)
+		c.AddFloat(1)
+
+		if balance == BalanceLevels || balance == BalanceAuto {
+			lo, hi := percentiles(*c, levelClip)
+			if hi-lo > 1e-6 {
+				c.SubtractFloat(float32(lo))
+				c.MultiplyFloat(float32(1 / (hi - lo)))
+			}
+		}
+	}
+
+	if balance == BalanceAuto {
+		// Gray world: scale the channels to their common mean
+		var means [3]float64
+		for i := range channels {
+			means[i] = channels[i].Mean().Val1
+		}
+		target := (means[0] + means[1] + means[2]) / 3
+		for i := range channels {
+			if means[i] > 1e-6 {
+				channels[i].MultiplyFloat(float32(target / means[i]))
+			}
+		}
+	}
+
+	for i := range channels {
+		c := &channels[i]
+		gocv.Threshold(*c, c, 1, 1, gocv.ThresholdTrunc)
+		gocv.Threshold(*c, c, 0, 0, gocv.ThresholdToZero)
+	}
+
+	merged := gocv.NewMat()
+	defer merged.Close()
+	gocv.Merge(channels, &merged)
+	if frame.Channels() == 1 {
+		gocv.CvtColor(merged, &merged, gocv.ColorBGRToGray)
+	}
+
+	out := gocv.NewMat()
+	switch Depth(frame) {
+	case gocv.MatTypeCV8U:
+		merged.ConvertToWithParams(&out, gocv.MatTypeCV8U, 255, 0)
+	case gocv.MatTypeCV16U:
+		merged.ConvertToWithParams(&out, gocv.MatTypeCV16U, 65535, 0)
+	default:
+		merged.ConvertTo(&out, Depth(frame))
+	}
+	return out, nil
+}
+
+// normalizedBGR converts img to 3-channel float32 on a 0-1 scale
+func normalizedBGR(img gocv.Mat) (gocv.Mat, error) {
+	var scale float32
+	switch Depth(img) {
+	case gocv.MatTypeCV8U:
+		scale = 1.0 / 255
+	case gocv.MatTypeCV16U:
+		scale = 1.0 / 65535
+	case gocv.MatTypeCV32F, gocv.MatTypeCV64F:
+		scale = 1
+	default:
+		return gocv.NewMat(), fmt.Errorf("%w: unsupported pixel type %v", ErrDecode, img.Type())
+	}
+
+	f := gocv.NewMat()
+	img.ConvertToWithParams(&f, gocv.MatTypeCV32F, scale, 0)
+
+	switch f.Channels() {
+	case 1:
+		gocv.CvtColor(f, &f, gocv.ColorGrayToBGR)
+	case 3:
+	case 4:
+		gocv.CvtColor(f, &f, gocv.ColorBGRAToBGR)
+	default:
+		f.Close()
+		return gocv.NewMat(), fmt.Errorf("%w: unsupported channel count %d", ErrDecode, img.Channels())
+	}
+	return f, nil
+}
+
+// percentiles returns the values of a float32 channel below which the
+// fraction clip of pixels lies, from the bottom and from the top
+func percentiles(c gocv.Mat, clip float64) (float64, float64) {
+	data, err := c.DataPtrFloat32()
+	if err != nil || len(data) == 0 {
+		return 0, 1
+	}
+
+	// A regular subsample is plenty for levels
+	step := max(1, len(data)/200000)
+	sample := make([]float64, 0, len(data)/step+1)
+	for i := 0; i < len(data); i += step {
+		sample = append(sample, float64(data[i]))
+	}
+	sort.Float64s(sample)
+
+	n := len(sample) - 1
+	return sample[int(float64(n)*clip)], sample[int(float64(n)*(1-clip))]
+}
+
+// growRect returns rect with every side moved outward by by pixels
+func growRect(rect *RotatedRect, by float32) *RotatedRect {
+	return &RotatedRect{
+		Center: rect.Center,
+		Size:   Point2f{X: rect.Size.X + 2*by, Y: rect.Size.Y + 2*by},
+		Angle:  rect.Angle,
+	}
+}
+
+// fillRect paints rect into a single channel mask
+func fillRect(mask *gocv.Mat, rect *RotatedRect, value uint8) {
+	pts := gocv.NewPointsVectorFromPoints([][]image.Point{rect.intCorners()})
+	defer pts.Close()
+	gocv.FillPoly(mask, pts, color.RGBA{value, value, value, 0})
+}
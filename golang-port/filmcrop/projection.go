@@ -0,0 +1,181 @@
+package filmcrop
+
+import (
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// ProjectionDetector finds each frame edge as the peak of the brightness
+// gradient across it, summed along a band of rows or columns. Summing over
+// the length of a side brings out a step too faint to show in a threshold
+// or an edge map, as long as it runs straight along the whole side. Each
+// edge is measured in two bands so its tilt follows from the two peaks.
+type ProjectionDetector struct{}
+
+// Name returns "projection"
+func (ProjectionDetector) Name() string {
+	return "projection"
+}
+
+// projectionBands are the pairs of bands each edge is measured in, as
+// fractions of the side. Each pair gives one estimate of the frame; all
+// stay clear of the corners, where two edges meet.
+var projectionBands = [][2][2]float64{
+	{{0.10, 0.40}, {0.60, 0.90}},
+	{{0.15, 0.45}, {0.55, 0.85}},
+	{{0.20, 0.50}, {0.50, 0.80}},
+}
+
+const (
+	// edgeSearch is how far into the image an edge is looked for, from each
+	// border, as a fraction of the image side
+	edgeSearch = 0.4
+	// minPeakRatio is how many times its median a profile must peak at to
+	// count as an edge
+	minPeakRatio = 3
+)
+
+// FindFrame measures the edges of s in each pair of projectionBands and
+// returns the median of the frames of plausible size
+func (ProjectionDetector) FindFrame(s *Scan) Candidate {
+	mask := erodedMask(s)
+	defer mask.Close()
+
+	gx := gocv.NewMat()
+	defer gx.Close()
+	gocv.Sobel(s.Gray, &gx, gocv.MatTypeCV32F, 1, 0, 3, 1, 0, gocv.BorderReplicate)
+	gy := gocv.NewMat()
+	defer gy.Close()
+	gocv.Sobel(s.Gray, &gy, gocv.MatTypeCV32F, 0, 1, 3, 1, 0, gocv.BorderReplicate)
+
+	dx, err := gx.DataPtrFloat32()
+	if err != nil {
+		return Candidate{}
+	}
+	dy, err := gy.DataPtrFloat32()
+	if err != nil {
+		return Candidate{}
+	}
+	g := &gradientField{w: s.Gray.Cols(), h: s.Gray.Rows(), gx: dx, gy: dy, mask: mask.ToBytes()}
+	window := scaledKernel(5, s.Scale)
+
+	var rects []*RotatedRect
+	for _, bands := range projectionBands {
+		rect := g.frame(bands, window)
+		s.debugf("projection bands= %v rect= %+v\n", bands, rect)
+		if rect != nil && float64(rect.Size.X*rect.Size.Y) >= s.minCaptureArea() {
+			rects = append(rects, rect)
+		}
+	}
+	return Candidate{Rect: medianRect(rects), Agreeing: rects, Passes: len(projectionBands)}
+}
+
+// gradientField holds the horizontal and vertical brightness gradients of
+// a w x h image, row by row, and the mask of pixels they count on
+type gradientField struct {
+	w, h   int
+	gx, gy []float32
+	mask   []byte
+}
+
+// frame measures each edge in both bands and returns the rectangle they
+// bound, or nil when an edge has no clear peak. The frame is darker than the
+// rebate, so brightness falls into the frame at the left and top edges and
+// rises out of it at the right and bottom edges.
+func (g *gradientField) frame(bands [2][2]float64, window int) *RotatedRect {
+	var left, right, top, bottom [2][2]float64
+	for i, b := range bands {
+		y0, y1 := int(b[0]*float64(g.h)), int(b[1]*float64(g.h))
+		x0, x1 := int(b[0]*float64(g.w)), int(b[1]*float64(g.w))
+		ym, xm := float64(y0+y1-1)/2, float64(x0+x1-1)/2
+
+		falling, rising := g.profiles(true, y0, y1)
+		xl, okl := profilePeak(smoothProfile(falling, window), 0, edgeSearch)
+		xr, okr := profilePeak(smoothProfile(rising, window), 1-edgeSearch, 1)
+		falling, rising = g.profiles(false, x0, x1)
+		yt, okt := profilePeak(smoothProfile(falling, window), 0, edgeSearch)
+		yb, okb := profilePeak(smoothProfile(rising, window), 1-edgeSearch, 1)
+		if !okl || !okr || !okt || !okb {
+			return nil
+		}
+		left[i] = [2]float64{xl, ym}
+		right[i] = [2]float64{xr, ym}
+		top[i] = [2]float64{xm, yt}
+		bottom[i] = [2]float64{xm, yb}
+	}
+
+	through := func(p [2][2]float64) edgeLine {
+		dx, dy := p[1][0]-p[0][0], p[1][1]-p[0][1]
+		n := math.Hypot(dx, dy)
+		return edgeLine{x: p[0][0], y: p[0][1], dx: dx / n, dy: dy / n}
+	}
+	return rectFromEdges(through(left), through(top), through(right), through(bottom))
+}
+
+// profiles sums the falling and rising gradient at each column over rows
+// from to to (across), or at each row over columns from to to
+func (g *gradientField) profiles(across bool, from, to int) (falling, rising []float64) {
+	n := g.h
+	if across {
+		n = g.w
+	}
+	falling = make([]float64, n)
+	rising = make([]float64, n)
+	add := func(pos int, v float32) {
+		if v < 0 {
+			falling[pos] -= float64(v)
+		} else {
+			rising[pos] += float64(v)
+		}
+	}
+	if across {
+		for y := from; y < to; y++ {
+			for x := 0; x < g.w; x++ {
+				if i := y*g.w + x; g.mask[i] != 0 {
+					add(x, g.gx[i])
+				}
+			}
+		}
+	} else {
+		for y := 0; y < g.h; y++ {
+			for x := from; x < to; x++ {
+				if i := y*g.w + x; g.mask[i] != 0 {
+					add(y, g.gy[i])
+				}
+			}
+		}
+	}
+	return falling, rising
+}
+
+// profilePeak returns the position of the highest value of profile between
+// the fractions lo and hi of its length, refined to a fraction of a pixel.
+// ok is false when the peak does not stand minPeakRatio times above the
+// median of that range.
+func profilePeak(profile []float64, lo, hi float64) (float64, bool) {
+	from := int(lo * float64(len(profile)))
+	to := min(len(profile), int(hi*float64(len(profile))))
+	if to-from < 3 {
+		return 0, false
+	}
+	best := from
+	for i := from; i < to; i++ {
+		if profile[i] > profile[best] {
+			best = i
+		}
+	}
+	if profile[best] <= 0 || profile[best] < minPeakRatio*median(profile[from:to]) {
+		return 0, false
+	}
+
+	// Fit a parabola through the peak and its neighbours
+	pos := float64(best)
+	if best > 0 && best < len(profile)-1 {
+		a, b, c := profile[best-1], profile[best], profile[best+1]
+		if den := a - 2*b + c; den < 0 {
+			pos += 0.5 * (a - c) / den
+		}
+	}
+	return pos, true
+}
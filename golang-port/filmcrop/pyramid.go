@@ -0,0 +1,133 @@
+package filmcrop
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// minEdgeContrast is the smallest gray level step the refinement accepts as
+// the frame edge; weaker steps leave the edge where the proxy put it
+const minEdgeContrast = 4.0
+
+// downsample shrinks img so its long edge is at most Options.ProxyLongEdge.
+// It returns the image to detect on and its scale relative to img; when no
+// downsampling is needed that is img itself and 1.
+func (d *Detector) downsample(img gocv.Mat) (gocv.Mat, float64) {
+	long := max(img.Cols(), img.Rows())
+	if d.opts.ProxyLongEdge <= 0 || long <= d.opts.ProxyLongEdge {
+		return img, 1
+	}
+
+	scale := float64(d.opts.ProxyLongEdge) / float64(long)
+	small := gocv.NewMat()
+	gocv.Resize(img, &small, image.Point{}, scale, scale, gocv.InterpolationArea)
+	d.debugf("detection proxy %dx%d -> %dx%d\n", img.Cols(), img.Rows(), small.Cols(), small.Rows())
+	return small, scale
+}
+
+// scaledKernel scales a kernel size tuned for full resolution scans to an
+// image downsampled by scale, keeping it odd and at least 1
+func scaledKernel(size int, scale float64) int {
+	k := int(math.Round(float64(size) * scale))
+	if k%2 == 0 {
+		k++
+	}
+	return max(1, k)
+}
+
+// scaleRect multiplies the position and size of rect by factor
+func scaleRect(rect *RotatedRect, factor float64) *RotatedRect {
+	if rect == nil {
+		return nil
+	}
+	return &RotatedRect{
+		Center: Point2f{X: float32(float64(rect.Center.X) * factor), Y: float32(float64(rect.Center.Y) * factor)},
+		Size:   Point2f{X: float32(float64(rect.Size.X) * factor), Y: float32(float64(rect.Size.Y) * factor)},
+		Angle:  rect.Angle,
+	}
+}
+
+// refineEdges moves each side of rect, found on a proxy, to the strongest
+// brightness step within band pixels of it in the full resolution img. Only
+// thin strips along the edges are sampled, so the full image is never
+// filtered.
+func (d *Detector) refineEdges(img gocv.Mat, rect *RotatedRect, band int) *RotatedRect {
+	cos := math.Cos(rect.Angle * math.Pi / 180)
+	sin := math.Sin(rect.Angle * math.Pi / 180)
+	ux := [2]float64{cos, sin}
+	uy := [2]float64{-sin, cos}
+	w, h := float64(rect.Size.X), float64(rect.Size.Y)
+
+	// Outward offsets of the right, left, bottom and top sides
+	right := d.edgeOffset(img, rect, ux, uy, w/2, h, band)
+	left := d.edgeOffset(img, rect, neg(ux), uy, w/2, h, band)
+	bottom := d.edgeOffset(img, rect, uy, ux, h/2, w, band)
+	top := d.edgeOffset(img, rect, neg(uy), ux, h/2, w, band)
+	d.debugf("edge refinement (px) right= %g left= %g bottom= %g top= %g\n", right, left, bottom, top)
+
+	shiftX := (right - left) / 2
+	shiftY := (bottom - top) / 2
+	return &RotatedRect{
+		Center: Point2f{
+			X: float32(float64(rect.Center.X) + shiftX*ux[0] + shiftY*uy[0]),
+			Y: float32(float64(rect.Center.Y) + shiftX*ux[1] + shiftY*uy[1]),
+		},
+		Size:  Point2f{X: float32(w + right + left), Y: float32(h + bottom + top)},
+		Angle: rect.Angle,
+	}
+}
+
+// edgeOffset samples a strip straddling the side of rect that lies dist
+// along normal from its centre, and returns how far outward the strongest
+// step across the strip sits. The strip covers the middle 80% of the side's
+// length so the corners and any neighbouring side stay out of it.
+func (d *Detector) edgeOffset(img gocv.Mat, rect *RotatedRect, normal, tangent [2]float64, dist, length float64, band int) float64 {
+	stripLen := int(length * 0.8)
+	if stripLen < 1 || band < 2 {
+		return 0
+	}
+	mx := float64(rect.Center.X) + dist*normal[0]
+	my := float64(rect.Center.Y) + dist*normal[1]
+
+	// Inverse map: strip pixel (u, v) samples the source at
+	// mid + (u - stripLen/2)*tangent + (v - band)*normal
+	m := gocv.NewMatWithSize(2, 3, gocv.MatTypeCV64F)
+	defer m.Close()
+	m.SetDoubleAt(0, 0, tangent[0])
+	m.SetDoubleAt(0, 1, normal[0])
+	m.SetDoubleAt(0, 2, mx-float64(stripLen)/2*tangent[0]-float64(band)*normal[0])
+	m.SetDoubleAt(1, 0, tangent[1])
+	m.SetDoubleAt(1, 1, normal[1])
+	m.SetDoubleAt(1, 2, my-float64(stripLen)/2*tangent[1]-float64(band)*normal[1])
+
+	strip := gocv.NewMat()
+	defer strip.Close()
+	gocv.WarpAffineWithParams(img, &strip, m, image.Point{X: stripLen, Y: 2 * band},
+		gocv.InterpolationLinear|gocv.WarpInverseMap, gocv.BorderReplicate, color.RGBA{})
+
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(strip, &gray, gocv.ColorBGRToGray)
+
+	// One value per row, i.e. per distance from the edge
+	profile := smoothProfile(projectionProfile(gray, false), 3)
+
+	best, bestStep := 0, 0.0
+	for i := 1; i < len(profile)-1; i++ {
+		step := math.Abs(profile[i+1] - profile[i-1])
+		if step > bestStep {
+			best, bestStep = i, step
+		}
+	}
+	if bestStep < minEdgeContrast {
+		return 0
+	}
+	return float64(best - band)
+}
+
+func neg(v [2]float64) [2]float64 {
+	return [2]float64{-v[0], -v[1]}
+}
@@ -0,0 +1,381 @@
+package filmcrop
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// Quad is a frame outline with four free corners, in the order top left, top
+// right, bottom right and bottom left. Unlike a RotatedRect it follows a
+// frame that was shot at a slant or bowed by its holder.
+type Quad [4]Point2f
+
+// quadFromEdges returns the quadrilateral bounded by four edges; ok is false
+// when two neighbouring edges do not cross
+func quadFromEdges(left, top, right, bottom edgeLine) (Quad, bool) {
+	var q Quad
+	for i, pair := range [4][2]edgeLine{{top, left}, {top, right}, {bottom, right}, {bottom, left}} {
+		p, ok := intersect(pair[0], pair[1])
+		if !ok {
+			return Quad{}, false
+		}
+		q[i] = p
+	}
+	return q, true
+}
+
+// edges returns the lines through the sides of q, clockwise from the top
+func (q Quad) edges() [4]edgeLine {
+	var out [4]edgeLine
+	for i := range q {
+		a, b := q[i], q[(i+1)%4]
+		dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+		n := math.Hypot(dx, dy)
+		out[i] = edgeLine{x: float64(a.X), y: float64(a.Y), dx: dx / n, dy: dy / n}
+	}
+	return out
+}
+
+// sides returns the mean lengths of the top and bottom sides and of the left
+// and right sides
+func (q Quad) sides() (float64, float64) {
+	dist := func(a, b Point2f) float64 {
+		return math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y))
+	}
+	return (dist(q[0], q[1]) + dist(q[3], q[2])) / 2, (dist(q[0], q[3]) + dist(q[1], q[2])) / 2
+}
+
+// Rect returns the rectangle closest to q: its center is the mean of the
+// corners, its sides the mean lengths of opposite sides and its angle that
+// of the top and bottom sides, within ±45°
+func (q Quad) Rect() *RotatedRect {
+	var cx, cy float64
+	for _, c := range q {
+		cx += float64(c.X) / 4
+		cy += float64(c.Y) / 4
+	}
+	w, h := q.sides()
+	tl, tr, br, bl := q[0], q[1], q[2], q[3]
+	angle := math.Atan2(float64(tr.Y-tl.Y)+float64(br.Y-bl.Y), float64(tr.X-tl.X)+float64(br.X-bl.X))
+	return uprightRect(&RotatedRect{
+		Center: Point2f{X: float32(cx), Y: float32(cy)},
+		Size:   Point2f{X: float32(w), Y: float32(h)},
+		Angle:  angle * 180 / math.Pi,
+	})
+}
+
+// inset moves every side of q inward by d pixels
+func (q Quad) inset(d float64) Quad {
+	e := q.edges()
+	for i := range e {
+		// Corners run clockwise on screen, so inward is to the right
+		e[i].x -= e[i].dy * d
+		e[i].y += e[i].dx * d
+	}
+	out, ok := quadFromEdges(e[3], e[0], e[1], e[2])
+	if !ok {
+		return q
+	}
+	return out
+}
+
+// shrink moves the corners of q toward their mean by the fraction percent
+func (q Quad) shrink(percent float64) Quad {
+	var cx, cy float32
+	for _, c := range q {
+		cx += c.X / 4
+		cy += c.Y / 4
+	}
+	scale := float32(math.Max(0, 1-percent))
+	for i, c := range q {
+		q[i] = Point2f{X: cx + (c.X-cx)*scale, Y: cy + (c.Y-cy)*scale}
+	}
+	return q
+}
+
+// quadBand is how far either side of the coarse frame edge refineQuad looks
+// for the true edge, as a fraction of the shorter image side
+const quadBand = 0.01
+
+// sideSamples is the number of places along each side where refineQuad
+// measures the edge
+const sideSamples = 16
+
+// refineQuad fits each side of rect to the brightness step along it in img,
+// independently and to a fraction of a pixel, and returns the quadrilateral
+// the four sides bound. The step is looked for within band pixels of each
+// side. A side without a clear step keeps its place on rect.
+func (d *Detector) refineQuad(img gocv.Mat, rect *RotatedRect, band int) Quad {
+	r := uprightRect(rect)
+	cos := math.Cos(r.Angle * math.Pi / 180)
+	sin := math.Sin(r.Angle * math.Pi / 180)
+	ux := [2]float64{cos, sin}
+	uy := [2]float64{-sin, cos}
+	w, h := float64(r.Size.X), float64(r.Size.Y)
+
+	// Left, top, right and bottom, each with a tangent running clockwise
+	sides := [4]struct {
+		normal, tangent [2]float64
+		dist, length    float64
+	}{
+		{neg(ux), neg(uy), w / 2, h},
+		{neg(uy), ux, h / 2, w},
+		{ux, uy, w / 2, h},
+		{uy, neg(ux), h / 2, w},
+	}
+	var edges [4]edgeLine
+	for i, s := range sides {
+		line, ok := d.fitSide(img, r, s.normal, s.tangent, s.dist, s.length, band)
+		if !ok {
+			line = edgeLine{
+				x:  float64(r.Center.X) + s.dist*s.normal[0],
+				y:  float64(r.Center.Y) + s.dist*s.normal[1],
+				dx: s.tangent[0],
+				dy: s.tangent[1],
+			}
+		}
+		edges[i] = line
+	}
+	q, ok := quadFromEdges(edges[0], edges[1], edges[2], edges[3])
+	if !ok {
+		q = quadFromRect(r)
+	}
+	d.debugf("refined quad= %v\n", q)
+	return q
+}
+
+// quadFromRect returns the corners of rect as a Quad
+func quadFromRect(rect *RotatedRect) Quad {
+	c := uprightRect(rect).Corners()
+	// Corners runs bottom right, bottom left, top left, top right
+	return Quad{c[2], c[3], c[0], c[1]}
+}
+
+// fitSide samples a strip straddling the side of rect that lies dist along
+// normal from its centre, finds the strongest step across the strip at
+// sideSamples places along it and fits a line through them, dropping the
+// places that disagree. ok is false when too few places show a clear step.
+func (d *Detector) fitSide(img gocv.Mat, rect *RotatedRect, normal, tangent [2]float64, dist, length float64, band int) (edgeLine, bool) {
+	// Stay clear of the corners, where the neighbouring side runs
+	stripLen := int(length * 0.9)
+	if stripLen < sideSamples || band < 2 {
+		return edgeLine{}, false
+	}
+	mx := float64(rect.Center.X) + dist*normal[0]
+	my := float64(rect.Center.Y) + dist*normal[1]
+
+	// Inverse map as in edgeOffset: strip pixel (u, v) samples the source at
+	// mid + (u - stripLen/2)*tangent + (v - band)*normal
+	m := gocv.NewMatWithSize(2, 3, gocv.MatTypeCV64F)
+	defer m.Close()
+	m.SetDoubleAt(0, 0, tangent[0])
+	m.SetDoubleAt(0, 1, normal[0])
+	m.SetDoubleAt(0, 2, mx-float64(stripLen)/2*tangent[0]-float64(band)*normal[0])
+	m.SetDoubleAt(1, 0, tangent[1])
+	m.SetDoubleAt(1, 1, normal[1])
+	m.SetDoubleAt(1, 2, my-float64(stripLen)/2*tangent[1]-float64(band)*normal[1])
+
+	strip := gocv.NewMat()
+	defer strip.Close()
+	gocv.WarpAffineWithParams(img, &strip, m, image.Point{X: stripLen, Y: 2 * band},
+		gocv.InterpolationLinear|gocv.WarpInverseMap, gocv.BorderReplicate, color.RGBA{})
+
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(strip, &gray, gocv.ColorBGRToGray)
+
+	var along, across, weights []float64
+	for k := 0; k < sideSamples; k++ {
+		u0, u1 := k*stripLen/sideSamples, (k+1)*stripLen/sideSamples
+		chunk := gray.Region(image.Rect(u0, 0, u1, 2*band))
+		profile := projectionProfile(chunk, false)
+		chunk.Close()
+
+		offset, step, ok := stepOffset(profile)
+		if !ok {
+			continue
+		}
+		along = append(along, float64(u0+u1-1)/2-float64(stripLen)/2)
+		across = append(across, offset-float64(band))
+		weights = append(weights, step)
+	}
+
+	a, b, ok := fitOffsets(along, across, weights)
+	if !ok {
+		return edgeLine{}, false
+	}
+	// The side runs through mid + a*normal, turning by b across per pixel along
+	dx, dy := tangent[0]+b*normal[0], tangent[1]+b*normal[1]
+	n := math.Hypot(dx, dy)
+	return edgeLine{x: mx + a*normal[0], y: my + a*normal[1], dx: dx / n, dy: dy / n}, true
+}
+
+// stepOffset returns where the brightness of profile changes fastest, to a
+// fraction of a sample, and how large the change is. ok is false when the
+// change is below minEdgeContrast.
+func stepOffset(profile []float64) (offset, step float64, ok bool) {
+	if len(profile) < 3 {
+		return 0, 0, false
+	}
+	grad := make([]float64, len(profile))
+	for i := 1; i < len(profile)-1; i++ {
+		grad[i] = math.Abs(profile[i+1]-profile[i-1]) / 2
+	}
+	best := 1
+	for i := 1; i < len(grad)-1; i++ {
+		if grad[i] > grad[best] {
+			best = i
+		}
+	}
+	if 2*grad[best] < minEdgeContrast {
+		return 0, 0, false
+	}
+
+	offset = float64(best)
+	a, b, c := grad[best-1], grad[best], grad[best+1]
+	if den := a - 2*b + c; den < 0 {
+		offset += 0.5 * (a - c) / den
+	}
+	return offset, grad[best], true
+}
+
+// minSidePoints is the fewest places along a side that must agree for a
+// refined side to be used
+const minSidePoints = 5
+
+// maxSideResidual is how far in pixels a place may lie from the fitted side
+// before it is dropped as dust or picture content
+const maxSideResidual = 1.5
+
+// fitOffsets fits across = a + b*along by weighted least squares, then drops
+// the points further than maxSideResidual from the fit and fits again
+func fitOffsets(along, across, weights []float64) (a, b float64, ok bool) {
+	keep := make([]bool, len(along))
+	for i := range keep {
+		keep[i] = true
+	}
+	for pass := 0; pass < 3; pass++ {
+		var sw, st, sn, stt, stn float64
+		n := 0
+		for i := range along {
+			if !keep[i] {
+				continue
+			}
+			w := weights[i]
+			sw += w
+			st += w * along[i]
+			sn += w * across[i]
+			stt += w * along[i] * along[i]
+			stn += w * along[i] * across[i]
+			n++
+		}
+		den := sw*stt - st*st
+		if n < minSidePoints || den == 0 {
+			return 0, 0, false
+		}
+		b = (sw*stn - st*sn) / den
+		a = (sn - b*st) / sw
+
+		dropped := false
+		for i := range along {
+			if keep[i] && math.Abs(across[i]-a-b*along[i]) > maxSideResidual {
+				keep[i] = false
+				dropped = true
+			}
+		}
+		if !dropped {
+			return a, b, true
+		}
+	}
+	return a, b, true
+}
+
+// RectifyQuad returns the outline the perspective corrected output of res
+// covers: the refined quadrilateral inset like InsetRect and shrunk by
+// FinalShrink
+func (d *Detector) RectifyQuad(res Result) (Quad, error) {
+	if !res.Found() {
+		return Quad{}, ErrNoFrameDetected
+	}
+	if res.Quad == nil {
+		return Quad{}, ErrNoQuad
+	}
+	w, h := res.Quad.sides()
+	q := res.Quad.inset((w + h) / 2 * d.opts.InsetPercent / 2)
+	return q.shrink(d.opts.FinalShrink), nil
+}
+
+// Rectify maps the refined frame of res onto an upright rectangle, undoing
+// perspective as well as rotation, for frames shot at a slant. The output
+// sides are the mean lengths of the opposite sides of the frame; with
+// EnforceAspect the output is then cut to the format aspect around its
+// centre. img may be a different resolution from the image res was
+// detected on; the frame is scaled to match.
+func (d *Detector) Rectify(img gocv.Mat, res Result, interp gocv.InterpolationFlags) (gocv.Mat, error) {
+	q, err := d.RectifyQuad(res)
+	if err != nil {
+		return gocv.NewMat(), err
+	}
+
+	sx := float32(img.Cols()) / float32(res.Width)
+	sy := float32(img.Rows()) / float32(res.Height)
+	for i := range q {
+		q[i] = Point2f{X: q[i].X * sx, Y: q[i].Y * sy}
+	}
+	w, h := q.sides()
+	outW, outH := int(math.Round(w)), int(math.Round(h))
+	if outW <= 0 || outH <= 0 {
+		return gocv.NewMat(), ErrNoFrameDetected
+	}
+
+	// Corners of the quad go to the pixel edges of the output
+	src := make([]gocv.Point2f, 4)
+	for i, c := range q {
+		src[i] = gocv.Point2f{X: c.X, Y: c.Y}
+	}
+	fw, fh := float32(outW)-0.5, float32(outH)-0.5
+	dst := []gocv.Point2f{{X: -0.5, Y: -0.5}, {X: fw, Y: -0.5}, {X: fw, Y: fh}, {X: -0.5, Y: fh}}
+	srcVec := gocv.NewPoint2fVectorFromPoints(src)
+	defer srcVec.Close()
+	dstVec := gocv.NewPoint2fVectorFromPoints(dst)
+	defer dstVec.Close()
+	m := gocv.GetPerspectiveTransform2f(srcVec, dstVec)
+	defer m.Close()
+
+	out := gocv.NewMat()
+	err = gocv.WarpPerspectiveWithParams(img, &out, m, image.Point{X: outW, Y: outH},
+		interp, gocv.BorderReplicate, color.RGBA{})
+	if err != nil {
+		out.Close()
+		return gocv.NewMat(), err
+	}
+	d.debugf("rectified quad= %v to %dx%d\n", q, outW, outH)
+
+	// Cut the output to the format aspect around its centre, as
+	// DeskewFrame does
+	format, err := LookupFormat(res.Format)
+	if !d.opts.EnforceAspect || err != nil || format.isAuto() {
+		return out, nil
+	}
+	target := format.Aspect
+	if outH > outW {
+		target = 1.0 / format.Aspect
+	}
+	cutW, cutH := outW, outH
+	if float64(outW)/float64(outH) > target {
+		cutW = int(math.Round(float64(outH) * target))
+	} else {
+		cutH = int(math.Round(float64(outW) / target))
+	}
+	if cutW == outW && cutH == outH || cutW <= 0 || cutH <= 0 {
+		return out, nil
+	}
+	defer out.Close()
+	x0, y0 := (outW-cutW)/2, (outH-cutH)/2
+	cut := out.Region(image.Rect(x0, y0, x0+cutW, y0+cutH))
+	defer cut.Close()
+	return cut.Clone(), nil
+}
@@ -0,0 +1,98 @@
+package filmcrop
+
+import (
+	"math"
+	"testing"
+)
+
+func closePoints(a, b Point2f, px float64) bool {
+	return math.Hypot(float64(a.X-b.X), float64(a.Y-b.Y)) <= px
+}
+
+func TestQuadRect(t *testing.T) {
+	q := quadFromRect(testFrame)
+	closeRects(t, q.Rect(), testFrame, 1e-3, 1e-4)
+
+	// Keystone: the top is narrower than the bottom
+	keystone := Quad{{110, 50}, {290, 50}, {300, 250}, {100, 250}}
+	closeRects(t, keystone.Rect(), &RotatedRect{Center: Point2f{X: 200, Y: 150}, Size: Point2f{X: 190, Y: 200.25}}, 0.01, 1e-4)
+}
+
+func TestQuadInsetShrink(t *testing.T) {
+	q := Quad{{100, 50}, {300, 50}, {300, 250}, {100, 250}}
+	want := Quad{{105, 55}, {295, 55}, {295, 245}, {105, 245}}
+	got := q.inset(5)
+	for i := range got {
+		if !closePoints(got[i], want[i], 1e-3) {
+			t.Errorf("inset(5) = %v, want %v", got, want)
+			break
+		}
+	}
+
+	want = Quad{{110, 60}, {290, 60}, {290, 240}, {110, 240}}
+	got = q.shrink(0.1)
+	for i := range got {
+		if !closePoints(got[i], want[i], 1e-3) {
+			t.Errorf("shrink(0.1) = %v, want %v", got, want)
+			break
+		}
+	}
+
+	// Insetting a turned frame keeps its centre and angle
+	closeRects(t, quadFromRect(testFrame).inset(4).Rect(),
+		&RotatedRect{Center: testFrame.Center, Size: Point2f{X: testFrame.Size.X - 8, Y: testFrame.Size.Y - 8}, Angle: testFrame.Angle}, 1e-3, 1e-4)
+}
+
+func TestStepOffset(t *testing.T) {
+	// A step blurred over a pixel, centred at 10.3
+	profile := make([]float64, 24)
+	for i := range profile {
+		profile[i] = 60 + 120/(1+math.Exp(-(float64(i)-10.3)*1.5))
+	}
+	offset, step, ok := stepOffset(profile)
+	if !ok || math.Abs(offset-10.3) > 0.1 || step <= 0 {
+		t.Errorf("stepOffset = %g, %g, %v, want about 10.3", offset, step, ok)
+	}
+
+	flat := make([]float64, 24)
+	for i := range flat {
+		flat[i] = 100 + float64(i%2)
+	}
+	if _, _, ok := stepOffset(flat); ok {
+		t.Error("found a step in a flat profile")
+	}
+}
+
+func TestFitOffsets(t *testing.T) {
+	var along, across, weights []float64
+	for i := 0; i < sideSamples; i++ {
+		u := float64(i*20 - 150)
+		along = append(along, u)
+		across = append(across, 1.25+0.01*u)
+		weights = append(weights, 10)
+	}
+	// Dust on the edge
+	across[3] += 6
+	across[11] -= 4
+
+	a, b, ok := fitOffsets(along, across, weights)
+	if !ok || math.Abs(a-1.25) > 1e-6 || math.Abs(b-0.01) > 1e-6 {
+		t.Errorf("fitOffsets = %g, %g, %v, want 1.25, 0.01", a, b, ok)
+	}
+
+	if _, _, ok := fitOffsets(along[:minSidePoints-1], across[:minSidePoints-1], weights[:minSidePoints-1]); ok {
+		t.Error("fitted a side to too few points")
+	}
+}
+
+func TestCropCoordinatesSubpixel(t *testing.T) {
+	rect := &RotatedRect{Center: Point2f{X: 200.5, Y: 150.25}, Size: Point2f{X: 300.6, Y: 200.3}}
+	crop := calculateCropCoordinates(rect, 300, 400)
+	want := Crop{Left: 50.2 / 400, Right: 350.8 / 400, Top: 50.1 / 300, Bottom: 250.4 / 300}
+	for _, d := range []float64{crop.Left - want.Left, crop.Right - want.Right, crop.Top - want.Top, crop.Bottom - want.Bottom} {
+		if math.Abs(d) > 1e-6 {
+			t.Errorf("crop = %+v, want %+v", crop, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package filmcrop
+
+import (
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// RollStatus records how a frame compared with the rest of its roll
+type RollStatus string
+
+const (
+	// RollOutlier marks a frame that differs from the roll and was kept
+	RollOutlier RollStatus = "outlier"
+	// RollRefit marks an outlier whose edges were found again around the
+	// roll frame
+	RollRefit RollStatus = "refit"
+	// RollReplaced marks an outlier replaced by the roll frame, centred on
+	// its own detection
+	RollReplaced RollStatus = "replaced"
+)
+
+// MinRollFrames is the fewest detected frames a roll consensus is taken from
+const MinRollFrames = 3
+
+// RollTolerance is how far a frame may differ from its roll before it
+// counts as an outlier
+type RollTolerance struct {
+	// Size is the largest difference of either side, as a fraction of the
+	// roll side
+	Size float64
+	// Angle is the largest difference in degrees
+	Angle float64
+}
+
+// DefaultRollTolerance suits frames scanned in one holder
+var DefaultRollTolerance = RollTolerance{Size: 0.02, Angle: 0.5}
+
+// Consensus is the typical frame of a roll: frames from one roll in one
+// holder share their size and angle, so the median over a batch holds even
+// where dark content near the border throws single detections off
+type Consensus struct {
+	// Long and Short are the median sides of the detected frames
+	Long, Short float64
+	// Angle is the median angle of the frames, within ±45°
+	Angle float64
+	// Frames is the number of frames the consensus was taken from
+	Frames int
+}
+
+// RollConsensus returns the median size and angle of the frames found in
+// results. ok is false when fewer than MinRollFrames frames were found.
+func RollConsensus(results []Result) (c Consensus, ok bool) {
+	var long, short, angles []float64
+	for _, res := range results {
+		if !res.Found() {
+			continue
+		}
+		r := uprightRect(res.RawRect)
+		w, h := float64(r.Size.X), float64(r.Size.Y)
+		long = append(long, math.Max(w, h))
+		short = append(short, math.Min(w, h))
+		angles = append(angles, r.Angle)
+	}
+	if len(long) < MinRollFrames {
+		return Consensus{Frames: len(long)}, false
+	}
+	return Consensus{Long: median(long), Short: median(short), Angle: median(angles), Frames: len(long)}, true
+}
+
+// Outlier reports whether the frame of res differs from c by more than tol
+// in either side or in angle
+func (c Consensus) Outlier(res Result, tol RollTolerance) bool {
+	if !res.Found() {
+		return false
+	}
+	r := uprightRect(res.RawRect)
+	w, h := float64(r.Size.X), float64(r.Size.Y)
+	return math.Abs(math.Max(w, h)-c.Long) > tol.Size*c.Long ||
+		math.Abs(math.Min(w, h)-c.Short) > tol.Size*c.Short ||
+		math.Abs(r.Angle-c.Angle) > tol.Angle
+}
+
+// rect returns the consensus frame centred on like, with its long side
+// along the same axis as that of like
+func (c Consensus) rect(like *RotatedRect) *RotatedRect {
+	r := uprightRect(like)
+	size := Point2f{X: float32(c.Long), Y: float32(c.Short)}
+	if r.Size.Y > r.Size.X {
+		size.X, size.Y = size.Y, size.X
+	}
+	return &RotatedRect{Center: r.Center, Size: size, Angle: c.Angle}
+}
+
+// Replace returns res with its frame replaced by the consensus frame,
+// centred on its own detection. The confidence of the detection is kept, and
+// any refined quadrilateral dropped.
+func (d *Detector) Replace(res Result, c Consensus) Result {
+	if !res.Found() {
+		return res
+	}
+	rawRect := c.rect(res.RawRect)
+	d.debugf("roll replace %+v with %+v\n", res.RawRect, rawRect)
+	res.Quad = nil
+	res = d.frame(res, rawRect, d.frameFormat(rawRect))
+	res.Roll = RollReplaced
+	return res
+}
+
+// Refit looks for the edges of res in img again, starting from the
+// consensus frame centred on the detection and searching within tol.Size of
+// each side. When the refitted frame still differs from c by more than tol
+// it falls back to Replace. img is the 8-bit image res was detected on.
+func (d *Detector) Refit(img gocv.Mat, res Result, c Consensus, tol RollTolerance) Result {
+	if !res.Found() {
+		return res
+	}
+	band := max(2, int(math.Ceil(tol.Size*c.Long)))
+	rawRect := d.refineEdges(img, c.rect(res.RawRect), band)
+	refit := res
+	refit.Quad = nil
+	refit = d.frame(refit, rawRect, d.frameFormat(rawRect))
+	if c.Outlier(refit, tol) {
+		d.debugf("roll refit %+v still differs from the roll\n", rawRect)
+		return d.Replace(res, c)
+	}
+	refit.Roll = RollRefit
+	return refit
+}
@@ -0,0 +1,46 @@
+package filmcrop
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRollConsensus(t *testing.T) {
+	d := NewDetector(DefaultOptions())
+	detected := func(cx, w, h float32, angle float64) Result {
+		res := Result{Width: 400, Height: 300}
+		return d.frame(res, &RotatedRect{Center: Point2f{X: cx, Y: 150}, Size: Point2f{X: w, Y: h}, Angle: angle}, Formats[0])
+	}
+	results := []Result{
+		detected(200, 300, 200, 0.5),
+		detected(202, 301, 199, 0.4),
+		// Found turned a quarter, as MinAreaRect may report it
+		detected(198, 199.5, 300.5, 90.6),
+		// Dark content along one side cut the frame short
+		detected(180, 260, 200, 0.5),
+		{Width: 400, Height: 300},
+	}
+
+	c, ok := RollConsensus(results)
+	if !ok || c.Frames != 4 || c.Long != 300.25 || c.Short != 199.75 || math.Abs(c.Angle-0.5) > 1e-9 {
+		t.Fatalf("RollConsensus = %+v, %v", c, ok)
+	}
+	for i, want := range []bool{false, false, false, true, false} {
+		if got := c.Outlier(results[i], DefaultRollTolerance); got != want {
+			t.Errorf("frame %d: Outlier = %v, want %v", i, got, want)
+		}
+	}
+
+	res := d.Replace(results[3], c)
+	if res.Roll != RollReplaced {
+		t.Errorf("Roll = %q, want %q", res.Roll, RollReplaced)
+	}
+	closeRects(t, res.RawRect, &RotatedRect{Center: Point2f{X: 180, Y: 150}, Size: Point2f{X: 300.25, Y: 199.75}, Angle: 0.5}, 1e-3, 1e-9)
+	if res.Confidence != results[3].Confidence || res.Crop.Retained() <= results[3].Crop.Retained() {
+		t.Errorf("replaced frame %+v from %+v", res, results[3])
+	}
+
+	if _, ok := RollConsensus(results[:2]); ok {
+		t.Error("took a consensus from two frames")
+	}
+}
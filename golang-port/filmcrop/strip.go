@@ -0,0 +1,215 @@
+package filmcrop
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+
+	"gocv.io/x/gocv"
+)
+
+// DetectStrip finds every frame on a scan of a film strip. Frames are
+// separated using the bright gaps between exposures, each is detected on its
+// own and the results are returned in strip order (left to right or top to
+// bottom) in full image coordinates. A scan without gaps yields one Result.
+func (d *Detector) DetectStrip(img gocv.Mat) ([]Result, error) {
+	if img.Empty() {
+		return nil, fmt.Errorf("%w: empty image", ErrDecode)
+	}
+
+	if img.Type() != gocv.MatTypeCV8UC3 {
+		proxy, err := DetectionProxy(img)
+		if err != nil {
+			return nil, err
+		}
+		defer proxy.Close()
+		img = proxy
+	}
+
+	segments := d.findStripFrames(img)
+	if len(segments) < 2 {
+		res, err := d.Detect(img)
+		return []Result{res}, err
+	}
+
+	var results []Result
+	for i, seg := range segments {
+		region := img.Region(seg)
+		res, err := d.Detect(region)
+		region.Close()
+
+		if errors.Is(err, ErrNoFrameDetected) {
+			d.debugf("strip frame %d at %v: no frame detected\n", i+1, seg)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res.translate(seg.Min, img.Cols(), img.Rows()))
+	}
+
+	if len(results) == 0 {
+		return nil, ErrNoFrameDetected
+	}
+	return results, nil
+}
+
+// findStripFrames splits the strip along its long axis at the gaps between
+// exposures. Each returned rectangle spans the full width of the strip and
+// reaches halfway into the neighbouring gaps so the rebate stays visible.
+func (d *Detector) findStripFrames(img gocv.Mat) []image.Rectangle {
+	film := d.classifyFilm(img)
+
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(img, &gray, gocv.ColorBGRToGray)
+
+	// Gaps are unexposed film, so make them bright for both polarities
+	if film.Type.Polarity() == "positive" {
+		gocv.BitwiseNot(gray, &gray)
+	}
+
+	w, h := gray.Cols(), gray.Rows()
+	horizontal := w >= h
+	long, short := w, h
+	if !horizontal {
+		long, short = h, w
+	}
+
+	// Only sample the middle of the strip to stay clear of sprocket holes
+	var band image.Rectangle
+	if horizontal {
+		band = image.Rect(0, h/5, w, h-h/5)
+	} else {
+		band = image.Rect(w/5, 0, w-w/5, h)
+	}
+	center := gray.Region(band)
+	profile := projectionProfile(center, horizontal)
+	center.Close()
+	profile = smoothProfile(profile, max(3, short/100))
+
+	// Gap columns sit close to the brightest level on the strip
+	sorted := append([]float64(nil), profile...)
+	sort.Float64s(sorted)
+	hi := sorted[len(sorted)-1]
+	mid := sorted[len(sorted)/2]
+	if hi-mid < 10 {
+		d.debugf("strip profile too flat (max= %f median= %f)\n", hi, mid)
+		return nil
+	}
+	gapLevel := hi - 0.25*(hi-mid)
+
+	// Collect runs of exposed columns long enough to be a frame
+	minLength := short / 2
+	type run struct{ start, end int }
+	var runs []run
+	start := -1
+	for i := 0; i <= long; i++ {
+		exposed := i < long && profile[i] < gapLevel
+		if exposed && start < 0 {
+			start = i
+		} else if !exposed && start >= 0 {
+			if i-start >= minLength {
+				runs = append(runs, run{start, i})
+			}
+			start = -1
+		}
+	}
+	d.debugf("strip gap level= %f frames= %d\n", gapLevel, len(runs))
+
+	var rects []image.Rectangle
+	for i, r := range runs {
+		from, to := 0, long
+		if i > 0 {
+			from = (runs[i-1].end + r.start) / 2
+		}
+		if i < len(runs)-1 {
+			to = (r.end + runs[i+1].start) / 2
+		}
+		if horizontal {
+			rects = append(rects, image.Rect(from, 0, to, h))
+		} else {
+			rects = append(rects, image.Rect(0, from, w, to))
+		}
+	}
+	return rects
+}
+
+// projectionProfile averages a single channel image across its rows
+// (horizontal) or columns, giving one value per position along the other axis
+func projectionProfile(gray gocv.Mat, horizontal bool) []float64 {
+	reduced := gocv.NewMat()
+	defer reduced.Close()
+
+	dim := 1
+	if horizontal {
+		dim = 0
+	}
+	gocv.Reduce(gray, &reduced, dim, gocv.ReduceAvg, gocv.MatTypeCV32F)
+
+	n := reduced.Rows()
+	if horizontal {
+		n = reduced.Cols()
+	}
+	profile := make([]float64, n)
+	for i := range profile {
+		if horizontal {
+			profile[i] = float64(reduced.GetFloatAt(0, i))
+		} else {
+			profile[i] = float64(reduced.GetFloatAt(i, 0))
+		}
+	}
+	return profile
+}
+
+// smoothProfile applies a centred moving average of the given window
+func smoothProfile(profile []float64, window int) []float64 {
+	out := make([]float64, len(profile))
+	half := window / 2
+	for i := range profile {
+		lo := max(0, i-half)
+		hi := min(len(profile), i+half+1)
+		sum := 0.0
+		for _, v := range profile[lo:hi] {
+			sum += v
+		}
+		out[i] = sum / float64(hi-lo)
+	}
+	return out
+}
+
+// translate maps a result detected on a sub-image at offset into the
+// coordinates of the full w x h image
+func (r Result) translate(offset image.Point, w, h int) Result {
+	shift := func(rect *RotatedRect) *RotatedRect {
+		if rect == nil {
+			return nil
+		}
+		moved := *rect
+		moved.Center.X += float32(offset.X)
+		moved.Center.Y += float32(offset.Y)
+		return &moved
+	}
+
+	out := r
+	out.RawRect = shift(r.RawRect)
+	out.InsetRect = shift(r.InsetRect)
+	out.Rect = shift(r.Rect)
+	if r.Quad != nil {
+		moved := *r.Quad
+		for i := range moved {
+			moved[i].X += float32(offset.X)
+			moved[i].Y += float32(offset.Y)
+		}
+		out.Quad = &moved
+	}
+	out.Crop = Crop{
+		Left:   (r.Crop.Left*float64(r.Width) + float64(offset.X)) / float64(w),
+		Right:  (r.Crop.Right*float64(r.Width) + float64(offset.X)) / float64(w),
+		Top:    (r.Crop.Top*float64(r.Height) + float64(offset.Y)) / float64(h),
+		Bottom: (r.Crop.Bottom*float64(r.Height) + float64(offset.Y)) / float64(h),
+	}
+	out.Width, out.Height = w, h
+	return out
+}
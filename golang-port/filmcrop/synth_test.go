@@ -0,0 +1,205 @@
+package filmcrop
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"film-crop-detector/eval"
+	"film-crop-detector/synth"
+
+	"gocv.io/x/gocv"
+)
+
+// detectSynthetic renders spec and runs the default detector with the frame
+// detector fd on it
+func detectSynthetic(t *testing.T, spec synth.Spec, fd FrameDetector) (Result, synth.Truth, error) {
+	t.Helper()
+	img, truth, err := synth.Generate(spec)
+	if err != nil {
+		t.Fatalf("%+v: %v", spec, err)
+	}
+	mat, err := gocv.ImageToMatRGB(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mat.Close()
+
+	opts := DefaultOptions()
+	opts.FrameDetector = fd
+	res, err := NewDetector(opts).Detect(mat)
+	return res, truth, err
+}
+
+// rectQuad returns the corners of rect in the order eval compares them
+func rectQuad(rect *RotatedRect) eval.Quad {
+	var corners [4]eval.Point
+	for i, c := range rect.Corners() {
+		corners[i] = eval.Point{X: float64(c.X), Y: float64(c.Y)}
+	}
+	return eval.NewQuad(corners)
+}
+
+// score compares a detection with the truth of its scan
+func score(res Result, truth synth.Truth, err error) eval.Score {
+	var detected eval.Quad
+	if err == nil {
+		detected = rectQuad(res.RawRect)
+	}
+	return eval.Evaluate(truth.Annotation, detected, err)
+}
+
+// TestDetectSyntheticClean runs every frame detector on clean scans of each
+// polarity
+func TestDetectSyntheticClean(t *testing.T) {
+	for _, fd := range FrameDetectors {
+		t.Run(fd.Name(), func(t *testing.T) { testDetectClean(t, fd) })
+	}
+}
+
+func testDetectClean(t *testing.T, fd FrameDetector) {
+	for _, p := range synth.Polarities {
+		for _, portrait := range []bool{false, true} {
+			for _, angle := range []float64{-2, 0, 1.5} {
+				spec := synth.Spec{
+					Width: 900, Height: 600,
+					Format: "135", Aspect: 1.5,
+					Portrait: portrait, Coverage: 0.88, Angle: angle,
+					Polarity: p, OrangeMask: true, Seed: 11,
+				}
+				if portrait {
+					spec.Width, spec.Height = spec.Height, spec.Width
+				}
+				name := fmt.Sprintf("%s/portrait=%v/angle=%v", p, portrait, angle)
+				t.Run(name, func(t *testing.T) {
+					res, truth, err := detectSynthetic(t, spec, fd)
+					s := score(res, truth, err)
+					if s.Failed() {
+						t.Fatalf("no frame: %s", s.Error)
+					}
+					if s.IoU < 0.95 {
+						t.Errorf("IoU %.3f, want at least 0.95", s.IoU)
+					}
+					if math.Abs(s.AngleError) > 0.5 {
+						t.Errorf("angle off by %.2f degrees", s.AngleError)
+					}
+					if res.Polarity != truth.Polarity {
+						t.Errorf("polarity %s, want %s", res.Polarity, truth.Polarity)
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestDetectSyntheticSweep runs detection on random scans with every kind of
+// defect. Some of them are hard on purpose, so it only fails when too many
+// frames are missed or the mean overlap drops.
+func TestDetectSyntheticSweep(t *testing.T) {
+	if testing.Short() {
+		t.Skip("sweep renders hundreds of scans")
+	}
+	const (
+		count       = 300
+		minIoU      = 0.9
+		minPassRate = 0.8
+		minMeanIoU  = 0.88
+	)
+	v := synth.DefaultVariation
+	v.Formats = nil
+	for _, name := range []string{"135", "645", "6x7"} {
+		f, err := LookupFormat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.Formats = append(v.Formats, synth.Format{Name: f.Name, Aspect: f.Aspect})
+	}
+
+	var scores []eval.Score
+	var polarityErrors int
+	for seed := int64(1); seed <= count; seed++ {
+		spec := synth.RandomSpec(seed, v)
+		res, truth, err := detectSynthetic(t, spec, nil)
+		s := score(res, truth, err)
+		s.File = fmt.Sprintf("seed %d", seed)
+		scores = append(scores, s)
+		if s.Failed() || s.IoU < minIoU {
+			t.Logf("%s: IoU %.3f %s (%+v)", s.File, s.IoU, s.Error, spec)
+		}
+		if err == nil && res.Polarity != truth.Polarity {
+			polarityErrors++
+			t.Logf("seed %d: polarity %s, want %s", seed, res.Polarity, truth.Polarity)
+		}
+	}
+
+	var passed int
+	for _, s := range scores {
+		if !s.Failed() && s.IoU >= minIoU {
+			passed++
+		}
+	}
+	sum := eval.Summarize(scores)
+	t.Logf("%d/%d frames at IoU %.2f or better, mean IoU %.3f, %d polarity errors", passed, count, minIoU, sum.MeanIoU, polarityErrors)
+	if rate := float64(passed) / count; rate < minPassRate {
+		t.Errorf("pass rate %.2f, want at least %.2f", rate, minPassRate)
+	}
+	if sum.MeanIoU < minMeanIoU {
+		t.Errorf("mean IoU %.3f, want at least %.3f", sum.MeanIoU, minMeanIoU)
+	}
+	if rate := float64(polarityErrors) / count; rate > 1-minPassRate {
+		t.Errorf("%d polarity errors", polarityErrors)
+	}
+}
+
+// TestCorrectAspectRatio disturbs the size of synthetic frames and checks
+// that correction restores the format aspect by cropping, never growing
+func TestCorrectAspectRatio(t *testing.T) {
+	const maxDifference = 0.3
+	d := NewDetector(DefaultOptions())
+	v := synth.DefaultVariation
+	v.LongEdge = 200
+	for seed := int64(1); seed <= 200; seed++ {
+		spec := synth.RandomSpec(seed, v)
+		_, truth, err := synth.Generate(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := truth.Quad()
+		if err != nil {
+			t.Fatal(err)
+		}
+		edges := [4]float64{}
+		for i := range q {
+			edges[i] = math.Hypot(q[(i+1)%4].X-q[i].X, q[(i+1)%4].Y-q[i].Y)
+		}
+		// Stretch one side by up to 25%, either way
+		stretch := 1 + 0.25*math.Sin(float64(seed))
+		rect := &RotatedRect{
+			Center: Point2f{X: float32((q[0].X + q[2].X) / 2), Y: float32((q[0].Y + q[2].Y) / 2)},
+			Size:   Point2f{X: float32(edges[0] * stretch), Y: float32(edges[1])},
+			Angle:  q.Angle(),
+		}
+
+		got, changed := d.correctAspectRatio(rect, spec.Aspect, maxDifference)
+		long := math.Max(float64(rect.Size.X), float64(rect.Size.Y))
+		short := math.Min(float64(rect.Size.X), float64(rect.Size.Y))
+		if math.Abs(spec.Aspect-long/short) > maxDifference {
+			if changed || got != rect {
+				t.Errorf("seed %d: corrected %+v, which is too far off %.3f", seed, rect, spec.Aspect)
+			}
+			continue
+		}
+
+		gotLong := math.Max(float64(got.Size.X), float64(got.Size.Y))
+		gotShort := math.Min(float64(got.Size.X), float64(got.Size.Y))
+		if math.Abs(gotLong/gotShort-spec.Aspect) > 1e-3 {
+			t.Errorf("seed %d: aspect %.4f, want %.4f", seed, gotLong/gotShort, spec.Aspect)
+		}
+		if got.Size.X > rect.Size.X+1e-3 || got.Size.Y > rect.Size.Y+1e-3 {
+			t.Errorf("seed %d: %v grew to %v", seed, rect.Size, got.Size)
+		}
+		if got.Center != rect.Center || got.Angle != rect.Angle {
+			t.Errorf("seed %d: moved %+v to %+v", seed, rect, got)
+		}
+	}
+}
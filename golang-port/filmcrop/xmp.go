@@ -0,0 +1,152 @@
+package filmcrop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const crsNamespace = "http://ns.adobe.com/camera-raw-settings/1.0/"
+
+// cropProperties are the Camera Raw settings owned by this tool
+var cropProperties = []string{"HasCrop", "CropTop", "CropLeft", "CropBottom", "CropRight", "CropAngle"}
+
+var (
+	descriptionTag = regexp.MustCompile(`<rdf:Description\b(?:[^>"']|"[^"]*"|'[^']*')*>`)
+	crsAttribute   = regexp.MustCompile(`\s+crs:(?:` + strings.Join(cropProperties, "|") + `)\s*=\s*(?:"[^"]*"|'[^']*')`)
+	crsElement     = regexp.MustCompile(`\s*<crs:(` + strings.Join(cropProperties, "|") + `)>[^<]*</crs:(?:` + strings.Join(cropProperties, "|") + `)>`)
+	crsValue       = regexp.MustCompile(`[\s<]crs:(` + strings.Join(cropProperties, "|") + `)(?:\s*=\s*(?:"([^"]*)"|'([^']*)')|>([^<]*)<)`)
+)
+
+// SidecarPath returns the XMP sidecar path Lightroom uses for imagePath
+func SidecarPath(imagePath string) string {
+	return strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + ".xmp"
+}
+
+// WriteXMPSidecar records the crop and rotation of res as Camera Raw settings
+// in the XMP file at path. An existing sidecar is updated in place, keeping
+// every property other than the crop.
+func WriteXMPSidecar(path string, res Result) error {
+	var packet string
+	existing, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		packet, err = mergeCropXMP(string(existing), res)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrWriteFailed, path, err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		packet = newCropXMP(res)
+	default:
+		return fmt.Errorf("%w: %s: %v", ErrWriteFailed, path, err)
+	}
+
+	// Write through a temporary file so a failure never truncates the sidecar
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(packet), 0644); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrWriteFailed, path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%w: %s: %v", ErrWriteFailed, path, err)
+	}
+	return nil
+}
+
+// ReadXMPSidecar returns the crop and rotation stored in the XMP file at
+// path, in either attribute or element form
+func ReadXMPSidecar(path string) (Crop, float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Crop{}, 0, fmt.Errorf("%w: %s", ErrNotFound, path)
+		}
+		return Crop{}, 0, err
+	}
+
+	values := make(map[string]string)
+	for _, m := range crsValue.FindAllStringSubmatch(string(data), -1) {
+		values[m[1]] = strings.TrimSpace(m[2] + m[3] + m[4])
+	}
+	if !strings.EqualFold(values["HasCrop"], "True") {
+		return Crop{}, 0, fmt.Errorf("no crop in XMP sidecar %s", path)
+	}
+
+	var numbers [5]float64
+	for i, name := range []string{"CropLeft", "CropRight", "CropTop", "CropBottom", "CropAngle"} {
+		v, ok := values[name]
+		if !ok && name == "CropAngle" {
+			continue
+		}
+		numbers[i], err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return Crop{}, 0, fmt.Errorf("bad crs:%s in XMP sidecar %s: %q", name, path, v)
+		}
+	}
+	crop := Crop{Left: numbers[0], Right: numbers[1], Top: numbers[2], Bottom: numbers[3]}
+	return crop, numbers[4], nil
+}
+
+func cropAttributes(res Result, indent string) string {
+	values := map[string]string{
+		"HasCrop":    "True",
+		"CropTop":    fmt.Sprintf("%.6f", res.Crop.Top),
+		"CropLeft":   fmt.Sprintf("%.6f", res.Crop.Left),
+		"CropBottom": fmt.Sprintf("%.6f", res.Crop.Bottom),
+		"CropRight":  fmt.Sprintf("%.6f", res.Crop.Right),
+		"CropAngle":  fmt.Sprintf("%.6f", res.Rotation),
+	}
+
+	var b strings.Builder
+	for _, name := range cropProperties {
+		fmt.Fprintf(&b, "\n%scrs:%s=\"%s\"", indent, name, values[name])
+	}
+	return b.String()
+}
+
+func newCropXMP(res Result) string {
+	return `<?xpacket begin="` + "\uFEFF" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="film-crop-detector">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:crs="` + crsNamespace + `"` + cropAttributes(res, "   ") + `/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
+}
+
+// mergeCropXMP replaces the crop settings in an existing XMP packet. The crop
+// is written as attributes of the first rdf:Description, the form Lightroom
+// itself uses, after removing any earlier crop attributes or elements from
+// every rdf:Description so each property is left with one value.
+func mergeCropXMP(packet string, res Result) (string, error) {
+	packet = crsElement.ReplaceAllString(packet, "")
+	packet = descriptionTag.ReplaceAllStringFunc(packet, func(tag string) string {
+		return crsAttribute.ReplaceAllString(tag, "")
+	})
+
+	loc := descriptionTag.FindStringIndex(packet)
+	if loc == nil {
+		return "", errors.New("no rdf:Description in XMP packet")
+	}
+
+	tag := packet[loc[0]:loc[1]]
+
+	end := len(tag) - 1
+	if strings.HasSuffix(tag, "/>") {
+		end = len(tag) - 2
+	}
+	head := strings.TrimRight(tag[:end], " \t\r\n")
+
+	if !strings.Contains(head, "xmlns:crs=") {
+		head += "\n    xmlns:crs=\"" + crsNamespace + "\""
+	}
+	tag = head + cropAttributes(res, "   ") + tag[end:]
+
+	return packet[:loc[0]] + tag + packet[loc[1]:], nil
+}
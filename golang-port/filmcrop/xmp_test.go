@@ -0,0 +1,38 @@
+package filmcrop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeCropXMP(t *testing.T) {
+	packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="3"/>
+  <rdf:Description rdf:about="" xmlns:crs="` + crsNamespace + `"
+    crs:HasCrop="True" crs:CropLeft="0.5" crs:CropAngle='2' crs:Exposure2012="+0.50">
+   <crs:CropTop>0.4</crs:CropTop>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+	res := Result{Crop: Crop{Left: 0.1, Right: 0.9, Top: 0.2, Bottom: 0.8}, Rotation: -0.25}
+
+	merged, err := mergeCropXMP(packet, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range cropProperties {
+		if n := strings.Count(merged, "crs:"+name); n != 1 {
+			t.Errorf("crs:%s appears %d times in\n%s", name, n, merged)
+		}
+	}
+	for _, keep := range []string{`xmp:Rating="3"`, `crs:Exposure2012="+0.50"`} {
+		if !strings.Contains(merged, keep) {
+			t.Errorf("%s dropped from\n%s", keep, merged)
+		}
+	}
+	first := descriptionTag.FindString(merged)
+	if !strings.Contains(first, `crs:CropLeft="0.100000"`) || !strings.Contains(first, `crs:CropAngle="-0.250000"`) || !strings.Contains(first, "xmlns:crs=") {
+		t.Errorf("crop not written to the first rdf:Description: %s", first)
+	}
+}
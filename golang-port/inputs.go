@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// input is one image to process. rel is the path its output takes below
+// --output-dir: the path relative to the folder it was found in, or the base
+// name for files named directly.
+type input struct {
+	path string
+	rel  string
+}
+
+// walkConfig controls how folders are expanded into input files
+type walkConfig struct {
+	recursive bool
+	include   patternList
+	exclude   patternList
+	// skip lists absolute directories never descended into, so that output
+	// written inside the source tree is not picked up again
+	skip []string
+	// uniqueRel fails inputs whose rel repeats that of an earlier input, as
+	// their output would land on the same path below --output-dir or
+	// --review-dir
+	uniqueRel bool
+}
+
+// errDuplicateOutput means two inputs would write the same output file
+var errDuplicateOutput = errors.New("duplicate output name")
+
+// patternList is a repeatable glob flag
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	if _, err := filepath.Match(value, ""); err != nil {
+		return fmt.Errorf("bad pattern %q: %v", value, err)
+	}
+	*p = append(*p, value)
+	return nil
+}
+
+// match reports whether any pattern matches rel. Patterns containing a slash
+// are matched against the whole relative path, others against the base name.
+func (p patternList) match(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range p {
+		target := rel
+		if !strings.Contains(pattern, "/") {
+			target = rel[strings.LastIndex(rel, "/")+1:]
+		}
+		if ok, _ := filepath.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// collectInputs expands the command line arguments into the files to
+// process. Files are kept in argument order; the files of each folder are
+// sorted by relative path. A file reached twice is processed once. With
+// walk.uniqueRel, a file whose rel was already taken by another file is
+// returned as a failure, before anything is written.
+func collectInputs(args []string, walk walkConfig) ([]input, []failure) {
+	var inputs []input
+	var failures []failure
+	seen := make(map[string]bool)
+	taken := make(map[string]string)
+
+	for _, arg := range args {
+		var found []input
+		if isDir(arg) {
+			var err error
+			found, err = expandDirectory(arg, walk)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: Failed to list directory '%s': %v\n", arg, err)
+				failures = append(failures, failure{path: arg, err: err})
+			}
+		} else {
+			found = []input{{path: arg, rel: filepath.Base(arg)}}
+		}
+
+		for _, in := range found {
+			key := filepath.Clean(in.path)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if walk.uniqueRel {
+				rel := filepath.ToSlash(in.rel)
+				if first, ok := taken[rel]; ok {
+					err := fmt.Errorf("%w: %s, also the output of %s", errDuplicateOutput, rel, first)
+					fmt.Fprintf(os.Stderr, "ERROR: Skipping '%s': %v\n", in.path, err)
+					failures = append(failures, failure{path: in.path, err: err})
+					continue
+				}
+				taken[rel] = in.path
+			}
+			inputs = append(inputs, in)
+		}
+	}
+	return inputs, failures
+}
+
+// expandDirectory lists the image files in dir, descending into
+// subdirectories when walk.recursive is set
+func expandDirectory(dir string, walk walkConfig) ([]input, error) {
+	var inputs []input
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			// Keep going past unreadable subfolders
+			fmt.Fprintf(os.Stderr, "WARNING: Skipping '%s': %v\n", path, err)
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if path == dir {
+				return nil
+			}
+			if !walk.recursive || walk.exclude.match(rel) || isAnyDir(path, walk.skip) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !isImageFile(path) || walk.exclude.match(rel) {
+			return nil
+		}
+		if len(walk.include) > 0 && !walk.include.match(rel) {
+			return nil
+		}
+		inputs = append(inputs, input{path: path, rel: rel})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(inputs, func(i, j int) bool {
+		return filepath.ToSlash(inputs[i].rel) < filepath.ToSlash(inputs[j].rel)
+	})
+	return inputs, nil
+}
+
+// readFileList reads the paths listed in name, or standard input for "-".
+// Entries are separated by NUL bytes when the list contains any (as written
+// by find -print0), otherwise by newlines. Blank lines are ignored.
+func readFileList(name string) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sep := []byte("\n")
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = []byte{0}
+	}
+
+	var paths []string
+	for _, entry := range bytes.Split(data, sep) {
+		path := string(entry)
+		if sep[0] == '\n' {
+			path = strings.TrimRight(path, "\r")
+		}
+		if strings.TrimSpace(path) == "" {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// isAnyDir reports whether path names one of the absolute directories dirs
+func isAnyDir(path string, dirs []string) bool {
+	if len(dirs) == 0 {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range dirs {
+		if abs == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func isImageFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" ||
+		ext == ".tif" || ext == ".tiff" || ext == ".bmp" || ext == ".webp"
+}
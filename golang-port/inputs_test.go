@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectInputsDuplicateRel(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a/001.jpg", "a/002.jpg", "b/001.jpg", "c/002.jpg"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	args := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c", "002.jpg")}
+
+	// Outputs next to the inputs never collide
+	inputs, failures := collectInputs(args, walkConfig{})
+	if len(inputs) != 4 || len(failures) != 0 {
+		t.Fatalf("got %d inputs and %v", len(inputs), failures)
+	}
+
+	inputs, failures = collectInputs(args, walkConfig{uniqueRel: true})
+	if len(inputs) != 2 || len(failures) != 2 {
+		t.Fatalf("got %d inputs and %v", len(inputs), failures)
+	}
+	for i, want := range []string{filepath.Join(dir, "b", "001.jpg"), filepath.Join(dir, "c", "002.jpg")} {
+		if failures[i].path != want || !errors.Is(failures[i].err, errDuplicateOutput) {
+			t.Errorf("failure %d = %v, want %s with %v", i, failures[i], want, errDuplicateOutput)
+		}
+	}
+}
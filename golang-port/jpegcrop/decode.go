@@ -0,0 +1,504 @@
+package jpegcrop
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	markerSOF0 = 0xC0
+	markerSOF1 = 0xC1
+	markerDHT  = 0xC4
+	markerJPG  = 0xC8
+	markerDAC  = 0xCC
+	markerRST0 = 0xD0
+	markerRST7 = 0xD7
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerDQT  = 0xDB
+	markerDRI  = 0xDD
+	markerAPP0 = 0xE0
+	markerAPPE = 0xEE
+	markerAPPF = 0xEF
+	markerCOM  = 0xFE
+)
+
+// unzigzag maps the zigzag order coefficients are coded in to the natural
+// row-major order of a block
+var unzigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// block holds the quantized coefficients of one 8x8 block in natural order
+type block [64]int16
+
+// component is one color channel and its blocks
+type component struct {
+	id   byte
+	h, v int
+	tq   byte
+	// bw and bh are the plane size in blocks, padded to whole MCUs
+	bw, bh int
+	blocks []block
+}
+
+// quantTable is a quantization table in natural order
+type quantTable struct {
+	precision byte
+	values    [64]uint16
+}
+
+// segment is a marker segment copied into the output
+type segment struct {
+	marker  byte
+	payload []byte
+}
+
+// frame is a decoded JPEG: its coefficients and everything needed to write
+// them back
+type frame struct {
+	sof           byte
+	precision     byte
+	width, height int
+	comps         []*component
+	hmax, vmax    int
+	quant         [4]*quantTable
+	markers       []segment
+}
+
+// mcuSize is the size in pixels of a minimum coded unit
+func (f *frame) mcuSize() (int, int) {
+	return 8 * f.hmax, 8 * f.vmax
+}
+
+// mcus is the number of MCUs across and down an interleaved scan
+func (f *frame) mcus() (int, int) {
+	w, h := f.mcuSize()
+	return (f.width + w - 1) / w, (f.height + h - 1) / h
+}
+
+// blockCount is the number of blocks holding image data in a component,
+// as coded by a scan of that component alone
+func (f *frame) blockCount(c *component) (int, int) {
+	cw := (f.width*c.h + f.hmax - 1) / f.hmax
+	ch := (f.height*c.v + f.vmax - 1) / f.vmax
+	return (cw + 7) / 8, (ch + 7) / 8
+}
+
+func (c *component) at(x, y int) *block {
+	return &c.blocks[y*c.bw+x]
+}
+
+// huffDecoder decodes one Huffman table, following Annex F.2.2.3
+type huffDecoder struct {
+	minCode, maxCode [17]int32
+	valPtr           [17]int32
+	vals             []byte
+}
+
+func newHuffDecoder(counts []byte, vals []byte) *huffDecoder {
+	d := &huffDecoder{vals: vals}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		d.valPtr[l] = k
+		d.minCode[l] = code
+		code += n
+		k += n
+		d.maxCode[l] = code - 1
+		if n == 0 {
+			d.maxCode[l] = -1
+		}
+		code <<= 1
+	}
+	return d
+}
+
+// decode parses a sequential Huffman coded JPEG into its coefficients
+func decode(buf []byte) (*frame, error) {
+	if len(buf) < 4 || buf[0] != 0xFF || buf[1] != markerSOI {
+		return nil, fmt.Errorf("%w: not a JPEG file", ErrUnsupported)
+	}
+
+	var f *frame
+	var markers []segment
+	var quant [4]*quantTable
+	var dc, ac [4]*huffDecoder
+	restart := 0
+
+	pos := 2
+	for {
+		// Skip fill bytes between markers
+		for pos+1 < len(buf) && buf[pos] == 0xFF && buf[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+2 > len(buf) {
+			break
+		}
+		if buf[pos] != 0xFF {
+			return nil, fmt.Errorf("%w: bad marker at %d", ErrCorrupt, pos)
+		}
+		marker := buf[pos+1]
+		if marker == markerEOI {
+			break
+		}
+		if pos+4 > len(buf) {
+			return nil, fmt.Errorf("%w: truncated segment", ErrCorrupt)
+		}
+		length := int(binary.BigEndian.Uint16(buf[pos+2:]))
+		if length < 2 || pos+2+length > len(buf) {
+			return nil, fmt.Errorf("%w: truncated segment", ErrCorrupt)
+		}
+		payload := buf[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		var err error
+		switch {
+		case marker == markerSOF0 || marker == markerSOF1:
+			if f != nil {
+				return nil, fmt.Errorf("%w: several frames", ErrCorrupt)
+			}
+			f, err = parseSOF(marker, payload)
+		case marker >= 0xC2 && marker <= 0xCF && marker != markerDHT && marker != markerJPG && marker != markerDAC:
+			return nil, fmt.Errorf("%w: SOF%d coding (progressive, lossless or arithmetic)", ErrUnsupported, marker-0xC0)
+		case marker == markerDAC:
+			return nil, fmt.Errorf("%w: arithmetic coding", ErrUnsupported)
+		case marker == markerDHT:
+			err = parseDHT(payload, &dc, &ac)
+		case marker == markerDQT:
+			err = parseDQT(payload, &quant)
+		case marker == markerDRI:
+			if len(payload) < 2 {
+				return nil, fmt.Errorf("%w: short DRI", ErrCorrupt)
+			}
+			restart = int(binary.BigEndian.Uint16(payload))
+		case marker == markerSOS:
+			if f == nil {
+				return nil, fmt.Errorf("%w: scan before frame header", ErrCorrupt)
+			}
+			pos, err = f.decodeScan(buf, pos, payload, &dc, &ac, restart)
+		case marker >= markerAPP0 && marker <= markerAPPF || marker == markerCOM:
+			markers = append(markers, segment{marker: marker, payload: payload})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if f == nil {
+		return nil, fmt.Errorf("%w: no frame header", ErrCorrupt)
+	}
+	for _, c := range f.comps {
+		if quant[c.tq] == nil {
+			return nil, fmt.Errorf("%w: missing quantization table %d", ErrCorrupt, c.tq)
+		}
+	}
+	f.quant = quant
+	f.markers = markers
+	return f, nil
+}
+
+func parseSOF(marker byte, p []byte) (*frame, error) {
+	if len(p) < 6 {
+		return nil, fmt.Errorf("%w: short frame header", ErrCorrupt)
+	}
+	f := &frame{
+		sof:       marker,
+		precision: p[0],
+		height:    int(binary.BigEndian.Uint16(p[1:])),
+		width:     int(binary.BigEndian.Uint16(p[3:])),
+	}
+	n := int(p[5])
+	if f.height == 0 {
+		return nil, fmt.Errorf("%w: height defined by DNL", ErrUnsupported)
+	}
+	if f.width == 0 || n == 0 || len(p) < 6+3*n {
+		return nil, fmt.Errorf("%w: bad frame header", ErrCorrupt)
+	}
+
+	for i := 0; i < n; i++ {
+		c := &component{
+			id: p[6+3*i],
+			h:  int(p[7+3*i] >> 4),
+			v:  int(p[7+3*i] & 15),
+			tq: p[8+3*i],
+		}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return nil, fmt.Errorf("%w: bad component %d", ErrCorrupt, c.id)
+		}
+		if n == 1 {
+			// Sampling factors mean nothing for a single component, whose
+			// scan is coded one block at a time
+			c.h, c.v = 1, 1
+		}
+		f.hmax = max(f.hmax, c.h)
+		f.vmax = max(f.vmax, c.v)
+		f.comps = append(f.comps, c)
+	}
+
+	mx, my := f.mcus()
+	for _, c := range f.comps {
+		c.bw, c.bh = mx*c.h, my*c.v
+		c.blocks = make([]block, c.bw*c.bh)
+	}
+	return f, nil
+}
+
+func parseDHT(p []byte, dc, ac *[4]*huffDecoder) error {
+	for len(p) > 0 {
+		if len(p) < 17 {
+			return fmt.Errorf("%w: short Huffman table", ErrCorrupt)
+		}
+		class, id := p[0]>>4, p[0]&15
+		if class > 1 || id > 3 {
+			return fmt.Errorf("%w: bad Huffman table %d/%d", ErrCorrupt, class, id)
+		}
+		n := 0
+		for _, c := range p[1:17] {
+			n += int(c)
+		}
+		if n > 256 || len(p) < 17+n {
+			return fmt.Errorf("%w: bad Huffman table", ErrCorrupt)
+		}
+		d := newHuffDecoder(p[1:17], p[17:17+n])
+		if class == 0 {
+			dc[id] = d
+		} else {
+			ac[id] = d
+		}
+		p = p[17+n:]
+	}
+	return nil
+}
+
+func parseDQT(p []byte, quant *[4]*quantTable) error {
+	for len(p) > 0 {
+		precision, id := p[0]>>4, p[0]&15
+		size := 64
+		if precision == 1 {
+			size = 128
+		}
+		if precision > 1 || id > 3 || len(p) < 1+size {
+			return fmt.Errorf("%w: bad quantization table", ErrCorrupt)
+		}
+		q := &quantTable{precision: precision}
+		for k := 0; k < 64; k++ {
+			if precision == 1 {
+				q.values[unzigzag[k]] = binary.BigEndian.Uint16(p[1+2*k:])
+			} else {
+				q.values[unzigzag[k]] = uint16(p[1+k])
+			}
+		}
+		quant[id] = q
+		p = p[1+size:]
+	}
+	return nil
+}
+
+// bitReader reads entropy coded data, removing stuffed zero bytes and
+// stopping at the first marker
+type bitReader struct {
+	buf []byte
+	pos int
+	acc uint32
+	n   uint
+	// marker is set once a marker has been reached; zeros are read after it
+	marker bool
+}
+
+func (r *bitReader) fill() {
+	for r.n <= 24 {
+		var b byte
+		if !r.marker && r.pos < len(r.buf) {
+			b = r.buf[r.pos]
+			if b == 0xFF {
+				if r.pos+1 < len(r.buf) && r.buf[r.pos+1] == 0 {
+					r.pos += 2
+				} else {
+					r.marker = true
+					b = 0
+				}
+			} else {
+				r.pos++
+			}
+		}
+		r.acc |= uint32(b) << (24 - r.n)
+		r.n += 8
+	}
+}
+
+func (r *bitReader) bits(n uint) int32 {
+	if n == 0 {
+		return 0
+	}
+	r.fill()
+	v := r.acc >> (32 - n)
+	r.acc <<= n
+	r.n -= n
+	return int32(v)
+}
+
+// reset drops the bits left in the current byte and steps over the restart
+// marker that must follow
+func (r *bitReader) reset() error {
+	r.acc, r.n = 0, 0
+	if !r.marker {
+		// Skip any padding up to the marker
+		for r.pos+1 < len(r.buf) && !(r.buf[r.pos] == 0xFF && r.buf[r.pos+1] != 0) {
+			r.pos++
+		}
+	}
+	if r.pos+1 >= len(r.buf) || r.buf[r.pos+1] < markerRST0 || r.buf[r.pos+1] > markerRST7 {
+		return fmt.Errorf("%w: missing restart marker", ErrCorrupt)
+	}
+	r.pos += 2
+	r.marker = false
+	return nil
+}
+
+func (r *bitReader) decode(d *huffDecoder) (byte, error) {
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		code = code<<1 | r.bits(1)
+		if code <= d.maxCode[l] {
+			i := d.valPtr[l] + code - d.minCode[l]
+			if int(i) >= len(d.vals) {
+				break
+			}
+			return d.vals[i], nil
+		}
+	}
+	return 0, fmt.Errorf("%w: bad Huffman code", ErrCorrupt)
+}
+
+// receive reads an s-bit magnitude and extends its sign (F.2.2.1)
+func (r *bitReader) receive(s byte) int32 {
+	v := r.bits(uint(s))
+	if s > 0 && v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v
+}
+
+// decodeScan decodes the entropy coded data after an SOS header at pos and
+// returns the position of the marker that ends it
+func (f *frame) decodeScan(buf []byte, pos int, header []byte, dc, ac *[4]*huffDecoder, restart int) (int, error) {
+	if len(header) < 1 {
+		return 0, fmt.Errorf("%w: short scan header", ErrCorrupt)
+	}
+	n := int(header[0])
+	if n < 1 || n > 4 || len(header) < 1+2*n+3 {
+		return 0, fmt.Errorf("%w: bad scan header", ErrCorrupt)
+	}
+
+	type scanComp struct {
+		c      *component
+		dc, ac *huffDecoder
+		pred   int32
+	}
+	comps := make([]*scanComp, n)
+	for i := range comps {
+		id, tables := header[1+2*i], header[2+2*i]
+		sc := &scanComp{dc: dc[tables>>4&3], ac: ac[tables&3]}
+		for _, c := range f.comps {
+			if c.id == id {
+				sc.c = c
+			}
+		}
+		if sc.c == nil || sc.dc == nil || sc.ac == nil {
+			return 0, fmt.Errorf("%w: scan refers to unknown component or table", ErrCorrupt)
+		}
+		comps[i] = sc
+	}
+	ss, se, a := header[1+2*n], header[2+2*n], header[3+2*n]
+	if ss != 0 || se != 63 || a != 0 {
+		return 0, fmt.Errorf("%w: spectral selection in a sequential scan", ErrUnsupported)
+	}
+
+	r := &bitReader{buf: buf, pos: pos}
+	decodeBlock := func(sc *scanComp, b *block) error {
+		t, err := r.decode(sc.dc)
+		if err != nil {
+			return err
+		}
+		if t > 15 {
+			return fmt.Errorf("%w: bad DC magnitude", ErrCorrupt)
+		}
+		sc.pred += r.receive(t)
+		b[0] = int16(sc.pred)
+
+		for k := 1; k < 64; {
+			rs, err := r.decode(sc.ac)
+			if err != nil {
+				return err
+			}
+			run, size := int(rs>>4), rs&15
+			if size == 0 {
+				if run != 15 {
+					break
+				}
+				k += 16
+				continue
+			}
+			k += run
+			if k > 63 {
+				return fmt.Errorf("%w: coefficient index out of range", ErrCorrupt)
+			}
+			b[unzigzag[k]] = int16(r.receive(size))
+			k++
+		}
+		return nil
+	}
+
+	// A scan of one component codes its blocks one by one, otherwise in
+	// MCUs holding h x v blocks of each component
+	mx, my := f.mcus()
+	if n == 1 {
+		mx, my = f.blockCount(comps[0].c)
+	}
+	for m := 0; m < mx*my; m++ {
+		if restart > 0 && m > 0 && m%restart == 0 {
+			if err := r.reset(); err != nil {
+				return 0, err
+			}
+			for _, sc := range comps {
+				sc.pred = 0
+			}
+		}
+
+		x, y := m%mx, m/mx
+		for _, sc := range comps {
+			if n == 1 {
+				if err := decodeBlock(sc, sc.c.at(x, y)); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			for v := 0; v < sc.c.v; v++ {
+				for h := 0; h < sc.c.h; h++ {
+					if err := decodeBlock(sc, sc.c.at(x*sc.c.h+h, y*sc.c.v+v)); err != nil {
+						return 0, err
+					}
+				}
+			}
+		}
+	}
+
+	// Find the marker after the scan, skipping any trailing restart marker
+	pos = r.pos
+	for pos+1 < len(buf) {
+		if buf[pos] == 0xFF && buf[pos+1] != 0 && (buf[pos+1] < markerRST0 || buf[pos+1] > markerRST7) && buf[pos+1] != 0xFF {
+			return pos, nil
+		}
+		pos++
+	}
+	return len(buf), nil
+}
@@ -0,0 +1,399 @@
+package jpegcrop
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// maxBlocksInMCU is the most blocks an interleaved scan may hold per MCU
+const maxBlocksInMCU = 10
+
+// huffEncoder holds the code of every symbol of one Huffman table
+type huffEncoder struct {
+	counts [16]byte
+	vals   []byte
+	code   [256]uint16
+	size   [256]byte
+}
+
+// stats counts how often each symbol of one Huffman table is coded
+type stats [257]int64
+
+// encode writes f as a sequential JPEG with Huffman tables fitted to its
+// coefficients, which usually makes the result slightly smaller than the
+// source. The luma component uses table 0 and the others table 1.
+func (f *frame) encode(strip bool) ([]byte, error) {
+	scans := f.scans()
+
+	// First pass: gather the symbol counts
+	var dcStats, acStats [2]stats
+	for _, scan := range scans {
+		f.walkScan(scan, func(c *component, b *block, pred int16) {
+			t := f.table(c)
+			countBlock(b, pred, &dcStats[t], &acStats[t])
+		})
+	}
+	tables := 1
+	if len(f.comps) > 1 {
+		tables = 2
+	}
+	var dc, ac [2]*huffEncoder
+	for t := 0; t < tables; t++ {
+		dc[t] = optimalTable(&dcStats[t])
+		ac[t] = optimalTable(&acStats[t])
+	}
+
+	var out bytes.Buffer
+	out.Write([]byte{0xFF, markerSOI})
+	for _, s := range f.markers {
+		if strip && !keepWhenStripping(s) {
+			continue
+		}
+		writeSegment(&out, s.marker, s.payload)
+	}
+
+	// Quantization tables in use, in zigzag order
+	var dqt []byte
+	var written [4]bool
+	for _, c := range f.comps {
+		if written[c.tq] {
+			continue
+		}
+		written[c.tq] = true
+		q := f.quant[c.tq]
+		dqt = append(dqt, q.precision<<4|c.tq)
+		for k := 0; k < 64; k++ {
+			v := q.values[unzigzag[k]]
+			if q.precision == 1 {
+				dqt = binary.BigEndian.AppendUint16(dqt, v)
+			} else {
+				dqt = append(dqt, byte(v))
+			}
+		}
+	}
+	writeSegment(&out, markerDQT, dqt)
+
+	sof := []byte{f.precision}
+	sof = binary.BigEndian.AppendUint16(sof, uint16(f.height))
+	sof = binary.BigEndian.AppendUint16(sof, uint16(f.width))
+	sof = append(sof, byte(len(f.comps)))
+	for _, c := range f.comps {
+		sof = append(sof, c.id, byte(c.h<<4|c.v), c.tq)
+	}
+	writeSegment(&out, f.sof, sof)
+
+	var dht []byte
+	for t := 0; t < tables; t++ {
+		for class, h := range []*huffEncoder{dc[t], ac[t]} {
+			dht = append(dht, byte(class<<4|t))
+			dht = append(dht, h.counts[:]...)
+			dht = append(dht, h.vals...)
+		}
+	}
+	writeSegment(&out, markerDHT, dht)
+
+	// Second pass: the entropy coded scans
+	for _, scan := range scans {
+		sos := []byte{byte(len(scan))}
+		for _, c := range scan {
+			t := byte(f.table(c))
+			sos = append(sos, c.id, t<<4|t)
+		}
+		sos = append(sos, 0, 63, 0)
+		writeSegment(&out, markerSOS, sos)
+
+		w := &bitWriter{out: &out}
+		f.walkScan(scan, func(c *component, b *block, pred int16) {
+			t := f.table(c)
+			w.writeBlock(b, pred, dc[t], ac[t])
+		})
+		w.flush()
+	}
+
+	out.Write([]byte{0xFF, markerEOI})
+	return out.Bytes(), nil
+}
+
+// table returns the Huffman table pair used for c
+func (f *frame) table(c *component) int {
+	if c == f.comps[0] {
+		return 0
+	}
+	return 1
+}
+
+// scans groups the components into scans: one interleaved scan when the
+// MCU is small enough, otherwise one scan per component
+func (f *frame) scans() [][]*component {
+	blocks := 0
+	for _, c := range f.comps {
+		blocks += c.h * c.v
+	}
+	if len(f.comps) <= 4 && blocks <= maxBlocksInMCU {
+		return [][]*component{f.comps}
+	}
+	scans := make([][]*component, len(f.comps))
+	for i, c := range f.comps {
+		scans[i] = []*component{c}
+	}
+	return scans
+}
+
+// walkScan calls fn for every block of a scan in coding order, along with
+// the DC value of the block coded before it in the same component
+func (f *frame) walkScan(scan []*component, fn func(c *component, b *block, pred int16)) {
+	preds := make([]int16, len(scan))
+	visit := func(i int, b *block) {
+		fn(scan[i], b, preds[i])
+		preds[i] = b[0]
+	}
+
+	if len(scan) == 1 {
+		c := scan[0]
+		bw, bh := f.blockCount(c)
+		for y := 0; y < bh; y++ {
+			for x := 0; x < bw; x++ {
+				visit(0, c.at(x, y))
+			}
+		}
+		return
+	}
+
+	mx, my := f.mcus()
+	for y := 0; y < my; y++ {
+		for x := 0; x < mx; x++ {
+			for i, c := range scan {
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						visit(i, c.at(x*c.h+h, y*c.v+v))
+					}
+				}
+			}
+		}
+	}
+}
+
+// keepWhenStripping reports whether a segment defines how the image data is
+// to be read, so that it must survive StripMarkers
+func keepWhenStripping(s segment) bool {
+	switch s.marker {
+	case markerAPP0:
+		return bytes.HasPrefix(s.payload, []byte("JFIF\x00"))
+	case markerAPPE:
+		return bytes.HasPrefix(s.payload, []byte("Adobe"))
+	}
+	return false
+}
+
+func writeSegment(out *bytes.Buffer, marker byte, payload []byte) {
+	out.Write([]byte{0xFF, marker})
+	binary.Write(out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+}
+
+// category returns the number of bits needed for the magnitude of v
+func category(v int32) byte {
+	if v < 0 {
+		v = -v
+	}
+	var n byte
+	for v > 0 {
+		n++
+		v >>= 1
+	}
+	return n
+}
+
+func countBlock(b *block, pred int16, dc, ac *stats) {
+	dc[category(int32(b[0])-int32(pred))]++
+	run := 0
+	for k := 1; k < 64; k++ {
+		v := b[unzigzag[k]]
+		if v == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			ac[0xF0]++
+		}
+		ac[run<<4|int(category(int32(v)))]++
+		run = 0
+	}
+	if run > 0 {
+		ac[0x00]++
+	}
+}
+
+// optimalTable builds a Huffman table for the given symbol counts, limited
+// to 16-bit codes, as libjpeg's jpeg_gen_optimal_table does (Annex K.2)
+func optimalTable(counts *stats) *huffEncoder {
+	const maxLen = 32
+	freq := *counts
+	// A reserved symbol keeps any real code from being all ones
+	freq[256] = 1
+
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		// The two least frequent symbols, preferring the later on ties
+		c1, c2 := -1, -1
+		for i := range freq {
+			if freq[i] == 0 {
+				continue
+			}
+			if c1 < 0 || freq[i] <= freq[c1] {
+				c1 = i
+			}
+		}
+		for i := range freq {
+			if freq[i] == 0 || i == c1 {
+				continue
+			}
+			if c2 < 0 || freq[i] <= freq[c2] {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+
+	var bits [maxLen + 1]int
+	for _, n := range codesize {
+		if n > 0 {
+			bits[n]++
+		}
+	}
+	// Shorten codes over 16 bits, moving pairs up the tree
+	for i := maxLen; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	// Drop the reserved symbol, which has one of the longest codes
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	h := &huffEncoder{}
+	for l := 1; l <= 16; l++ {
+		h.counts[l-1] = byte(bits[l])
+	}
+	for l := 1; l <= maxLen; l++ {
+		for s := 0; s < 256; s++ {
+			if codesize[s] == l {
+				h.vals = append(h.vals, byte(s))
+			}
+		}
+	}
+
+	// Assign the codes in canonical order (Annex C)
+	code, k := uint16(0), 0
+	for l := 1; l <= 16; l++ {
+		for n := 0; n < int(h.counts[l-1]); n++ {
+			s := h.vals[k]
+			h.code[s] = code
+			h.size[s] = byte(l)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return h
+}
+
+// bitWriter writes entropy coded data, stuffing a zero after every 0xFF
+type bitWriter struct {
+	out *bytes.Buffer
+	acc uint32
+	n   uint
+}
+
+func (w *bitWriter) write(v uint32, n byte) {
+	if n == 0 {
+		return
+	}
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += uint(n)
+	for w.n >= 8 {
+		b := byte(w.acc >> (w.n - 8))
+		w.out.WriteByte(b)
+		if b == 0xFF {
+			w.out.WriteByte(0)
+		}
+		w.n -= 8
+	}
+}
+
+// flush pads the last byte with ones
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.write(1<<(8-w.n)-1, byte(8-w.n))
+	}
+}
+
+func (w *bitWriter) symbol(h *huffEncoder, s byte) {
+	w.write(uint32(h.code[s]), h.size[s])
+}
+
+// value writes the low bits of v for a magnitude of the given category,
+// ones' complement for negative values (F.1.2.1)
+func (w *bitWriter) value(v int32, size byte) {
+	if v < 0 {
+		v--
+	}
+	w.write(uint32(v), size)
+}
+
+func (w *bitWriter) writeBlock(b *block, pred int16, dc, ac *huffEncoder) {
+	diff := int32(b[0]) - int32(pred)
+	size := category(diff)
+	w.symbol(dc, size)
+	w.value(diff, size)
+
+	run := 0
+	for k := 1; k < 64; k++ {
+		v := b[unzigzag[k]]
+		if v == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			w.symbol(ac, 0xF0)
+		}
+		size := category(int32(v))
+		w.symbol(ac, byte(run<<4)|size)
+		w.value(int32(v), size)
+		run = 0
+	}
+	if run > 0 {
+		w.symbol(ac, 0x00)
+	}
+}
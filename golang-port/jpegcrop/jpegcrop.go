@@ -0,0 +1,156 @@
+// Package jpegcrop crops and rotates JPEG files without recompression, in
+// the manner of jpegtran: the quantized DCT coefficients are moved between
+// blocks and entropy coded again, so no pixel changes. Sequential Huffman
+// coded files (baseline and extended) are supported; progressive and
+// arithmetic coded files are not.
+package jpegcrop
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+)
+
+var (
+	// ErrUnsupported means the file is not a JPEG this package can rewrite,
+	// such as a progressive JPEG; callers should fall back to re-encoding
+	ErrUnsupported = errors.New("unsupported JPEG for lossless crop")
+	// ErrCorrupt means the JPEG data could not be decoded
+	ErrCorrupt = errors.New("corrupt JPEG data")
+)
+
+// Transform is a lossless rotation or flip, named after the EXIF
+// orientation it undoes
+type Transform int
+
+const (
+	None Transform = iota
+	FlipHorizontal
+	FlipVertical
+	Transpose
+	Transverse
+	Rotate90
+	Rotate180
+	Rotate270
+)
+
+// ForOrientation returns the transform that makes an image with the given
+// EXIF orientation (1-8) upright. Rotations are clockwise.
+func ForOrientation(orientation int) Transform {
+	switch orientation {
+	case 2:
+		return FlipHorizontal
+	case 3:
+		return Rotate180
+	case 4:
+		return FlipVertical
+	case 5:
+		return Transpose
+	case 6:
+		return Rotate90
+	case 7:
+		return Transverse
+	case 8:
+		return Rotate270
+	}
+	return None
+}
+
+// transposes reports whether t swaps rows and columns
+func (t Transform) transposes() bool {
+	return t == Transpose || t == Transverse || t == Rotate90 || t == Rotate270
+}
+
+// flips reports whether t mirrors the image horizontally and vertically,
+// applied after any transposition
+func (t Transform) flips() (horizontal, vertical bool) {
+	switch t {
+	case FlipHorizontal, Rotate90:
+		return true, false
+	case FlipVertical, Rotate270:
+		return false, true
+	case Rotate180, Transverse:
+		return true, true
+	}
+	return false, false
+}
+
+// Snap chooses how the left and top edges of a crop move onto the block
+// grid. The right and bottom edges are always kept exact.
+type Snap int
+
+const (
+	// SnapOutward widens the crop to the grid line before it, keeping all
+	// of the requested area
+	SnapOutward Snap = iota
+	// SnapInward narrows the crop to the grid line after it, keeping nothing
+	// outside the requested area
+	SnapInward
+)
+
+// ParseSnap maps a snap name (outward or inward) to its Snap
+func ParseSnap(name string) (Snap, error) {
+	switch strings.ToLower(name) {
+	case "outward":
+		return SnapOutward, nil
+	case "inward":
+		return SnapInward, nil
+	}
+	return SnapOutward, fmt.Errorf("unknown snap %q (known: outward, inward)", name)
+}
+
+// Options describes a lossless crop
+type Options struct {
+	// Transform is applied first; Rect is in the transformed image
+	Transform Transform
+	// Rect is the area to keep. An empty Rect keeps the whole image.
+	Rect image.Rectangle
+	Snap Snap
+	// StripMarkers drops APPn and COM segments, other than the JFIF and
+	// Adobe segments that define the color space
+	StripMarkers bool
+}
+
+// Crop applies opts to the JPEG file in src. It returns the new file and
+// the area it covers in the transformed image, which differs from
+// opts.Rect where edges were snapped to the block grid.
+//
+// Partial blocks can only sit at the right and bottom edge of a JPEG, so
+// transforms that move them elsewhere drop them first, as jpegtran -trim
+// does. APPn and COM segments are copied unchanged, so an EXIF orientation
+// still describes src.
+func Crop(src []byte, opts Options) ([]byte, image.Rectangle, error) {
+	f, err := decode(src)
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+
+	f, origin := f.transform(opts.Transform)
+
+	bounds := image.Rect(0, 0, f.width, f.height)
+	r := bounds
+	if !opts.Rect.Empty() {
+		r = opts.Rect.Sub(origin).Intersect(bounds)
+	}
+
+	mcuW, mcuH := f.mcuSize()
+	switch opts.Snap {
+	case SnapInward:
+		r.Min.X = (r.Min.X + mcuW - 1) / mcuW * mcuW
+		r.Min.Y = (r.Min.Y + mcuH - 1) / mcuH * mcuH
+	default:
+		r.Min.X = r.Min.X / mcuW * mcuW
+		r.Min.Y = r.Min.Y / mcuH * mcuH
+	}
+	if r.Empty() {
+		return nil, image.Rectangle{}, fmt.Errorf("crop %v is empty on the %dx%d block grid", opts.Rect, mcuW, mcuH)
+	}
+
+	f = f.crop(r)
+	out, err := f.encode(opts.StripMarkers)
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+	return out, r.Add(origin), nil
+}
@@ -0,0 +1,235 @@
+package jpegcrop
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// tolerance is the largest difference per channel between a decoded
+// transform and the transformed decoded original. The coefficients are
+// the same, but the decoder's integer IDCT rounds mirrored blocks a little
+// differently, and the conversion to RGB magnifies chroma rounding.
+const tolerance = 3
+
+var transforms = []Transform{None, FlipHorizontal, FlipVertical, Transpose, Transverse, Rotate90, Rotate180, Rotate270}
+
+// wave is a smooth pattern that changes enough from one pixel to the next
+// for a pixel moved to the wrong place to stand out
+func wave(x, y int, fx, fy float64) uint8 {
+	return uint8(128 + 100*math.Sin(float64(x)*fx)*math.Cos(float64(y)*fy))
+}
+
+// testJPEG encodes a w x h image with color, which the standard encoder
+// subsamples 4:2:0 onto a 16 pixel grid, or in gray on an 8 pixel grid
+func testJPEG(t *testing.T, w, h int, gray bool) []byte {
+	t.Helper()
+	var img image.Image
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				g.SetGray(x, y, color.Gray{wave(x, y, 0.7, 0.5)})
+			}
+		}
+		img = g
+	} else {
+		c := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				c.SetRGBA(x, y, color.RGBA{wave(x, y, 0.7, 0.5), wave(x, y, 0.3, 0.8), wave(y, x, 0.4, 0.2), 255})
+			}
+		}
+		img = c
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeJPEG(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// transformedSize is the size of a w x h image after t
+func transformedSize(t Transform, w, h int) (int, int) {
+	if t.transposes() {
+		return h, w
+	}
+	return w, h
+}
+
+// source returns the point of a w x h image that t moves to (x, y)
+func source(t Transform, w, h, x, y int) (int, int) {
+	switch t {
+	case FlipHorizontal:
+		return w - 1 - x, y
+	case FlipVertical:
+		return x, h - 1 - y
+	case Transpose:
+		return y, x
+	case Transverse:
+		return w - 1 - y, h - 1 - x
+	case Rotate90:
+		return y, h - 1 - x
+	case Rotate180:
+		return w - 1 - x, h - 1 - y
+	case Rotate270:
+		return w - 1 - y, x
+	}
+	return x, y
+}
+
+// comparePixels checks that every pixel of got, which covers r in the
+// transformed image, matches the pixel of orig that t moves there
+func comparePixels(got, orig image.Image, tr Transform, r image.Rectangle) error {
+	if got.Bounds().Dx() != r.Dx() || got.Bounds().Dy() != r.Dy() {
+		return fmt.Errorf("decoded size %v, want %dx%d", got.Bounds().Size(), r.Dx(), r.Dy())
+	}
+	w, h := orig.Bounds().Dx(), orig.Bounds().Dy()
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			sx, sy := source(tr, w, h, r.Min.X+x, r.Min.Y+y)
+			a := color.RGBAModel.Convert(got.At(x, y)).(color.RGBA)
+			b := color.RGBAModel.Convert(orig.At(sx, sy)).(color.RGBA)
+			if diff(a.R, b.R) > tolerance || diff(a.G, b.G) > tolerance || diff(a.B, b.B) > tolerance {
+				return fmt.Errorf("pixel (%d,%d) = %v, want %v from (%d,%d)", r.Min.X+x, r.Min.Y+y, a, b, sx, sy)
+			}
+		}
+	}
+	return nil
+}
+
+func diff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func TestCrop(t *testing.T) {
+	images := []struct {
+		name string
+		w, h int
+		gray bool
+		mcu  int
+	}{
+		{"color", 64, 48, false, 16},
+		{"color with partial blocks", 70, 53, false, 16},
+		{"gray", 40, 24, true, 8},
+		{"gray with partial blocks", 45, 30, true, 8},
+	}
+	for _, im := range images {
+		src := testJPEG(t, im.w, im.h, im.gray)
+		orig := decodeJPEG(t, src)
+		for _, tr := range transforms {
+			tw, th := transformedSize(tr, im.w, im.h)
+			for _, snap := range []Snap{SnapOutward, SnapInward} {
+				for _, rect := range []image.Rectangle{{}, image.Rect(im.mcu+3, im.mcu+1, tw-5, th-2)} {
+					name := fmt.Sprintf("%s %v snap %v rect %v", im.name, tr, snap, rect)
+					out, got, err := Crop(src, Options{Transform: tr, Rect: rect, Snap: snap})
+					if err != nil {
+						t.Errorf("%s: %v", name, err)
+						continue
+					}
+
+					// Partial blocks moved to the left or top are dropped,
+					// so the result ends where the transformed image ends
+					if got.Max.X > tw || got.Max.Y > th || got.Min.X < 0 || got.Min.Y < 0 {
+						t.Errorf("%s: covers %v outside %dx%d", name, got, tw, th)
+						continue
+					}
+					want := image.Rect(0, 0, tw, th)
+					if !rect.Empty() {
+						want = rect
+					}
+					if got.Max != want.Max {
+						t.Errorf("%s: covers %v, want the right and bottom edge of %v", name, got, want)
+					}
+					if snap == SnapInward && !got.In(want) {
+						t.Errorf("%s: inward snap covers %v outside %v", name, got, want)
+					}
+					if snap == SnapOutward && (got.Min.X > want.Min.X+im.mcu || got.Min.Y > want.Min.Y+im.mcu) {
+						t.Errorf("%s: outward snap covers %v, which cuts into %v", name, got, want)
+					}
+					if !rect.Empty() && snap == SnapOutward && !want.In(got) {
+						t.Errorf("%s: outward snap covers %v, want all of %v", name, got, want)
+					}
+
+					if err := comparePixels(decodeJPEG(t, out), orig, tr, got); err != nil {
+						t.Errorf("%s: %v", name, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCropMarkers(t *testing.T) {
+	src := testJPEG(t, 32, 32, false)
+	comment := []byte("film-crop-detector test comment")
+	seg := append([]byte{0xFF, markerCOM, 0, byte(len(comment) + 2)}, comment...)
+	src = append(append(append([]byte{}, src[:2]...), seg...), src[2:]...)
+
+	out, _, err := Crop(src, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, comment) {
+		t.Error("comment dropped without StripMarkers")
+	}
+	out, _, err = Crop(src, Options{StripMarkers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, comment) {
+		t.Error("comment kept with StripMarkers")
+	}
+	decodeJPEG(t, out)
+}
+
+func TestCropErrors(t *testing.T) {
+	src := testJPEG(t, 32, 32, false)
+	if _, _, err := Crop([]byte("not a jpeg"), Options{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("non-JPEG error = %v, want %v", err, ErrUnsupported)
+	}
+	if _, _, err := Crop(src[:len(src)/2], Options{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("truncated JPEG error = %v, want %v", err, ErrCorrupt)
+	}
+	if _, _, err := Crop(src, Options{Rect: image.Rect(20, 20, 30, 30), Snap: SnapInward}); err == nil {
+		t.Error("crop between two grid lines did not fail")
+	}
+}
+
+func TestForOrientation(t *testing.T) {
+	// Orientations outside 1-8 are left alone
+	want := map[int]Transform{1: None, 2: FlipHorizontal, 3: Rotate180, 4: FlipVertical, 5: Transpose, 6: Rotate90, 7: Transverse, 8: Rotate270, 0: None, 9: None}
+	for o, tr := range want {
+		if got := ForOrientation(o); got != tr {
+			t.Errorf("ForOrientation(%d) = %v, want %v", o, got, tr)
+		}
+	}
+}
+
+func TestParseSnap(t *testing.T) {
+	for name, want := range map[string]Snap{"outward": SnapOutward, "Inward": SnapInward} {
+		if got, err := ParseSnap(name); err != nil || got != want {
+			t.Errorf("ParseSnap(%q) = %v, %v", name, got, err)
+		}
+	}
+	if _, err := ParseSnap("nearest"); err == nil {
+		t.Error("ParseSnap accepted an unknown name")
+	}
+}
@@ -0,0 +1,136 @@
+package jpegcrop
+
+import "image"
+
+// transform returns f with t applied, and the position of its top left
+// corner in the full transformed image. The two differ when partial MCUs
+// that would land on the left or top edge are dropped.
+func (f *frame) transform(t Transform) (*frame, image.Point) {
+	if t == None {
+		return f, image.Point{}
+	}
+	if t.transposes() {
+		f = f.transpose()
+	}
+
+	flipH, flipV := t.flips()
+	mcuW, mcuH := f.mcuSize()
+	var origin image.Point
+	width, height := f.width, f.height
+	if flipH {
+		width = f.width / mcuW * mcuW
+		origin.X = f.width - width
+	}
+	if flipV {
+		height = f.height / mcuH * mcuH
+		origin.Y = f.height - height
+	}
+
+	out := f.resized(width, height)
+	for i, c := range f.comps {
+		o := out.comps[i]
+		for y := 0; y < o.bh; y++ {
+			for x := 0; x < o.bw; x++ {
+				sx, sy := x, y
+				if flipH {
+					sx = o.bw - 1 - x
+				}
+				if flipV {
+					sy = o.bh - 1 - y
+				}
+				b := *c.at(sx, sy)
+				flipBlock(&b, flipH, flipV)
+				*o.at(x, y) = b
+			}
+		}
+	}
+	return out, origin
+}
+
+// transpose swaps the rows and columns of f, its blocks and its
+// quantization tables
+func (f *frame) transpose() *frame {
+	out := &frame{
+		sof:       f.sof,
+		precision: f.precision,
+		width:     f.height,
+		height:    f.width,
+		hmax:      f.vmax,
+		vmax:      f.hmax,
+		markers:   f.markers,
+	}
+	for i, q := range f.quant {
+		if q == nil {
+			continue
+		}
+		t := &quantTable{precision: q.precision}
+		for k, v := range q.values {
+			t.values[k%8*8+k/8] = v
+		}
+		out.quant[i] = t
+	}
+	for _, c := range f.comps {
+		o := &component{id: c.id, h: c.v, v: c.h, tq: c.tq, bw: c.bh, bh: c.bw}
+		o.blocks = make([]block, len(c.blocks))
+		for y := 0; y < c.bh; y++ {
+			for x := 0; x < c.bw; x++ {
+				b := c.at(x, y)
+				t := o.at(y, x)
+				for k, v := range b {
+					t[k%8*8+k/8] = v
+				}
+			}
+		}
+		out.comps = append(out.comps, o)
+	}
+	return out
+}
+
+// crop returns the part of f inside r, whose top left corner must lie on
+// the MCU grid
+func (f *frame) crop(r image.Rectangle) *frame {
+	mcuW, mcuH := f.mcuSize()
+	out := f.resized(r.Dx(), r.Dy())
+	for i, c := range f.comps {
+		o := out.comps[i]
+		x0, y0 := r.Min.X/mcuW*c.h, r.Min.Y/mcuH*c.v
+		for y := 0; y < o.bh && y0+y < c.bh; y++ {
+			for x := 0; x < o.bw && x0+x < c.bw; x++ {
+				*o.at(x, y) = *c.at(x0+x, y0+y)
+			}
+		}
+	}
+	return out
+}
+
+// resized returns an empty frame like f with a new size
+func (f *frame) resized(width, height int) *frame {
+	out := *f
+	out.width, out.height = width, height
+	out.comps = nil
+	mx, my := out.mcus()
+	for _, c := range f.comps {
+		o := *c
+		o.bw, o.bh = mx*c.h, my*c.v
+		o.blocks = make([]block, o.bw*o.bh)
+		out.comps = append(out.comps, &o)
+	}
+	return &out
+}
+
+// flipBlock mirrors a block by negating its odd horizontal or vertical
+// frequencies
+func flipBlock(b *block, horizontal, vertical bool) {
+	if !horizontal && !vertical {
+		return
+	}
+	for k := range b {
+		u, v := k%8, k/8
+		if horizontal && u%2 == 1 {
+			b[k] = -b[k]
+		}
+		if vertical && v%2 == 1 {
+			b[k] = -b[k]
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package metadata
+
+import (
+	"encoding/binary"
+)
+
+// exifBlock is a parsed EXIF payload: the primary IFD with its Exif, GPS and
+// Interop sub-IFDs. The thumbnail IFD is dropped since it shows the uncropped
+// frame.
+type exifBlock struct {
+	order binary.ByteOrder
+	ifd0  []entry
+}
+
+// ifd0Tags are the primary IFD fields carried between files. Structural
+// fields such as strip layout or compression always come from the output.
+var ifd0Tags = map[uint16]bool{
+	270:            true, // ImageDescription
+	271:            true, // Make
+	272:            true, // Model
+	282:            true, // XResolution
+	283:            true, // YResolution
+	296:            true, // ResolutionUnit
+	305:            true, // Software
+	306:            true, // DateTime
+	315:            true, // Artist
+	33432:          true, // Copyright
+	tagExifIFD:     true,
+	tagGPSIFD:      true,
+	tagOrientation: true,
+}
+
+// parseExif decodes a TIFF-structured EXIF payload
+func parseExif(buf []byte) (*exifBlock, error) {
+	order, err := parseByteOrder(buf)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, _, err := readIFD(buf, order, order.Uint32(buf[4:]), 0)
+	if err != nil {
+		return nil, err
+	}
+	return &exifBlock{order: order, ifd0: ifd0}, nil
+}
+
+// encode serializes the block as a TIFF-structured EXIF payload
+func (b *exifBlock) encode() []byte {
+	header := make([]byte, 8)
+	if b.order == binary.LittleEndian {
+		copy(header, "II")
+	} else {
+		copy(header, "MM")
+	}
+	b.order.PutUint16(header[2:], 42)
+	b.order.PutUint32(header[4:], 8)
+	return append(header, encodeIFD(b.order, b.ifd0, 8, 0)...)
+}
+
+// orientation returns the Orientation tag, or 1 when absent
+func (b *exifBlock) orientation() int {
+	if e, ok := findEntry(b.ifd0, tagOrientation); ok {
+		if v, err := e.uintValue(b.order); err == nil && v >= 1 && v <= 8 {
+			return int(v)
+		}
+	}
+	return 1
+}
+
+// update resets the orientation and records the new pixel dimensions
+func (b *exifBlock) update(width, height int) {
+	b.ifd0 = setEntry(b.ifd0, shortEntry(b.order, tagOrientation, 1))
+
+	exif, ok := findEntry(b.ifd0, tagExifIFD)
+	if !ok || exif.sub == nil {
+		return
+	}
+	exif.sub = setEntry(exif.sub, longEntry(b.order, tagPixelXDimension, uint32(width)))
+	exif.sub = setEntry(exif.sub, longEntry(b.order, tagPixelYDimension, uint32(height)))
+}
+
+// descriptive returns the primary IFD fields worth copying into another file
+func (b *exifBlock) descriptive() []entry {
+	var out []entry
+	for _, e := range b.ifd0 {
+		if ifd0Tags[e.tag] {
+			e.sub = append([]entry(nil), e.sub...)
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,282 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// TIFF field types
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeSByte     = 6
+	typeUndefined = 7
+	typeSShort    = 8
+	typeSLong     = 9
+	typeSRational = 10
+	typeFloat     = 11
+	typeDouble    = 12
+	typeIFD       = 13
+)
+
+// Tags this package reads or rewrites
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagOrientation     = 274
+	tagXMP             = 700
+	tagIPTC            = 33723
+	tagPhotoshop       = 34377
+	tagExifIFD         = 34665
+	tagICCProfile      = 34675
+	tagGPSIFD          = 34853
+	tagInteropIFD      = 40965
+	tagPixelXDimension = 40962
+	tagPixelYDimension = 40963
+)
+
+// subIFDTags point at nested IFDs that are copied along with their parent
+var subIFDTags = map[uint16]bool{tagExifIFD: true, tagGPSIFD: true, tagInteropIFD: true}
+
+var errTruncated = errors.New("truncated TIFF structure")
+
+// typeSizes is the byte size of one value of each field type; unitSizes is
+// the size of the unit that must be byte swapped (rationals are two longs)
+var (
+	typeSizes = map[uint16]int{
+		typeByte: 1, typeASCII: 1, typeShort: 2, typeLong: 4, typeRational: 8,
+		typeSByte: 1, typeUndefined: 1, typeSShort: 2, typeSLong: 4,
+		typeSRational: 8, typeFloat: 4, typeDouble: 8, typeIFD: 4,
+	}
+	unitSizes = map[uint16]int{
+		typeShort: 2, typeLong: 4, typeRational: 4, typeSShort: 2,
+		typeSLong: 4, typeSRational: 4, typeFloat: 4, typeDouble: 8, typeIFD: 4,
+	}
+)
+
+// entry is one IFD field with its value bytes resolved. Sub-IFD pointers
+// carry the parsed child IFD instead of an offset.
+type entry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+	sub   []entry
+}
+
+// parseByteOrder reads the II/MM marker at the start of a TIFF structure
+func parseByteOrder(buf []byte) (binary.ByteOrder, error) {
+	if len(buf) < 8 {
+		return nil, errTruncated
+	}
+	switch string(buf[:2]) {
+	case "II":
+		if binary.LittleEndian.Uint16(buf[2:]) != 42 {
+			return nil, errors.New("not a TIFF structure")
+		}
+		return binary.LittleEndian, nil
+	case "MM":
+		if binary.BigEndian.Uint16(buf[2:]) != 42 {
+			return nil, errors.New("not a TIFF structure")
+		}
+		return binary.BigEndian, nil
+	}
+	return nil, errors.New("not a TIFF structure")
+}
+
+// readIFD parses the IFD at offset, following sub-IFD pointers. It returns
+// the entries and the offset of the next IFD in the chain.
+func readIFD(buf []byte, order binary.ByteOrder, offset uint32, depth int) ([]entry, uint32, error) {
+	if depth > 4 {
+		return nil, 0, errors.New("TIFF sub-IFDs nested too deeply")
+	}
+	if uint64(offset)+2 > uint64(len(buf)) {
+		return nil, 0, errTruncated
+	}
+
+	n := int(order.Uint16(buf[offset:]))
+	pos := int(offset) + 2
+	if pos+12*n+4 > len(buf) {
+		return nil, 0, errTruncated
+	}
+
+	entries := make([]entry, 0, n)
+	for i := 0; i < n; i, pos = i+1, pos+12 {
+		e := entry{
+			tag:   order.Uint16(buf[pos:]),
+			typ:   order.Uint16(buf[pos+2:]),
+			count: order.Uint32(buf[pos+4:]),
+		}
+
+		size, ok := typeSizes[e.typ]
+		if !ok {
+			// Unknown field types cannot be relocated safely
+			continue
+		}
+		length := uint64(size) * uint64(e.count)
+		if length <= 4 {
+			e.data = append([]byte(nil), buf[pos+8:pos+8+int(length)]...)
+		} else {
+			valueOffset := uint64(order.Uint32(buf[pos+8:]))
+			if valueOffset+length > uint64(len(buf)) {
+				return nil, 0, errTruncated
+			}
+			e.data = append([]byte(nil), buf[valueOffset:valueOffset+length]...)
+		}
+
+		if subIFDTags[e.tag] && e.count == 1 && (e.typ == typeLong || e.typ == typeIFD) {
+			sub, _, err := readIFD(buf, order, order.Uint32(e.data), depth+1)
+			if err != nil {
+				// Drop a damaged sub-IFD rather than the whole block
+				continue
+			}
+			e.sub = sub
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, order.Uint32(buf[pos:]), nil
+}
+
+// convertOrder re-encodes the entries from one byte order to another
+func convertOrder(entries []entry, from, to binary.ByteOrder) []entry {
+	if from == to {
+		return entries
+	}
+
+	out := make([]entry, len(entries))
+	for i, e := range entries {
+		data := append([]byte(nil), e.data...)
+		if unit := unitSizes[e.typ]; unit > 1 {
+			for j := 0; j+unit <= len(data); j += unit {
+				for a, b := j, j+unit-1; a < b; a, b = a+1, b-1 {
+					data[a], data[b] = data[b], data[a]
+				}
+			}
+		}
+		e.data = data
+		if e.sub != nil {
+			e.sub = convertOrder(e.sub, from, to)
+		}
+		out[i] = e
+	}
+	return out
+}
+
+// ifdSize is the number of bytes encodeIFD produces for entries, including
+// out-of-line values and nested IFDs
+func ifdSize(entries []entry) int {
+	size := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if e.sub != nil {
+			size += ifdSize(e.sub)
+		} else if len(e.data) > 4 {
+			size += len(e.data) + len(e.data)%2
+		}
+	}
+	return size
+}
+
+// encodeIFD serializes entries as an IFD that will be stored at absolute
+// offset base. Values that do not fit in an entry and nested IFDs are placed
+// directly after the entry table.
+func encodeIFD(order binary.ByteOrder, entries []entry, base int, next uint32) []byte {
+	sorted := append([]entry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].tag < sorted[j].tag })
+
+	tableSize := 2 + 12*len(sorted) + 4
+	dataPos := base + tableSize
+
+	out := make([]byte, tableSize, ifdSize(sorted))
+	order.PutUint16(out, uint16(len(sorted)))
+
+	for i, e := range sorted {
+		field := out[2+12*i:]
+		order.PutUint16(field, e.tag)
+		order.PutUint16(field[2:], e.typ)
+		order.PutUint32(field[4:], e.count)
+
+		switch {
+		case e.sub != nil:
+			offset := dataPos + len(out) - tableSize
+			order.PutUint32(field[8:], uint32(offset))
+			out = append(out, encodeIFD(order, e.sub, offset, 0)...)
+		case len(e.data) <= 4:
+			copy(field[8:12], e.data)
+		default:
+			order.PutUint32(field[8:], uint32(dataPos+len(out)-tableSize))
+			out = append(out, e.data...)
+			if len(e.data)%2 == 1 {
+				out = append(out, 0)
+			}
+		}
+	}
+	order.PutUint32(out[2+12*len(sorted):], next)
+
+	return out
+}
+
+// findEntry returns the entry with tag, if present
+func findEntry(entries []entry, tag uint16) (*entry, bool) {
+	for i := range entries {
+		if entries[i].tag == tag {
+			return &entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// setEntry replaces or adds an entry
+func setEntry(entries []entry, e entry) []entry {
+	if existing, ok := findEntry(entries, e.tag); ok {
+		*existing = e
+		return entries
+	}
+	return append(entries, e)
+}
+
+// removeEntries drops every entry whose tag is in tags
+func removeEntries(entries []entry, tags ...uint16) []entry {
+	out := entries[:0:0]
+	for _, e := range entries {
+		keep := true
+		for _, t := range tags {
+			if e.tag == t {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func shortEntry(order binary.ByteOrder, tag uint16, v uint16) entry {
+	data := make([]byte, 2)
+	order.PutUint16(data, v)
+	return entry{tag: tag, typ: typeShort, count: 1, data: data}
+}
+
+func longEntry(order binary.ByteOrder, tag uint16, v uint32) entry {
+	data := make([]byte, 4)
+	order.PutUint32(data, v)
+	return entry{tag: tag, typ: typeLong, count: 1, data: data}
+}
+
+// uintValue reads the first value of a SHORT or LONG entry
+func (e entry) uintValue(order binary.ByteOrder) (uint32, error) {
+	switch {
+	case e.typ == typeShort && len(e.data) >= 2:
+		return uint32(order.Uint16(e.data)), nil
+	case (e.typ == typeLong || e.typ == typeIFD) && len(e.data) >= 4:
+		return order.Uint32(e.data), nil
+	}
+	return 0, fmt.Errorf("tag %d is not an integer", e.tag)
+}
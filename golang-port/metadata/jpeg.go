@@ -0,0 +1,158 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+const (
+	markerSOI  = 0xD8
+	markerSOS  = 0xDA
+	markerEOI  = 0xD9
+	markerAPP0 = 0xE0
+	markerAPP1 = 0xE1
+	markerAPP2 = 0xE2
+
+	// maxSegment is the largest payload a marker segment can hold
+	maxSegment = 0xFFFF - 2
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+)
+
+// segment is one JPEG marker segment up to the start of scan
+type segment struct {
+	marker  byte
+	payload []byte
+}
+
+// splitJPEG returns the marker segments before the first SOS and the rest of
+// the file starting at that SOS marker
+func splitJPEG(buf []byte) ([]segment, []byte, error) {
+	if len(buf) < 2 || buf[0] != 0xFF || buf[1] != markerSOI {
+		return nil, nil, errors.New("not a JPEG file")
+	}
+
+	var segments []segment
+	pos := 2
+	for {
+		// Skip fill bytes between markers
+		for pos < len(buf) && buf[pos] == 0xFF && pos+1 < len(buf) && buf[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+4 > len(buf) || buf[pos] != 0xFF {
+			return nil, nil, errors.New("corrupt JPEG marker")
+		}
+
+		marker := buf[pos+1]
+		if marker == markerSOS || marker == markerEOI {
+			return segments, buf[pos:], nil
+		}
+
+		length := int(binary.BigEndian.Uint16(buf[pos+2:]))
+		if length < 2 || pos+2+length > len(buf) {
+			return nil, nil, errors.New("truncated JPEG segment")
+		}
+		segments = append(segments, segment{marker: marker, payload: buf[pos+4 : pos+2+length]})
+		pos += 2 + length
+	}
+}
+
+func (m *Metadata) readJPEG(buf []byte) error {
+	segments, _, err := splitJPEG(buf)
+	if err != nil {
+		return err
+	}
+
+	type iccChunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []iccChunk
+
+	for _, s := range segments {
+		switch {
+		case s.marker == markerAPP1 && bytes.HasPrefix(s.payload, exifHeader) && m.exif == nil:
+			// A damaged EXIF block is dropped rather than failing the image
+			if exif, err := parseExif(s.payload[len(exifHeader):]); err == nil {
+				m.exif = exif
+			}
+		case s.marker == markerAPP1 && bytes.HasPrefix(s.payload, xmpHeader) && m.xmp == nil:
+			m.xmp = append([]byte(nil), s.payload[len(xmpHeader):]...)
+		case s.marker == markerAPP2 && bytes.HasPrefix(s.payload, iccHeader) && len(s.payload) > len(iccHeader)+2:
+			chunks = append(chunks, iccChunk{
+				seq:  s.payload[len(iccHeader)],
+				data: s.payload[len(iccHeader)+2:],
+			})
+		}
+	}
+
+	// ICC profiles are split across numbered APP2 segments
+	if len(chunks) > 0 {
+		sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+		for _, c := range chunks {
+			m.icc = append(m.icc, c.data...)
+		}
+	}
+	return nil
+}
+
+func writeJPEG(buf []byte, exif *exifBlock, icc, xmp []byte) ([]byte, error) {
+	segments, scan, err := splitJPEG(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []byte{0xFF, markerSOI}
+	put := func(marker byte, parts ...[]byte) {
+		n := 0
+		for _, p := range parts {
+			n += len(p)
+		}
+		out = append(out, 0xFF, marker, byte((n+2)>>8), byte(n+2))
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+	}
+
+	// JFIF must stay first when the encoder wrote it
+	rest := segments
+	if len(rest) > 0 && rest[0].marker == markerAPP0 {
+		put(markerAPP0, rest[0].payload)
+		rest = rest[1:]
+	}
+
+	if exif != nil {
+		if data := exif.encode(); len(exifHeader)+len(data) <= maxSegment {
+			put(markerAPP1, exifHeader, data)
+		}
+	}
+	if xmp != nil && len(xmpHeader)+len(xmp) <= maxSegment {
+		put(markerAPP1, xmpHeader, xmp)
+	}
+	if icc != nil {
+		const chunkSize = maxSegment - 14
+		count := (len(icc) + chunkSize - 1) / chunkSize
+		if count <= 255 {
+			for i := 0; i < count; i++ {
+				end := min((i+1)*chunkSize, len(icc))
+				put(markerAPP2, iccHeader, []byte{byte(i + 1), byte(count)}, icc[i*chunkSize:end])
+			}
+		}
+	}
+
+	// Keep the encoder's own segments, minus any metadata we replaced
+	for _, s := range rest {
+		if s.marker == markerAPP1 || s.marker == markerAPP2 {
+			continue
+		}
+		put(s.marker, s.payload)
+	}
+
+	return append(out, scan...), nil
+}
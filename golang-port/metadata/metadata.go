@@ -0,0 +1,165 @@
+// Package metadata carries EXIF, ICC profile and XMP metadata from a source
+// image into a re-encoded copy. JPEG, PNG and TIFF files are supported, in any
+// combination of source and destination format.
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// ErrUnsupported means the file format carries no metadata this package handles
+var ErrUnsupported = errors.New("unsupported format for metadata")
+
+// Metadata is the metadata extracted from a single image
+type Metadata struct {
+	exif *exifBlock
+	icc  []byte
+	xmp  []byte
+}
+
+// Read extracts the metadata of the image at path
+func Read(path string) (*Metadata, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{}
+	switch detectFormat(buf) {
+	case formatJPEG:
+		err = m.readJPEG(buf)
+	case formatPNG:
+		err = m.readPNG(buf)
+	case formatTIFF:
+		err = m.readTIFF(buf)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Empty reports whether no metadata was found
+func (m *Metadata) Empty() bool {
+	return m.exif == nil && m.icc == nil && m.xmp == nil
+}
+
+// Orientation returns the EXIF orientation (1-8) of the source image
+func (m *Metadata) Orientation() int {
+	if m.exif == nil {
+		return 1
+	}
+	return m.exif.orientation()
+}
+
+// ICCProfile returns the embedded color profile, if any
+func (m *Metadata) ICCProfile() []byte {
+	return m.icc
+}
+
+// Apply writes the metadata into the image at path, which has just been
+// encoded with the given pixel dimensions. The orientation is reset to 1
+// since the pixels are already upright.
+func (m *Metadata) Apply(path string, width, height int) error {
+	if m.Empty() {
+		return nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var exif *exifBlock
+	if m.exif != nil {
+		exif = &exifBlock{order: m.exif.order, ifd0: m.exif.descriptive()}
+		exif.update(width, height)
+	}
+	xmp := updateXMP(m.xmp, width, height)
+
+	var out []byte
+	switch detectFormat(buf) {
+	case formatJPEG:
+		out, err = writeJPEG(buf, exif, m.icc, xmp)
+	case formatPNG:
+		out, err = writePNG(buf, exif, m.icc, xmp)
+	case formatTIFF:
+		out, err = writeTIFF(buf, exif, m.icc, xmp)
+	default:
+		return ErrUnsupported
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+type format int
+
+const (
+	formatUnknown format = iota
+	formatJPEG
+	formatPNG
+	formatTIFF
+)
+
+func detectFormat(buf []byte) format {
+	switch {
+	case bytes.HasPrefix(buf, []byte{0xFF, 0xD8, 0xFF}):
+		return formatJPEG
+	case bytes.HasPrefix(buf, pngSignature):
+		return formatPNG
+	case bytes.HasPrefix(buf, []byte("II*\x00")), bytes.HasPrefix(buf, []byte("MM\x00*")):
+		return formatTIFF
+	}
+	return formatUnknown
+}
+
+// xmpProperties are the XMP fields rewritten after cropping
+var xmpProperties = []struct {
+	name  string
+	value func(width, height int) int
+}{
+	{"tiff:Orientation", func(w, h int) int { return 1 }},
+	{"tiff:ImageWidth", func(w, h int) int { return w }},
+	{"tiff:ImageLength", func(w, h int) int { return h }},
+	{"exif:PixelXDimension", func(w, h int) int { return w }},
+	{"exif:PixelYDimension", func(w, h int) int { return h }},
+}
+
+// updateXMP rewrites orientation and dimensions in an XMP packet, in both
+// attribute and element form
+func updateXMP(xmp []byte, width, height int) []byte {
+	if xmp == nil {
+		return nil
+	}
+
+	out := xmp
+	for _, p := range xmpProperties {
+		v := []byte(strconv.Itoa(p.value(width, height)))
+		name := regexp.QuoteMeta(p.name)
+
+		attr := regexp.MustCompile(`(\b` + name + `\s*=\s*["'])[^"']*(["'])`)
+		out = attr.ReplaceAll(out, append(append([]byte("${1}"), v...), "${2}"...))
+
+		elem := regexp.MustCompile(`(<` + name + `>)[^<]*(</` + name + `>)`)
+		out = elem.ReplaceAll(out, append(append([]byte("${1}"), v...), "${2}"...))
+	}
+	return out
+}
@@ -0,0 +1,353 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	srcWidth, srcHeight = 64, 48
+	dstWidth, dstHeight = 40, 24
+	tagMake             = 271
+	tagDateTimeOriginal = 36867
+)
+
+// testICC is larger than one JPEG segment, so it is split across several
+var testICC = bytes.Repeat([]byte("icc profile data "), 5000)
+
+func testXMP(orientation, width, height string) []byte {
+	return []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:tiff="http://ns.adobe.com/tiff/1.0/" xmlns:exif="http://ns.adobe.com/exif/1.0/" tiff:Orientation="` + orientation + `">` +
+		`<exif:PixelXDimension>` + width + `</exif:PixelXDimension><exif:PixelYDimension>` + height + `</exif:PixelYDimension>` +
+		`</rdf:Description></rdf:RDF></x:xmpmeta>`)
+}
+
+func asciiEntry(tag uint16, s string) entry {
+	data := append([]byte(s), 0)
+	return entry{tag: tag, typ: typeASCII, count: uint32(len(data)), data: data}
+}
+
+// testExif is the EXIF of a scan turned by the camera, with its original
+// pixel dimensions in the Exif sub-IFD
+func testExif(order binary.ByteOrder) *exifBlock {
+	sub := []entry{
+		longEntry(order, tagPixelXDimension, srcWidth),
+		longEntry(order, tagPixelYDimension, srcHeight),
+		asciiEntry(tagDateTimeOriginal, "2024:05:01 12:00:00"),
+	}
+	return &exifBlock{order: order, ifd0: []entry{
+		asciiEntry(tagMake, "Nikon"),
+		shortEntry(order, tagOrientation, 6),
+		{tag: tagExifIFD, typ: typeLong, count: 1, data: make([]byte, 4), sub: sub},
+	}}
+}
+
+func grayImage(w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	return img
+}
+
+// encodeJPEG encodes a w x h image and inserts the given metadata segments
+// after SOI
+func encodeJPEG(t *testing.T, w, h int, exif *exifBlock, icc, xmp []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, grayImage(w, h), nil); err != nil {
+		t.Fatal(err)
+	}
+	var segs bytes.Buffer
+	put := func(marker byte, parts ...[]byte) {
+		n := 2
+		for _, p := range parts {
+			n += len(p)
+		}
+		segs.Write([]byte{0xFF, marker, byte(n >> 8), byte(n)})
+		for _, p := range parts {
+			segs.Write(p)
+		}
+	}
+	if exif != nil {
+		put(markerAPP1, exifHeader, exif.encode())
+	}
+	if xmp != nil {
+		put(markerAPP1, xmpHeader, xmp)
+	}
+	const chunkSize = 60000
+	count := (len(icc) + chunkSize - 1) / chunkSize
+	for i := 0; i < count; i++ {
+		put(markerAPP2, iccHeader, []byte{byte(i + 1), byte(count)}, icc[i*chunkSize:min((i+1)*chunkSize, len(icc))])
+	}
+	b := buf.Bytes()
+	return append(append(append([]byte(nil), b[:2]...), segs.Bytes()...), b[2:]...)
+}
+
+// encodePNG encodes a w x h image and inserts the given metadata chunks
+// after IHDR
+func encodePNG(t *testing.T, w, h int, exif *exifBlock, icc, xmp []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, grayImage(w, h)); err != nil {
+		t.Fatal(err)
+	}
+	var chunks bytes.Buffer
+	if exif != nil {
+		writeChunk(&chunks, chunk{typ: "eXIf", data: exif.encode()})
+	}
+	if icc != nil {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(icc)
+		zw.Close()
+		writeChunk(&chunks, chunk{typ: "iCCP", data: append([]byte("scanner\x00\x00"), z.Bytes()...)})
+	}
+	if xmp != nil {
+		writeChunk(&chunks, chunk{typ: "iTXt", data: append([]byte(xmpKeyword+"\x00\x00\x00\x00\x00"), xmp...)})
+	}
+	b := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 12 + 13
+	return append(append(append([]byte(nil), b[:ihdrEnd]...), chunks.Bytes()...), b[ihdrEnd:]...)
+}
+
+// encodeTIFF writes a w x h uncompressed gray TIFF with the given metadata
+// in its primary IFD
+func encodeTIFF(t *testing.T, w, h int, exif *exifBlock, icc, xmp []byte) []byte {
+	t.Helper()
+	order := binary.LittleEndian
+	out := []byte("II*\x00\x00\x00\x00\x00")
+	out = append(out, grayImage(w, h).Pix...)
+	if len(out)%2 == 1 {
+		out = append(out, 0)
+	}
+
+	entries := []entry{
+		longEntry(order, tagImageWidth, uint32(w)),
+		longEntry(order, tagImageLength, uint32(h)),
+		shortEntry(order, 258, 8), // BitsPerSample
+		shortEntry(order, 259, 1), // Compression
+		shortEntry(order, 262, 1), // PhotometricInterpretation
+		longEntry(order, 273, 8),  // StripOffsets
+		longEntry(order, 278, uint32(h)),
+		longEntry(order, 279, uint32(w*h)),
+	}
+	if exif != nil {
+		entries = append(entries, convertOrder(exif.ifd0, exif.order, order)...)
+	}
+	if icc != nil {
+		entries = append(entries, entry{tag: tagICCProfile, typ: typeUndefined, count: uint32(len(icc)), data: icc})
+	}
+	if xmp != nil {
+		entries = append(entries, entry{tag: tagXMP, typ: typeByte, count: uint32(len(xmp)), data: xmp})
+	}
+	order.PutUint32(out[4:], uint32(len(out)))
+	return append(out, encodeIFD(order, entries, len(out), 0)...)
+}
+
+type encoder func(t *testing.T, w, h int, exif *exifBlock, icc, xmp []byte) []byte
+
+var encoders = []struct {
+	name   string
+	encode encoder
+}{
+	{"jpeg", encodeJPEG},
+	{"png", encodePNG},
+	{"tiff", encodeTIFF},
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// exifValue returns the integer value of tag in entries
+func exifValue(t *testing.T, b *exifBlock, entries []entry, tag uint16) uint32 {
+	t.Helper()
+	e, ok := findEntry(entries, tag)
+	if !ok {
+		t.Fatalf("tag %d missing", tag)
+	}
+	v, err := e.uintValue(b.order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// checkApplied checks that m holds the test metadata with the orientation
+// reset and the dimensions of the output
+func checkApplied(t *testing.T, m *Metadata) {
+	t.Helper()
+	if m.exif == nil {
+		t.Fatal("EXIF missing")
+	}
+	if got := m.Orientation(); got != 1 {
+		t.Errorf("orientation = %d, want 1", got)
+	}
+	if e, ok := findEntry(m.exif.ifd0, tagMake); !ok || string(e.data) != "Nikon\x00" {
+		t.Errorf("Make = %q, want Nikon", e.data)
+	}
+	exifIFD, ok := findEntry(m.exif.ifd0, tagExifIFD)
+	if !ok || exifIFD.sub == nil {
+		t.Fatal("Exif sub-IFD missing")
+	}
+	if w, h := exifValue(t, m.exif, exifIFD.sub, tagPixelXDimension), exifValue(t, m.exif, exifIFD.sub, tagPixelYDimension); w != dstWidth || h != dstHeight {
+		t.Errorf("EXIF dimensions = %dx%d, want %dx%d", w, h, dstWidth, dstHeight)
+	}
+	if _, ok := findEntry(exifIFD.sub, tagDateTimeOriginal); !ok {
+		t.Error("DateTimeOriginal dropped")
+	}
+	if !bytes.Equal(m.ICCProfile(), testICC) {
+		t.Errorf("ICC profile of %d bytes, want %d", len(m.ICCProfile()), len(testICC))
+	}
+	if want := testXMP("1", "40", "24"); !bytes.Equal(m.xmp, want) {
+		t.Errorf("XMP = %s, want %s", m.xmp, want)
+	}
+}
+
+// checkImage checks that the file at path still holds a dstWidth x
+// dstHeight image
+func checkImage(t *testing.T, path string) {
+	t.Helper()
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w, h int
+	switch detectFormat(buf) {
+	case formatJPEG, formatPNG:
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, h = cfg.Width, cfg.Height
+	case formatTIFF:
+		order, err := parseByteOrder(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, _, err := readIFD(buf, order, order.Uint32(buf[4:]), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &exifBlock{order: order}
+		w, h = int(exifValue(t, b, entries, tagImageWidth)), int(exifValue(t, b, entries, tagImageLength))
+		offset := exifValue(t, b, entries, 273)
+		if !bytes.Equal(buf[offset:int(offset)+w*h], grayImage(w, h).Pix) {
+			t.Error("TIFF pixels changed")
+		}
+	default:
+		t.Fatal("unknown format")
+	}
+	if w != dstWidth || h != dstHeight {
+		t.Errorf("image is %dx%d, want %dx%d", w, h, dstWidth, dstHeight)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	xmp := testXMP("6", "64", "48")
+	for _, src := range encoders {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			srcPath := writeFile(t, "src."+src.name, src.encode(t, srcWidth, srcHeight, testExif(order), testICC, xmp))
+			m, err := Read(srcPath)
+			if err != nil {
+				t.Fatalf("%s: %v", src.name, err)
+			}
+			if m.Orientation() != 6 || !bytes.Equal(m.ICCProfile(), testICC) || !bytes.Equal(m.xmp, xmp) {
+				t.Fatalf("%s: read orientation %d, %d bytes of ICC and XMP %s", src.name, m.Orientation(), len(m.ICCProfile()), m.xmp)
+			}
+
+			for _, dst := range encoders {
+				t.Run(src.name+" "+order.String()+" to "+dst.name, func(t *testing.T) {
+					dstPath := writeFile(t, "dst."+dst.name, dst.encode(t, dstWidth, dstHeight, nil, nil, nil))
+					if err := m.Apply(dstPath, dstWidth, dstHeight); err != nil {
+						t.Fatal(err)
+					}
+					checkImage(t, dstPath)
+					got, err := Read(dstPath)
+					if err != nil {
+						t.Fatal(err)
+					}
+					checkApplied(t, got)
+				})
+			}
+		}
+	}
+}
+
+// TestApplyReplaces checks that metadata the encoder already wrote is
+// replaced rather than duplicated
+func TestApplyReplaces(t *testing.T) {
+	m, err := Read(writeFile(t, "src.jpg", encodeJPEG(t, srcWidth, srcHeight, testExif(binary.BigEndian), testICC, testXMP("6", "64", "48"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := testExif(binary.LittleEndian)
+	stale.ifd0 = setEntry(stale.ifd0, asciiEntry(tagMake, "Encoder"))
+	for _, dst := range encoders {
+		path := writeFile(t, "dst."+dst.name, dst.encode(t, dstWidth, dstHeight, stale, []byte("old profile"), testXMP("3", "1", "1")))
+		if err := m.Apply(path, dstWidth, dstHeight); err != nil {
+			t.Fatalf("%s: %v", dst.name, err)
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A TIFF keeps its old IFD, unreferenced, so only what Read finds counts
+		kept := bytes.Contains(buf, []byte("Encoder")) || bytes.Contains(buf, []byte("old profile")) || strings.Count(string(buf), "xmpmeta xmlns") != 1
+		if dst.name != "tiff" && kept {
+			t.Errorf("%s: stale metadata kept", dst.name)
+		}
+		got, err := Read(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkApplied(t, got)
+	}
+}
+
+func TestApplyEmpty(t *testing.T) {
+	data := encodePNG(t, dstWidth, dstHeight, nil, nil, nil)
+	path := writeFile(t, "plain.png", data)
+	m, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Empty() || m.Orientation() != 1 {
+		t.Fatalf("metadata of a plain PNG: %+v", m)
+	}
+	if err := m.Apply(path, dstWidth, dstHeight); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := os.ReadFile(path); !bytes.Equal(buf, data) {
+		t.Error("empty metadata changed the file")
+	}
+}
+
+func TestReadUnsupported(t *testing.T) {
+	if _, err := Read(writeFile(t, "scan.bmp", []byte("BM not an image"))); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("error = %v, want %v", err, ErrUnsupported)
+	}
+}
+
+func TestUpdateXMPElements(t *testing.T) {
+	in := []byte(`<tiff:Orientation>8</tiff:Orientation><tiff:ImageWidth>6000</tiff:ImageWidth> tiff:ImageLength='4000'`)
+	want := `<tiff:Orientation>1</tiff:Orientation><tiff:ImageWidth>40</tiff:ImageWidth> tiff:ImageLength='24'`
+	if got := updateXMP(in, dstWidth, dstHeight); string(got) != want {
+		t.Errorf("updateXMP = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,171 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+const xmpKeyword = "XML:com.adobe.xmp"
+
+// chunk is one PNG chunk
+type chunk struct {
+	typ  string
+	data []byte
+}
+
+func splitPNG(buf []byte) ([]chunk, error) {
+	if !bytes.HasPrefix(buf, pngSignature) {
+		return nil, errors.New("not a PNG file")
+	}
+
+	var chunks []chunk
+	pos := len(pngSignature)
+	for pos < len(buf) {
+		if pos+12 > len(buf) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		length := int(binary.BigEndian.Uint32(buf[pos:]))
+		if length < 0 || pos+12+length > len(buf) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunks = append(chunks, chunk{
+			typ:  string(buf[pos+4 : pos+8]),
+			data: buf[pos+8 : pos+8+length],
+		})
+		pos += 12 + length
+	}
+	return chunks, nil
+}
+
+func (m *Metadata) readPNG(buf []byte) error {
+	chunks, err := splitPNG(buf)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range chunks {
+		switch c.typ {
+		case "eXIf":
+			if exif, err := parseExif(c.data); err == nil {
+				m.exif = exif
+			}
+		case "iCCP":
+			// Profile name, NUL, compression method, zlib stream
+			i := bytes.IndexByte(c.data, 0)
+			if i < 0 || i+2 > len(c.data) {
+				continue
+			}
+			if icc, err := inflate(c.data[i+2:]); err == nil {
+				m.icc = icc
+			}
+		case "iTXt":
+			if xmp, ok := parseXMPText(c.data); ok {
+				m.xmp = xmp
+			}
+		}
+	}
+	return nil
+}
+
+// parseXMPText extracts the packet from an iTXt chunk with the XMP keyword
+func parseXMPText(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, []byte(xmpKeyword+"\x00")) {
+		return nil, false
+	}
+	rest := data[len(xmpKeyword)+1:]
+	if len(rest) < 2 {
+		return nil, false
+	}
+	compressed := rest[0] == 1
+	rest = rest[2:]
+
+	// Skip the language tag and translated keyword
+	for i := 0; i < 2; i++ {
+		j := bytes.IndexByte(rest, 0)
+		if j < 0 {
+			return nil, false
+		}
+		rest = rest[j+1:]
+	}
+
+	if compressed {
+		text, err := inflate(rest)
+		if err != nil {
+			return nil, false
+		}
+		return text, true
+	}
+	return append([]byte(nil), rest...), true
+}
+
+func writePNG(buf []byte, exif *exifBlock, icc, xmp []byte) ([]byte, error) {
+	chunks, err := splitPNG(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" {
+		return nil, errors.New("PNG file does not start with IHDR")
+	}
+
+	var added []chunk
+	if icc != nil {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		w.Write(icc)
+		w.Close()
+		added = append(added, chunk{typ: "iCCP", data: append([]byte("ICC Profile\x00\x00"), z.Bytes()...)})
+	}
+	if exif != nil {
+		added = append(added, chunk{typ: "eXIf", data: exif.encode()})
+	}
+	if xmp != nil {
+		data := append([]byte(xmpKeyword+"\x00\x00\x00\x00\x00"), xmp...)
+		added = append(added, chunk{typ: "iTXt", data: data})
+	}
+
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	writeChunk(&out, chunks[0])
+	for _, c := range added {
+		writeChunk(&out, c)
+	}
+	for _, c := range chunks[1:] {
+		// Drop anything that would conflict with the copied metadata
+		switch {
+		case c.typ == "eXIf" && exif != nil,
+			(c.typ == "iCCP" || c.typ == "sRGB") && icc != nil,
+			c.typ == "iTXt" && xmp != nil && bytes.HasPrefix(c.data, []byte(xmpKeyword+"\x00")):
+			continue
+		}
+		writeChunk(&out, c)
+	}
+	return out.Bytes(), nil
+}
+
+func writeChunk(w *bytes.Buffer, c chunk) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(c.data)))
+	copy(header[4:], c.typ)
+	w.Write(header[:])
+	w.Write(c.data)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(c.data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,71 @@
+package metadata
+
+import "errors"
+
+func (m *Metadata) readTIFF(buf []byte) error {
+	order, err := parseByteOrder(buf)
+	if err != nil {
+		return err
+	}
+	ifd0, _, err := readIFD(buf, order, order.Uint32(buf[4:]), 0)
+	if err != nil {
+		return err
+	}
+
+	exif := &exifBlock{order: order}
+	for _, e := range ifd0 {
+		switch {
+		case e.tag == tagICCProfile:
+			m.icc = e.data
+		case e.tag == tagXMP:
+			m.xmp = e.data
+		case ifd0Tags[e.tag]:
+			exif.ifd0 = append(exif.ifd0, e)
+		}
+	}
+	if len(exif.ifd0) > 0 {
+		m.exif = exif
+	}
+	return nil
+}
+
+// writeTIFF appends a new primary IFD holding the encoder's own fields plus
+// the copied metadata and points the header at it. The image data and the
+// old IFD are left where they are.
+func writeTIFF(buf []byte, exif *exifBlock, icc, xmp []byte) ([]byte, error) {
+	order, err := parseByteOrder(buf)
+	if err != nil {
+		return nil, err
+	}
+	entries, next, err := readIFD(buf, order, order.Uint32(buf[4:]), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if exif != nil {
+		for tag := range ifd0Tags {
+			entries = removeEntries(entries, tag)
+		}
+		entries = append(entries, convertOrder(exif.ifd0, exif.order, order)...)
+	}
+	if icc != nil {
+		entries = removeEntries(entries, tagICCProfile)
+		entries = append(entries, entry{tag: tagICCProfile, typ: typeUndefined, count: uint32(len(icc)), data: icc})
+	}
+	if xmp != nil {
+		entries = removeEntries(entries, tagXMP)
+		entries = append(entries, entry{tag: tagXMP, typ: typeByte, count: uint32(len(xmp)), data: xmp})
+	}
+
+	out := append([]byte(nil), buf...)
+	if len(out)%2 == 1 {
+		out = append(out, 0)
+	}
+	offset := len(out)
+	if uint64(offset)+uint64(ifdSize(entries)) > 0xFFFFFFFF {
+		return nil, errors.New("TIFF file too large for 32-bit offsets")
+	}
+	out = append(out, encodeIFD(order, entries, offset, next)...)
+	order.PutUint32(out[4:], uint32(offset))
+	return out, nil
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"film-crop-detector/filmcrop"
+)
+
+// Record statuses
+const (
+	statusOK     = "ok"
+	statusReview = "review"
+	statusError  = "error"
+)
+
+// outputFormats lists the values accepted by --output-format
+var outputFormats = []string{"text", "json", "ndjson", "csv"}
+
+// record is one machine readable line of output: a frame of an input file,
+// or the file itself when it failed before any frame was found
+type record struct {
+	File string `json:"file"`
+	// Rel is the path of the file below the input folder it was found in,
+	// with forward slashes, which apply matches records by
+	Rel    string `json:"rel,omitempty"`
+	Frame  int    `json:"frame,omitempty"`
+	Status string `json:"status"`
+	*Detection
+	Output     string  `json:"output,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Detection holds the details of a detected frame, absent for failed files.
+// It is exported only so encoding/json can fill it in when reading records.
+type Detection struct {
+	Width          int         `json:"width"`
+	Height         int         `json:"height"`
+	Polarity       string      `json:"polarity"`
+	Film           string      `json:"film,omitempty"`
+	FilmConfidence float64     `json:"film_confidence,omitempty"`
+	FilmBase       *baseRecord `json:"film_base,omitempty"`
+	Format         string      `json:"format,omitempty"`
+	Detectors      []string    `json:"detectors,omitempty"`
+	RawRect        *rectRecord `json:"raw_rect"`
+	InsetRect      *rectRecord `json:"inset_rect"`
+	Rect           *rectRecord `json:"rect"`
+	Quad           *quadRecord `json:"quad,omitempty"`
+	Crop           cropRecord  `json:"crop"`
+	CropPixels     boxRecord   `json:"crop_px"`
+	Rotation       float64     `json:"rotation"`
+	Retained       float64     `json:"retained_percent"`
+	Confidence     float64     `json:"confidence"`
+	Roll           string      `json:"roll,omitempty"`
+}
+
+// baseRecord is the sampled film base on a 0-1 scale
+type baseRecord struct {
+	R float64 `json:"r"`
+	G float64 `json:"g"`
+	B float64 `json:"b"`
+}
+
+type rectRecord struct {
+	CenterX float64 `json:"center_x"`
+	CenterY float64 `json:"center_y"`
+	Width   float64 `json:"width"`
+	Height  float64 `json:"height"`
+	Angle   float64 `json:"angle"`
+}
+
+// quadRecord is a refined frame outline, clockwise from the top left corner
+type quadRecord [4]pointRecord
+
+type pointRecord struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type cropRecord struct {
+	Left   float64 `json:"left"`
+	Right  float64 `json:"right"`
+	Top    float64 `json:"top"`
+	Bottom float64 `json:"bottom"`
+}
+
+type boxRecord struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// newRecords describes the outcome for path, one record per frame. rel is
+// the path below its input folder, or "" when there is none.
+func newRecords(path, rel string, o outcome) []record {
+	status := statusOK
+	errText := ""
+	switch {
+	case o.err != nil:
+		status = statusError
+		errText = o.err.Error()
+	case o.review:
+		status = statusReview
+	}
+	ms := float64(o.elapsed.Microseconds()) / 1000
+
+	if len(o.results) == 0 {
+		return []record{{File: path, Rel: rel, Status: status, DurationMS: ms, Error: errText}}
+	}
+
+	records := make([]record, len(o.results))
+	for i, res := range o.results {
+		rec := record{
+			File:       path,
+			Rel:        rel,
+			Status:     status,
+			Detection:  newDetection(res),
+			DurationMS: ms,
+			Error:      errText,
+		}
+		if len(o.results) > 1 {
+			rec.Frame = i + 1
+		}
+		if i < len(o.outputs) {
+			rec.Output = o.outputs[i]
+		}
+		records[i] = rec
+	}
+	return records
+}
+
+func newDetection(res filmcrop.Result) *Detection {
+	box := res.Crop.Pixels(res.Width, res.Height)
+	return &Detection{
+		Width:          res.Width,
+		Height:         res.Height,
+		Polarity:       res.Polarity,
+		Film:           string(res.Film.Type),
+		FilmConfidence: res.Film.Confidence,
+		FilmBase:       newBaseRecord(res.Base),
+		Format:         res.Format,
+		Detectors:      res.Detectors,
+		RawRect:        newRectRecord(res.RawRect),
+		InsetRect:      newRectRecord(res.InsetRect),
+		Rect:           newRectRecord(res.Rect),
+		Quad:           newQuadRecord(res.Quad),
+		Crop:           cropRecord{Left: res.Crop.Left, Right: res.Crop.Right, Top: res.Crop.Top, Bottom: res.Crop.Bottom},
+		CropPixels:     boxRecord{X: box.Min.X, Y: box.Min.Y, Width: box.Dx(), Height: box.Dy()},
+		Rotation:       res.Rotation,
+		Retained:       math.Round(res.Crop.Retained()*10000) / 100,
+		Confidence:     res.Confidence.Score,
+		Roll:           string(res.Roll),
+	}
+}
+
+func newRectRecord(rect *filmcrop.RotatedRect) *rectRecord {
+	if rect == nil {
+		return nil
+	}
+	return &rectRecord{
+		CenterX: float64(rect.Center.X),
+		CenterY: float64(rect.Center.Y),
+		Width:   float64(rect.Size.X),
+		Height:  float64(rect.Size.Y),
+		Angle:   rect.Angle,
+	}
+}
+
+func newQuadRecord(quad *filmcrop.Quad) *quadRecord {
+	if quad == nil {
+		return nil
+	}
+	var rec quadRecord
+	for i, c := range quad {
+		rec[i] = pointRecord{X: float64(c.X), Y: float64(c.Y)}
+	}
+	return &rec
+}
+
+func newBaseRecord(base *filmcrop.BaseColor) *baseRecord {
+	if base == nil {
+		return nil
+	}
+	return &baseRecord{R: base.R, G: base.G, B: base.B}
+}
+
+// readRecords reads a file written with --output-format json or ndjson
+func readRecords(path string) ([]record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []record
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(trimmed, &records)
+		return records, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var rec record
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// reporter writes records in one of the machine readable formats
+type reporter interface {
+	write(records []record) error
+	close() error
+}
+
+// newReporter returns the reporter for format writing to w, or nil for the
+// plain text progress output
+func newReporter(format string, w io.Writer) (reporter, error) {
+	switch format {
+	case "text":
+		return nil, nil
+	case "json":
+		return &jsonReporter{w: w}, nil
+	case "ndjson":
+		return &ndjsonReporter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		r := &csvReporter{w: csv.NewWriter(w)}
+		return r, r.w.Write(csvHeader)
+	}
+	return nil, fmt.Errorf("unknown output format %q (known: %s)", format, strings.Join(outputFormats, ", "))
+}
+
+// jsonReporter collects every record into a single array written on close
+type jsonReporter struct {
+	w       io.Writer
+	records []record
+}
+
+func (r *jsonReporter) write(records []record) error {
+	r.records = append(r.records, records...)
+	return nil
+}
+
+func (r *jsonReporter) close() error {
+	if r.records == nil {
+		r.records = []record{}
+	}
+	enc := json.NewEncoder(r.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.records)
+}
+
+// ndjsonReporter streams one JSON object per line
+type ndjsonReporter struct {
+	enc *json.Encoder
+}
+
+func (r *ndjsonReporter) write(records []record) error {
+	for _, rec := range records {
+		if err := r.enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ndjsonReporter) close() error {
+	return nil
+}
+
+// csvReporter streams one row per record with the rectangles flattened
+type csvReporter struct {
+	w *csv.Writer
+}
+
+var csvHeader = []string{
+	"file", "rel", "frame", "status", "width", "height", "polarity", "film", "film_confidence",
+	"film_base_r", "film_base_g", "film_base_b", "format", "detectors",
+	"raw_center_x", "raw_center_y", "raw_width", "raw_height", "raw_angle",
+	"inset_center_x", "inset_center_y", "inset_width", "inset_height", "inset_angle",
+	"rect_center_x", "rect_center_y", "rect_width", "rect_height", "rect_angle",
+	"quad_top_left_x", "quad_top_left_y", "quad_top_right_x", "quad_top_right_y",
+	"quad_bottom_right_x", "quad_bottom_right_y", "quad_bottom_left_x", "quad_bottom_left_y",
+	"crop_left", "crop_right", "crop_top", "crop_bottom",
+	"crop_x", "crop_y", "crop_width", "crop_height",
+	"rotation", "retained_percent", "confidence", "roll", "output", "duration_ms", "error",
+}
+
+func (r *csvReporter) write(records []record) error {
+	for _, rec := range records {
+		if err := r.w.Write(csvRow(rec)); err != nil {
+			return err
+		}
+	}
+	r.w.Flush()
+	return r.w.Error()
+}
+
+func (r *csvReporter) close() error {
+	r.w.Flush()
+	return r.w.Error()
+}
+
+// csvRow flattens rec in the column order of csvHeader. Detection columns
+// are left empty for failed files, and quad columns for frames without a
+// refined quad.
+func csvRow(rec record) []string {
+	frame := ""
+	if rec.Frame > 0 {
+		frame = strconv.Itoa(rec.Frame)
+	}
+	row := []string{rec.File, rec.Rel, frame, rec.Status}
+
+	if f := rec.Detection; f != nil {
+		row = append(row, strconv.Itoa(f.Width), strconv.Itoa(f.Height), f.Polarity, f.Film, formatFloat(f.FilmConfidence))
+		row = append(row, baseColumns(f.FilmBase)...)
+		row = append(row, f.Format, strings.Join(f.Detectors, "+"))
+		for _, rect := range []*rectRecord{f.RawRect, f.InsetRect, f.Rect} {
+			row = append(row, rectColumns(rect)...)
+		}
+		row = append(row, quadColumns(f.Quad)...)
+		row = append(row,
+			formatFloat(f.Crop.Left), formatFloat(f.Crop.Right), formatFloat(f.Crop.Top), formatFloat(f.Crop.Bottom),
+			strconv.Itoa(f.CropPixels.X), strconv.Itoa(f.CropPixels.Y), strconv.Itoa(f.CropPixels.Width), strconv.Itoa(f.CropPixels.Height),
+			formatFloat(f.Rotation), formatFloat(f.Retained), formatFloat(f.Confidence), f.Roll,
+		)
+	} else {
+		row = append(row, make([]string, len(csvHeader)-len(row)-3)...)
+	}
+
+	return append(row, rec.Output, formatFloat(rec.DurationMS), rec.Error)
+}
+
+func baseColumns(base *baseRecord) []string {
+	if base == nil {
+		return make([]string, 3)
+	}
+	return []string{formatFloat(base.R), formatFloat(base.G), formatFloat(base.B)}
+}
+
+func quadColumns(quad *quadRecord) []string {
+	if quad == nil {
+		return make([]string, 8)
+	}
+	var cols []string
+	for _, p := range quad {
+		cols = append(cols, formatFloat(p.X), formatFloat(p.Y))
+	}
+	return cols
+}
+
+func rectColumns(rect *rectRecord) []string {
+	if rect == nil {
+		return make([]string, 5)
+	}
+	return []string{
+		formatFloat(rect.CenterX), formatFloat(rect.CenterY),
+		formatFloat(rect.Width), formatFloat(rect.Height), formatFloat(rect.Angle),
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestCSVRowMatchesHeader(t *testing.T) {
+	quad := &quadRecord{{1, 2}, {10, 2.5}, {10, 8}, {1.5, 8}}
+	records := []record{
+		{File: "in/a.jpg", Rel: "a.jpg", Status: statusError, Error: "no frame"},
+		{File: "in/b.jpg", Rel: "b.jpg", Frame: 1, Status: statusOK, Detection: &Detection{Width: 12, Height: 10}},
+		{File: "in/c.jpg", Rel: "c.jpg", Status: statusOK, Detection: &Detection{Width: 12, Height: 10, Quad: quad}},
+	}
+	for _, rec := range records {
+		row := csvRow(rec)
+		if len(row) != len(csvHeader) {
+			t.Fatalf("%s: %d columns, header has %d", rec.File, len(row), len(csvHeader))
+		}
+		col := make(map[string]string)
+		for i, name := range csvHeader {
+			col[name] = row[i]
+		}
+		if col["rel"] != rec.Rel || col["error"] != rec.Error {
+			t.Errorf("%s: rel %q and error %q", rec.File, col["rel"], col["error"])
+		}
+		want := [2]string{"", ""}
+		if rec.Detection != nil && rec.Quad != nil {
+			want = [2]string{"10", "8"}
+		}
+		if got := [2]string{col["quad_bottom_right_x"], col["quad_bottom_right_y"]}; got != want {
+			t.Errorf("%s: bottom right quad corner %v, want %v", rec.File, got, want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"film-crop-detector/filmcrop"
+)
+
+// review is a file held back because its detection looked unreliable
+type review struct {
+	path  string
+	score float64
+}
+
+// lowestConfidence is the weakest score among results, so a strip is only
+// trusted when every frame is
+func lowestConfidence(results []filmcrop.Result) float64 {
+	lowest := math.Inf(1)
+	for _, res := range results {
+		lowest = math.Min(lowest, res.Confidence.Score)
+	}
+	return lowest
+}
+
+// needsReview reports whether results fall below the --min-confidence cutoff
+func (cfg runConfig) needsReview(results []filmcrop.Result) bool {
+	return cfg.minConfidence > 0 && len(results) > 0 && lowestConfidence(results) < cfg.minConfidence
+}
+
+// queueForReview leaves the input untouched and, with --review-dir, copies it
+// there next to the analysis overlay processImage wrote
+func queueForReview(in input, results []filmcrop.Result, cfg runConfig) (string, []string, error) {
+	score := lowestConfidence(results)
+	line := fmt.Sprintf("confidence %.2f below %.2f, left for review (%s)", score, cfg.minConfidence, filepath.Base(in.path))
+	if cfg.dryRun || cfg.reviewDir == "" {
+		return line, nil, nil
+	}
+
+	dst := reviewPath(in, cfg)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", filmcrop.ErrWriteFailed, err)
+	}
+	if err := copyFile(in.path, dst); err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("confidence %.2f below %.2f, copied for review -> %s", score, cfg.minConfidence, dst), []string{dst}, nil
+}
+
+// reviewPath is where a file held back for review is copied to
+func reviewPath(in input, cfg runConfig) string {
+	return filepath.Join(cfg.reviewDir, in.rel)
+}
+
+// writeReviewList writes one tab-separated "score path" line per held back
+// file, in input order
+func writeReviewList(path string, reviews []review) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", filmcrop.ErrWriteFailed, err)
+	}
+
+	for _, r := range reviews {
+		fmt.Fprintf(file, "%.3f\t%s\n", r.score, r.path)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("%w: %v", filmcrop.ErrWriteFailed, err)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("%w: %v", filmcrop.ErrWriteFailed, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("%w: %s: %v", filmcrop.ErrWriteFailed, dst, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("%w: %s: %v", filmcrop.ErrWriteFailed, dst, err)
+	}
+	return nil
+}
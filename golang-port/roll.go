@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"film-crop-detector/filmcrop"
+
+	"gocv.io/x/gocv"
+)
+
+// rollModes are what --roll does with frames that differ from their roll
+var rollModes = []string{"flag", "replace", "refit"}
+
+// rollConfig carries the roll consensus of a batch into the second pass
+type rollConfig struct {
+	// mode is one of rollModes
+	mode string
+	tol  filmcrop.RollTolerance
+	// consensus is only valid with found set
+	consensus filmcrop.Consensus
+	found     bool
+	// detected holds the results of the first pass by input path
+	detected map[string][]filmcrop.Result
+}
+
+// stored returns the first pass results for path, if it was detected
+func (r *rollConfig) stored(path string) ([]filmcrop.Result, bool) {
+	if r == nil {
+		return nil, false
+	}
+	results, ok := r.detected[path]
+	return results, ok
+}
+
+// adjust marks, replaces or refits the results that differ from the roll.
+// img is the 8-bit image the results were detected on.
+func (r *rollConfig) adjust(detector *filmcrop.Detector, img gocv.Mat, results []filmcrop.Result) []filmcrop.Result {
+	if !r.found {
+		return results
+	}
+	out := make([]filmcrop.Result, len(results))
+	for i, res := range results {
+		if !r.consensus.Outlier(res, r.tol) {
+			out[i] = res
+			continue
+		}
+		switch r.mode {
+		case "replace":
+			out[i] = detector.Replace(res, r.consensus)
+		case "refit":
+			out[i] = detector.Refit(img, res, r.consensus, r.tol)
+		default:
+			res.Roll = filmcrop.RollOutlier
+			out[i] = res
+		}
+	}
+	return out
+}
+
+// detectRoll detects every input on its own and takes the roll consensus
+// over all frames found. It returns the outcome of the inputs that failed,
+// by path, so the second pass can report them without trying again.
+func detectRoll(detector *filmcrop.Detector, inputs []input, cfg runConfig, roll *rollConfig, progress io.Writer) map[string]outcome {
+	// The first pass only finds frames: it writes no crop data, analysis
+	// overlay or review copy, which the second pass produces from the
+	// consensus
+	pass := cfg
+	pass.detectOnly = true
+	pass.invert = false
+	pass.keepCropData = false
+	pass.reviewDir = ""
+
+	roll.detected = make(map[string][]filmcrop.Result)
+	failed := make(map[string]outcome)
+	var all []filmcrop.Result
+	runBatch(inputs, pass, func(in input) outcome { return runFile(detector, in, pass) }, func(idx int, o outcome) {
+		path := inputs[idx].path
+		if o.err != nil {
+			failed[path] = o
+			return
+		}
+		roll.detected[path] = o.results
+		all = append(all, o.results...)
+	})
+
+	roll.consensus, roll.found = filmcrop.RollConsensus(all)
+	if !roll.found {
+		fmt.Fprintf(os.Stderr, "WARNING: Only %d frame(s) detected; roll consensus needs %d, so no frame is adjusted\n", roll.consensus.Frames, filmcrop.MinRollFrames)
+		return failed
+	}
+	c := roll.consensus
+	fmt.Fprintf(progress, "roll consensus of %d frames: %.1f x %.1f px at %.2f°\n", c.Frames, c.Long, c.Short, c.Angle)
+	return failed
+}
+
+// rollFrame is a frame that differed from its roll
+type rollFrame struct {
+	path   string
+	frame  int
+	status filmcrop.RollStatus
+}
+
+// rollFrames lists the results of path that differed from the roll
+func rollFrames(path string, results []filmcrop.Result) []rollFrame {
+	var frames []rollFrame
+	for i, res := range results {
+		if res.Roll == "" {
+			continue
+		}
+		frame := 0
+		if len(results) > 1 {
+			frame = i + 1
+		}
+		frames = append(frames, rollFrame{path: path, frame: frame, status: res.Roll})
+	}
+	return frames
+}
+
+func printRollFrames(frames []rollFrame) {
+	if len(frames) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\n%d frame(s) differ from the roll:\n", len(frames))
+	for _, f := range frames {
+		name := f.path
+		if f.frame > 0 {
+			name = fmt.Sprintf("%s [frame %d]", f.path, f.frame)
+		}
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", name, f.status)
+	}
+}
+
+// parseRollMode checks a --roll value
+func parseRollMode(name string) (string, error) {
+	for _, m := range rollModes {
+		if name == m {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown roll mode %q (known: %s)", name, strings.Join(rollModes, ", "))
+}
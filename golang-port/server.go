@@ -0,0 +1,587 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"film-crop-detector/filmcrop"
+
+	"gocv.io/x/gocv"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// uploadTypes maps accepted upload content types to the extension the image
+// is stored with while it is processed
+var uploadTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/tiff": ".tif",
+	"image/bmp":  ".bmp",
+	"image/webp": ".webp",
+}
+
+// encodeTypes maps the encodings /crop can return to their content type
+var encodeTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".tif":  "image/tiff",
+	".webp": "image/webp",
+}
+
+// uploadTimeout bounds how long reading the body of a request may take
+const uploadTimeout = 5 * time.Minute
+
+// errBadRequest marks client errors that are reported with status 400
+var errBadRequest = errors.New("bad request")
+
+// server answers detection requests over HTTP
+type server struct {
+	opts       filmcrop.Options
+	cfg        runConfig
+	maxUpload  int64
+	allowRoot  string
+	slots      chan struct{}
+	metrics    *serverMetrics
+	reqTimeout time.Duration
+}
+
+// runServe implements the serve command
+func runServe(args []string) int {
+	fs := flag.NewFlagSet(os.Args[0]+" serve", flag.ExitOnError)
+
+	f := addDetectFlags(fs)
+	var listen string
+	var enforceAspect bool
+	var maxUploadMB int64
+	var maxConcurrent int
+	var allowRoot string
+	var interpolation string
+	var timeout time.Duration
+
+	fs.StringVar(&listen, "listen", "127.0.0.1:8080", "Address to listen on")
+	fs.BoolVar(&enforceAspect, "enforce-aspect", false, "Enforce the exact aspect ratio of the format")
+	fs.StringVar(&interpolation, "interpolation", "cubic", "Interpolation used by deskewed and perspective corrected crops: nearest, linear, cubic, area or lanczos")
+	fs.Int64Var(&maxUploadMB, "max-upload", 200, "Largest accepted request body in MB")
+	fs.IntVar(&maxConcurrent, "max-concurrent", runtime.NumCPU(), "Number of images processed at once; further requests wait")
+	fs.DurationVar(&timeout, "timeout", 2*time.Minute, "Longest a request may wait for a free slot")
+	fs.StringVar(&allowRoot, "allow-root", "", "Folder under which requests may name local files by path (empty = uploads only)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s serve [options]\n\nServes POST /detect and POST /crop; the API is described at GET /openapi.json.\nThe detection flags are defaults that a request may override.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	opts, err := f.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+	opts.EnforceAspect = enforceAspect
+	var cfg runConfig
+	cfg.interpolation, err = filmcrop.ParseInterpolation(interpolation)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+	if allowRoot != "" {
+		allowRoot, err = filepath.Abs(allowRoot)
+		if err == nil {
+			allowRoot, err = filepath.EvalSymlinks(allowRoot)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: --allow-root: %v\n", err)
+			return ExitUsage
+		}
+	}
+
+	s := &server{
+		opts:       opts,
+		cfg:        cfg,
+		maxUpload:  maxUploadMB << 20,
+		allowRoot:  allowRoot,
+		slots:      make(chan struct{}, max(1, maxConcurrent)),
+		metrics:    newServerMetrics(),
+		reqTimeout: timeout,
+	}
+
+	srv := &http.Server{
+		Addr:              listen,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Finish running requests on Ctrl-C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	fmt.Fprintf(os.Stderr, "listening on http://%s\n", listen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitAllFailed
+	}
+	return ExitOK
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/detect", s.instrument("detect", s.handleDetect))
+	mux.HandleFunc("/crop", s.instrument("crop", s.handleCrop))
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/metrics", s.metrics.handle)
+	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPISpec)
+	})
+	return mux
+}
+
+// instrument only lets POST through and records the status and duration of
+// every request to an image endpoint
+func (s *server) instrument(endpoint string, h func(w http.ResponseWriter, r *http.Request) int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		status := http.StatusMethodNotAllowed
+		if r.Method == http.MethodPost {
+			status = h(w, r)
+		} else {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, status, fmt.Errorf("%s requires POST", r.URL.Path))
+		}
+		s.metrics.observe(endpoint, status, time.Since(start))
+		if verbose {
+			fmt.Fprintf(os.Stderr, "%s %s %d %s\n", r.Method, r.URL.Path, status, time.Since(start))
+		}
+	}
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":    "ok",
+		"in_flight": len(s.slots),
+		"capacity":  cap(s.slots),
+	})
+}
+
+// handleDetect answers with the result records of the image, as written by
+// --output-format json
+func (s *server) handleDetect(w http.ResponseWriter, r *http.Request) int {
+	job, status, err := s.begin(w, r)
+	if err != nil {
+		return writeError(w, status, err)
+	}
+	defer job.done()
+
+	cfg := s.requestConfig(job)
+	cfg.detectOnly = true
+	o := runFile(job.detector, input{path: job.path, rel: filepath.Base(job.path)}, cfg)
+	if o.err != nil {
+		return writeError(w, errorStatus(o.err), o.err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newRecords(job.name, "", o))
+	return http.StatusOK
+}
+
+// handleCrop answers with the cropped image of one frame
+func (s *server) handleCrop(w http.ResponseWriter, r *http.Request) int {
+	job, status, err := s.begin(w, r)
+	if err != nil {
+		return writeError(w, status, err)
+	}
+	defer job.done()
+
+	q := r.URL.Query()
+	frame := 1
+	if v := q.Get("frame"); v != "" {
+		frame, err = strconv.Atoi(v)
+		if err != nil || frame < 1 {
+			return writeError(w, http.StatusBadRequest, fmt.Errorf("bad frame %q", v))
+		}
+	}
+	ext := strings.ToLower(q.Get("encoding"))
+	if ext == "" {
+		ext = filepath.Ext(job.path)
+	}
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	} else if ext == ".tiff" {
+		ext = ".tif"
+	}
+	contentType, ok := encodeTypes[ext]
+	if !ok {
+		return writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported encoding %q", ext))
+	}
+
+	cfg := s.requestConfig(job)
+	cfg.deskew = q.Get("deskew") == "true"
+	cfg.perspective = q.Get("perspective") == "true"
+	if cfg.deskew && cfg.perspective {
+		return writeError(w, http.StatusBadRequest, errors.New("deskew cannot be combined with perspective"))
+	}
+	cfg.invert = q.Get("invert") == "true"
+	cfg.balance = filmcrop.BalanceLevels
+	if v := q.Get("balance"); v != "" {
+		cfg.balance, err = filmcrop.ParseBalance(v)
+		if err != nil {
+			return writeError(w, http.StatusBadRequest, err)
+		}
+	}
+
+	img, results, err := processImage(job.detector, input{path: job.path, rel: filepath.Base(job.path)}, cfg)
+	defer img.Close()
+	if err != nil {
+		return writeError(w, errorStatus(err), err)
+	}
+	if frame > len(results) {
+		return writeError(w, http.StatusNotFound, fmt.Errorf("frame %d of %d", frame, len(results)))
+	}
+	if cfg.invert {
+		if err := sampleBases(job.detector, img, results[frame-1:frame]); err != nil {
+			return writeError(w, errorStatus(err), err)
+		}
+	}
+	res := results[frame-1]
+
+	cropped, err := cropImage(job.detector, img, res, cfg)
+	if err != nil {
+		return writeError(w, errorStatus(err), err)
+	}
+	defer cropped.Close()
+	if cropped.Empty() {
+		return writeError(w, http.StatusUnprocessableEntity, filmcrop.ErrNoFrameDetected)
+	}
+
+	buf, err := gocv.IMEncode(gocv.FileExt(ext), cropped)
+	if err != nil {
+		return writeError(w, http.StatusInternalServerError, fmt.Errorf("%w: %v", filmcrop.ErrWriteFailed, err))
+	}
+	defer buf.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("X-Crop", fmt.Sprintf("%f,%f,%f,%f", res.Crop.Left, res.Crop.Right, res.Crop.Top, res.Crop.Bottom))
+	h.Set("X-Crop-Rotation", strconv.FormatFloat(res.Rotation, 'f', -1, 64))
+	h.Set("X-Crop-Confidence", strconv.FormatFloat(res.Confidence.Score, 'f', 3, 64))
+	h.Set("X-Frame-Count", strconv.Itoa(len(results)))
+	w.Write(buf.GetBytes())
+	return http.StatusOK
+}
+
+// job is an image request holding a processing slot
+type job struct {
+	// path is the image on disk and name how it is reported
+	path, name string
+	strip      bool
+	detector   *filmcrop.Detector
+	done       func()
+}
+
+// begin validates a request, stores the image on disk and waits for a free
+// slot. On failure it returns the status to answer with.
+func (s *server) begin(w http.ResponseWriter, r *http.Request) (*job, int, error) {
+	q := r.URL.Query()
+	opts := s.opts
+	if name := q.Get("format"); name != "" {
+		format, err := filmcrop.LookupFormat(name)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		opts.Format = format
+	}
+	if name := q.Get("film"); name != "" {
+		film, err := filmcrop.ParseFilmType(name)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		opts.FilmType = film
+	}
+	if name := q.Get("detector"); name != "" {
+		fd, err := filmcrop.LookupFrameDetector(name)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		opts.FrameDetector = fd
+	}
+	if v := q.Get("enforce_aspect"); v != "" {
+		opts.EnforceAspect = v == "true"
+	}
+	if v := q.Get("quad"); v != "" {
+		opts.RefineQuad = v == "true"
+	}
+	if q.Get("perspective") == "true" {
+		opts.RefineQuad = true
+	}
+
+	// Read the whole upload before waiting for a slot, so a slow client
+	// never holds one while detection could run
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	http.NewResponseController(w).SetReadDeadline(time.Now().Add(uploadTimeout))
+	path, name, cleanup, err := s.receive(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("request larger than %d MB", s.maxUpload>>20)
+		case errors.Is(err, errBadRequest):
+			return nil, http.StatusBadRequest, err
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			return nil, http.StatusRequestTimeout, errors.New("upload took too long")
+		default:
+			return nil, errorStatus(err), err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.reqTimeout)
+	defer cancel()
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		cleanup()
+		s.metrics.reject()
+		return nil, http.StatusServiceUnavailable, errors.New("all processing slots busy")
+	}
+
+	j := &job{
+		strip:    q.Get("strip") == "true",
+		detector: filmcrop.NewDetector(opts),
+	}
+	j.path, j.name = path, name
+	j.done = func() {
+		cleanup()
+		<-s.slots
+	}
+	return j, http.StatusOK, nil
+}
+
+// receive stores the image of a request in a temporary file, or resolves the
+// local path named by a JSON body. It returns the path, the name to report
+// and a function removing anything it created.
+func (s *server) receive(r *http.Request) (string, string, func(), error) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch {
+	case mediaType == "application/json":
+		var body struct {
+			Path string `json:"path"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return "", "", nil, fmt.Errorf("%w: %v", errBadRequest, err)
+		}
+		path, err := s.localPath(body.Path)
+		return path, body.Path, func() {}, err
+
+	case mediaType == "multipart/form-data":
+		part, err := imagePart(multipart.NewReader(r.Body, params["boundary"]))
+		if err != nil {
+			return "", "", nil, err
+		}
+		defer part.Close()
+		ext := strings.ToLower(filepath.Ext(part.FileName()))
+		if !isImageFile(part.FileName()) {
+			if ext = uploadTypes[part.Header.Get("Content-Type")]; ext == "" {
+				return "", "", nil, fmt.Errorf("%w: unsupported image type", errBadRequest)
+			}
+		}
+		path, cleanup, err := saveUpload(part, ext)
+		return path, part.FileName(), cleanup, err
+
+	default:
+		ext, ok := uploadTypes[mediaType]
+		if !ok {
+			return "", "", nil, fmt.Errorf("%w: unsupported content type %q", errBadRequest, mediaType)
+		}
+		path, cleanup, err := saveUpload(r.Body, ext)
+		return path, "upload" + ext, cleanup, err
+	}
+}
+
+// imagePart returns the "image" field of a multipart form
+func imagePart(form *multipart.Reader) (*multipart.Part, error) {
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: no image field in form", errBadRequest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "image" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// localPath checks that path names a file below --allow-root
+func (s *server) localPath(path string) (string, error) {
+	if s.allowRoot == "" {
+		return "", fmt.Errorf("%w: local paths are disabled, upload the image instead", errBadRequest)
+	}
+	if path == "" {
+		return "", fmt.Errorf("%w: missing path", errBadRequest)
+	}
+
+	// Relative paths are taken from the root; symlinks must not lead out of it
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.allowRoot, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", filmcrop.ErrNotFound, path)
+	}
+	rel, err := filepath.Rel(s.allowRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s is outside the allowed root", errBadRequest, path)
+	}
+	return resolved, nil
+}
+
+// saveUpload copies an uploaded image into a temporary file
+func saveUpload(r io.Reader, ext string) (string, func(), error) {
+	f, err := os.CreateTemp("", "film-crop-*"+ext)
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// requestConfig returns the processing config of job. Requests may name
+// files in the user's folders and run concurrently on the same file, so
+// nothing is ever written for them: no crop data is kept next to the source
+// and no file or analysis overlay is copied for review.
+func (s *server) requestConfig(job *job) runConfig {
+	cfg := s.cfg
+	cfg.strip = job.strip
+	cfg.keepCropData = false
+	cfg.reviewDir = ""
+	cfg.showWindows = false
+	return cfg
+}
+
+// errorStatus maps processing errors to HTTP statuses
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, filmcrop.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, filmcrop.ErrDecode):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, filmcrop.ErrNoFrameDetected), errors.Is(err, filmcrop.ErrNoFilmBase):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) int {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	return status
+}
+
+// serverMetrics counts requests for the Prometheus text format at /metrics
+type serverMetrics struct {
+	mu       sync.Mutex
+	requests map[string]int64 // by "endpoint status"
+	seconds  map[string]float64
+	counts   map[string]int64
+	rejected int64
+	started  time.Time
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests: make(map[string]int64),
+		seconds:  make(map[string]float64),
+		counts:   make(map[string]int64),
+		started:  time.Now(),
+	}
+}
+
+func (m *serverMetrics) observe(endpoint string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[fmt.Sprintf("%s %d", endpoint, status)]++
+	m.seconds[endpoint] += d.Seconds()
+	m.counts[endpoint]++
+}
+
+func (m *serverMetrics) reject() {
+	m.mu.Lock()
+	m.rejected++
+	m.mu.Unlock()
+}
+
+func (m *serverMetrics) handle(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP filmcrop_requests_total Image requests by endpoint and status.\n# TYPE filmcrop_requests_total counter\n")
+	keys := make([]string, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		endpoint, status, _ := strings.Cut(k, " ")
+		fmt.Fprintf(w, "filmcrop_requests_total{endpoint=%q,status=%q} %d\n", endpoint, status, m.requests[k])
+	}
+
+	fmt.Fprintf(w, "# HELP filmcrop_request_seconds Time spent answering image requests.\n# TYPE filmcrop_request_seconds summary\n")
+	endpoints := make([]string, 0, len(m.counts))
+	for k := range m.counts {
+		endpoints = append(endpoints, k)
+	}
+	sort.Strings(endpoints)
+	for _, e := range endpoints {
+		fmt.Fprintf(w, "filmcrop_request_seconds_sum{endpoint=%q} %f\n", e, m.seconds[e])
+		fmt.Fprintf(w, "filmcrop_request_seconds_count{endpoint=%q} %d\n", e, m.counts[e])
+	}
+
+	fmt.Fprintf(w, "# HELP filmcrop_rejected_total Requests refused because every slot stayed busy.\n# TYPE filmcrop_rejected_total counter\n")
+	fmt.Fprintf(w, "filmcrop_rejected_total %d\n", m.rejected)
+	fmt.Fprintf(w, "# HELP filmcrop_uptime_seconds Time since the server started.\n# TYPE filmcrop_uptime_seconds gauge\n")
+	fmt.Fprintf(w, "filmcrop_uptime_seconds %f\n", time.Since(m.started).Seconds())
+}
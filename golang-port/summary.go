@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runSummary is what the report command computes over the records of a run
+type runSummary struct {
+	Files      int            `json:"files"`
+	Frames     int            `json:"frames"`
+	OK         int            `json:"ok"`
+	Review     int            `json:"review"`
+	Failed     int            `json:"failed"`
+	Retained   stats          `json:"retained_percent"`
+	Confidence stats          `json:"confidence"`
+	DurationMS stats          `json:"duration_ms"`
+	Formats    map[string]int `json:"formats"`
+	Polarity   map[string]int `json:"polarity"`
+	Films      map[string]int `json:"films"`
+	Roll       map[string]int `json:"roll"`
+	Lowest     []lowFrame     `json:"lowest_confidence"`
+	Failures   []failedFile   `json:"failures"`
+}
+
+// stats summarises one value over every frame or file
+type stats struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	Mean   float64 `json:"mean"`
+	Max    float64 `json:"max"`
+	Total  float64 `json:"total"`
+}
+
+type lowFrame struct {
+	File       string  `json:"file"`
+	Frame      int     `json:"frame,omitempty"`
+	Confidence float64 `json:"confidence"`
+}
+
+type failedFile struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// runReport implements the report command, summarising the records written
+// by detect or crop with --output-format json or ndjson
+func runReport(args []string) int {
+	fs := flag.NewFlagSet(os.Args[0]+" report", flag.ExitOnError)
+
+	var format string
+	var lowest int
+	fs.StringVar(&format, "output-format", "text", "Summary format: text or json")
+	fs.IntVar(&lowest, "lowest", 10, "Number of lowest confidence frames to list")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s report [options] results.json|results.ndjson...\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Summarizes the records written by detect or crop with --output-format json or ndjson.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if format != "text" && format != "json" {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown output format %q (known: text, json)\n", format)
+		return ExitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	var records []record
+	var failures []failure
+	for _, path := range fs.Args() {
+		recs, err := readRecords(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Skipping '%s': %v\n", path, err)
+			failures = append(failures, failure{path: path, err: err})
+			continue
+		}
+		records = append(records, recs...)
+	}
+
+	summary := summarize(records, lowest)
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(summary)
+	} else {
+		summary.print(os.Stdout)
+	}
+
+	printSummary(failures)
+	return exitCode(fs.NArg()-len(failures), len(failures))
+}
+
+// summarize counts files by status and gathers frame statistics. A strip
+// contributes one file and one record per frame.
+func summarize(records []record, lowest int) runSummary {
+	s := runSummary{
+		Formats:  make(map[string]int),
+		Polarity: make(map[string]int),
+		Films:    make(map[string]int),
+		Roll:     make(map[string]int),
+		Lowest:   []lowFrame{},
+		Failures: []failedFile{},
+	}
+
+	var retained, confidence, durations []float64
+	seen := make(map[string]bool)
+	for _, rec := range records {
+		if !seen[rec.File] {
+			seen[rec.File] = true
+			s.Files++
+			durations = append(durations, rec.DurationMS)
+			switch rec.Status {
+			case statusOK:
+				s.OK++
+			case statusReview:
+				s.Review++
+			default:
+				s.Failed++
+				s.Failures = append(s.Failures, failedFile{File: rec.File, Error: rec.Error})
+			}
+		}
+
+		d := rec.Detection
+		if d == nil {
+			continue
+		}
+		s.Frames++
+		retained = append(retained, d.Retained)
+		confidence = append(confidence, d.Confidence)
+		s.Polarity[d.Polarity]++
+		if d.Film != "" {
+			s.Films[d.Film]++
+		}
+		if d.Format != "" {
+			s.Formats[d.Format]++
+		}
+		if d.Roll != "" {
+			s.Roll[d.Roll]++
+		}
+		s.Lowest = append(s.Lowest, lowFrame{File: rec.File, Frame: rec.Frame, Confidence: d.Confidence})
+	}
+
+	sort.SliceStable(s.Lowest, func(i, j int) bool { return s.Lowest[i].Confidence < s.Lowest[j].Confidence })
+	if len(s.Lowest) > lowest {
+		s.Lowest = s.Lowest[:max(0, lowest)]
+	}
+
+	s.Retained = newStats(retained)
+	s.Confidence = newStats(confidence)
+	s.DurationMS = newStats(durations)
+	return s
+}
+
+func newStats(values []float64) stats {
+	if len(values) == 0 {
+		return stats{}
+	}
+	st := stats{Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, v := range values {
+		st.Min = math.Min(st.Min, v)
+		st.Max = math.Max(st.Max, v)
+		st.Total += v
+	}
+	st.Mean = st.Total / float64(len(values))
+	st.Median = medianOf(values)
+	return st
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[n/2]
+}
+
+func (s runSummary) print(w io.Writer) {
+	fmt.Fprintf(w, "Files:      %d (%d ok, %d review, %d failed)\n", s.Files, s.OK, s.Review, s.Failed)
+	fmt.Fprintf(w, "Frames:     %d\n", s.Frames)
+	if s.Frames > 0 {
+		fmt.Fprintf(w, "Retained:   min %.1f%%  median %.1f%%  mean %.1f%%  max %.1f%%\n",
+			s.Retained.Min, s.Retained.Median, s.Retained.Mean, s.Retained.Max)
+		fmt.Fprintf(w, "Confidence: min %.2f  median %.2f  mean %.2f  max %.2f\n",
+			s.Confidence.Min, s.Confidence.Median, s.Confidence.Mean, s.Confidence.Max)
+		fmt.Fprintf(w, "Polarity:   %s\n", countList(s.Polarity))
+		if len(s.Films) > 0 {
+			fmt.Fprintf(w, "Film:       %s\n", countList(s.Films))
+		}
+		if len(s.Formats) > 0 {
+			fmt.Fprintf(w, "Formats:    %s\n", countList(s.Formats))
+		}
+		if len(s.Roll) > 0 {
+			fmt.Fprintf(w, "Roll:       %s\n", countList(s.Roll))
+		}
+	}
+	if s.Files > 0 {
+		fmt.Fprintf(w, "Time:       total %.1fs  mean %.0fms  max %.0fms\n",
+			s.DurationMS.Total/1000, s.DurationMS.Mean, s.DurationMS.Max)
+	}
+
+	if len(s.Lowest) > 0 {
+		fmt.Fprintf(w, "\nLowest confidence:\n")
+		for _, f := range s.Lowest {
+			name := f.File
+			if f.Frame > 0 {
+				name = fmt.Sprintf("%s #%d", f.File, f.Frame)
+			}
+			fmt.Fprintf(w, "  %.2f  %s\n", f.Confidence, name)
+		}
+	}
+	if len(s.Failures) > 0 {
+		fmt.Fprintf(w, "\nFailed:\n")
+		for _, f := range s.Failures {
+			fmt.Fprintf(w, "  %s: %s\n", f.File, f.Error)
+		}
+	}
+}
+
+// countList formats counts as "name n, name n" with the largest first
+func countList(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %d", name, counts[name])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,113 @@
+package synth
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Format is a film format a random scan may be drawn in
+type Format struct {
+	Name string
+	// Aspect is the ratio of the long to the short side of a frame
+	Aspect float64
+}
+
+// Variation bounds the random choices of RandomSpec. The Sprockets,
+// LightLeaks and Holders fields are the chance of each, from 0 to 1.
+type Variation struct {
+	// LongEdge is the long side of the canvas in pixels
+	LongEdge int
+	// MinCoverage and MaxCoverage bound the share of each canvas side the
+	// frame spans; the canvas is cut to fit, as a scan is framed by hand
+	MinCoverage, MaxCoverage float64
+
+	Formats    []Format
+	Polarities []Polarity
+	// PortraitChance is the chance of a portrait frame, from 0 to 1
+	PortraitChance float64
+	// MaxAngle is the largest turn of the film either way, in degrees
+	MaxAngle float64
+
+	Sprockets  float64
+	LightLeaks float64
+	Holders    float64
+	MaxDust    int
+	MaxNoise   float64
+}
+
+// DefaultVariation draws small 135 scans of every polarity with every
+// defect now and then
+var DefaultVariation = Variation{
+	LongEdge:       900,
+	MinCoverage:    0.72,
+	MaxCoverage:    0.94,
+	Formats:        []Format{{Name: "135", Aspect: 1.5}},
+	Polarities:     Polarities,
+	PortraitChance: 0.3,
+	MaxAngle:       3,
+	Sprockets:      0.5,
+	LightLeaks:     0.2,
+	Holders:        0.3,
+	MaxDust:        40,
+	MaxNoise:       4,
+}
+
+// maxOffset is the largest shift of the frame center RandomSpec draws, as
+// a fraction of the canvas
+const maxOffset = 0.01
+
+// RandomSpec draws a scan within v. Equal seeds give equal specs.
+func RandomSpec(seed int64, v Variation) Spec {
+	rng := rand.New(rand.NewSource(seed))
+	format := Format{Name: "135", Aspect: 1.5}
+	if len(v.Formats) > 0 {
+		format = v.Formats[rng.Intn(len(v.Formats))]
+	}
+	polarities := v.Polarities
+	if len(polarities) == 0 {
+		polarities = Polarities
+	}
+	coverage := func() float64 {
+		return v.MinCoverage + (v.MaxCoverage-v.MinCoverage)*rng.Float64()
+	}
+
+	s := Spec{
+		Format:     format.Name,
+		Aspect:     format.Aspect,
+		Portrait:   rng.Float64() < v.PortraitChance,
+		Angle:      (2*rng.Float64() - 1) * v.MaxAngle,
+		Polarity:   polarities[rng.Intn(len(polarities))],
+		OrangeMask: rng.Float64() < 0.7,
+		Sprockets:  rng.Float64() < v.Sprockets,
+		LightLeak:  rng.Float64() < v.LightLeaks,
+		Holder:     rng.Float64() < v.Holders,
+		Dust:       rng.Intn(v.MaxDust + 1),
+		Noise:      rng.Float64() * v.MaxNoise,
+		OffsetX:    (2*rng.Float64() - 1) * maxOffset,
+		OffsetY:    (2*rng.Float64() - 1) * maxOffset,
+		Seed:       rng.Int63(),
+	}
+
+	// Cut the canvas around the frame, then shrink the frame until its
+	// turned outline fits with a margin
+	long := float64(v.LongEdge)
+	s.Coverage = coverage()
+	short := math.Round(long / format.Aspect * s.Coverage / coverage())
+	s.Width, s.Height = v.LongEdge, int(short)
+	if s.Portrait {
+		s.Width, s.Height = s.Height, s.Width
+	}
+
+	sin := math.Sin(math.Abs(s.Angle) * math.Pi / 180)
+	cos := math.Cos(s.Angle * math.Pi / 180)
+	room := func(side float64) float64 {
+		return (1-2*maxOffset-0.02)*side - 6
+	}
+	frameLong := s.Coverage * long
+	frameShort := frameLong / format.Aspect
+	scale := math.Min(1, math.Min(
+		room(long)/(frameLong*cos+frameShort*sin),
+		room(short)/(frameLong*sin+frameShort*cos)))
+	s.Coverage *= scale
+	return s
+}
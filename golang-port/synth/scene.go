@@ -0,0 +1,61 @@
+package synth
+
+import (
+	"math"
+	"math/rand"
+)
+
+// scene is the picture exposed on the frame: a soft gradient under a few
+// blurred blobs, like an out of focus photograph
+type scene struct {
+	from, to rgb
+	// gx and gy set the direction of the gradient
+	gx, gy float64
+	blobs  []blob
+}
+
+type blob struct {
+	x, y, radius, strength float64
+	color                  rgb
+}
+
+func newScene(rng *rand.Rand) *scene {
+	s := &scene{
+		from: randomColor(rng),
+		to:   randomColor(rng),
+	}
+	angle := rng.Float64() * 2 * math.Pi
+	s.gx, s.gy = math.Cos(angle), math.Sin(angle)
+
+	for i := 6 + rng.Intn(7); i > 0; i-- {
+		s.blobs = append(s.blobs, blob{
+			x:        rng.Float64(),
+			y:        rng.Float64(),
+			radius:   0.05 + 0.25*rng.Float64(),
+			strength: 0.5 + 0.5*rng.Float64(),
+			color:    randomColor(rng),
+		})
+	}
+	return s
+}
+
+// at returns the color at (u, v) across and down the frame, both from 0 to 1
+func (s *scene) at(u, v float64) rgb {
+	t := 0.5 + 0.5*((u-0.5)*s.gx+(v-0.5)*s.gy)
+	c := mix(s.from, s.to, math.Max(0, math.Min(1, t)))
+	for _, b := range s.blobs {
+		d2 := (u-b.x)*(u-b.x) + (v-b.y)*(v-b.y)
+		c = mix(c, b.color, b.strength*math.Exp(-d2/(b.radius*b.radius)))
+	}
+	return c
+}
+
+// randomColor picks a color clear of pure black and white, as little of a
+// real picture is either
+func randomColor(rng *rand.Rand) rgb {
+	var c rgb
+	for k := range c {
+		c[k] = 0.1 + 0.8*rng.Float64()
+	}
+	return c
+}
@@ -0,0 +1,413 @@
+// Package synth renders synthetic film scans whose frame is known exactly,
+// for tests and for measuring detection with the eval command. A scan is
+// described by a Spec; Generate draws it and returns the ground truth.
+//
+// Scans are modelled as light passing through film on a light table: the
+// backlight, a film strip with its rebate and sprocket holes, the exposed
+// frame, an optional holder, light leaks and dust. Positive scans are
+// negatives inverted after removing the film base, as a scanner does.
+package synth
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+
+	"film-crop-detector/eval"
+)
+
+// Polarity is the kind of film and scan rendered
+type Polarity string
+
+const (
+	// Negative is a raw negative scan: a bright base in the rebate, orange
+	// with OrangeMask, and clipped backlight beyond the film
+	Negative Polarity = "negative"
+	// Slide is a slide on a light table: a nearly opaque rebate and clipped
+	// backlight beyond the film
+	Slide Polarity = "slide"
+	// Positive is a negative scanned and inverted: a black rebate, black
+	// beyond the film and a bright holder
+	Positive Polarity = "positive"
+)
+
+// Polarities lists every Polarity
+var Polarities = []Polarity{Negative, Slide, Positive}
+
+// ParsePolarity maps a polarity name (negative, slide or positive) to its
+// Polarity
+func ParsePolarity(name string) (Polarity, error) {
+	for _, p := range Polarities {
+		if string(p) == name {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown polarity %q (known: negative, slide, positive)", name)
+}
+
+// Detected returns the polarity filmcrop reports for a scan of p
+func (p Polarity) Detected() string {
+	if p == Negative {
+		return "negative"
+	}
+	return "positive"
+}
+
+// Spec describes one synthetic scan
+type Spec struct {
+	// Width and Height are the canvas size in pixels
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// Format names the film format and Aspect is the ratio of its long to
+	// its short side
+	Format string  `json:"format"`
+	Aspect float64 `json:"aspect"`
+	// Portrait stands the frame upright, with the film running vertically
+	Portrait bool `json:"portrait"`
+	// Coverage is the long side of the frame as a fraction of the canvas
+	// side it runs along
+	Coverage float64 `json:"coverage"`
+	// OffsetX and OffsetY move the frame center away from the canvas
+	// center, as fractions of the canvas size
+	OffsetX float64 `json:"offset_x"`
+	OffsetY float64 `json:"offset_y"`
+	// Angle turns the film clockwise on screen, in degrees
+	Angle float64 `json:"angle"`
+
+	Polarity Polarity `json:"polarity"`
+	// OrangeMask gives negatives the orange base of color negative film;
+	// without it the base is the neutral grey of black and white film
+	OrangeMask bool `json:"orange_mask"`
+
+	Sprockets bool `json:"sprockets"`
+	LightLeak bool `json:"light_leak"`
+	Holder    bool `json:"holder"`
+	// Dust is the number of dust specks
+	Dust int `json:"dust"`
+	// Noise is the standard deviation of the sensor noise in 8-bit levels
+	Noise float64 `json:"noise"`
+
+	// Seed drives the frame content and every random detail
+	Seed int64 `json:"seed"`
+}
+
+// Truth is the ground truth of a synthetic scan. It is an eval.Annotation,
+// so a list of them is an annotation file.
+type Truth struct {
+	eval.Annotation
+	// Polarity is what filmcrop should report for the scan
+	Polarity string `json:"polarity"`
+	Spec     Spec   `json:"spec"`
+}
+
+// Film dimensions of 135 film in millimetres, used for every format: the
+// frame height, the film width and its perforations
+const (
+	frameHeightMM = 24
+	filmWidthMM   = 35
+	holeEdgeMM    = 2.01
+	holeAcrossMM  = 2.794
+	holeAlongMM   = 1.981
+	holeRadiusMM  = 0.5
+	holePitchMM   = 4.75
+)
+
+// Light levels of the raw scan, as transmission from 0 to 1
+var (
+	backlight  = rgb{1, 1, 1}
+	orangeBase = rgb{0.92, 0.62, 0.40}
+	greyBase   = rgb{0.80, 0.80, 0.80}
+	slideBase  = rgb{0.025, 0.025, 0.03}
+	holderT    = rgb{0.04, 0.04, 0.04}
+)
+
+// Generate renders the scan described by spec. The frame corners of the
+// truth are in pixel coordinates with pixel centers on whole numbers, as
+// filmcrop reports them.
+func Generate(spec Spec) (*image.RGBA, Truth, error) {
+	l, err := newLayout(spec)
+	if err != nil {
+		return nil, Truth{}, err
+	}
+	rng := rand.New(rand.NewSource(spec.Seed))
+	sc := newScene(rng)
+	leak := newLeak(rng, l, spec.LightLeak)
+	l.phase = rng.Float64() * holePitchMM * l.mm
+	if spec.Holder {
+		l.holderAlong = l.frameAlong + (0.03+0.05*rng.Float64())*2*l.frameAlong
+		l.holderAcross = l.strip - rng.Float64()*1.5*l.mm
+	}
+
+	base := greyBase
+	if spec.OrangeMask {
+		base = orangeBase
+	}
+	if spec.Polarity == Slide {
+		base = slideBase
+	}
+
+	w, h := spec.Width, spec.Height
+	buf := make([]rgb, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a, c, u, v := l.local(float64(x), float64(y))
+
+			t := backlight
+			film := coverage(math.Abs(c) - l.strip)
+			if spec.Sprockets {
+				film *= 1 - l.hole(a, c)
+			}
+			t = mix(t, base, film)
+
+			if f := coverage(math.Max(math.Abs(u)-l.hw, math.Abs(v)-l.hh)); f > 0 {
+				s := sc.at((u+l.hw)/(2*l.hw), (v+l.hh)/(2*l.hh))
+				t = mix(t, frameTransmission(spec.Polarity, base, s), f)
+			}
+			if leak != nil {
+				t = leak.apply(t, film*leak.amount(float64(x), float64(y)), spec.Polarity)
+			}
+			if l.holderAlong > 0 {
+				inside := math.Max(math.Abs(a)-l.holderAlong, math.Abs(c)-l.holderAcross)
+				t = mix(t, holderT, coverage(-inside))
+			}
+			buf[y*w+x] = t
+		}
+	}
+	addDust(buf, w, h, rng, spec.Dust)
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, t := range buf {
+		if spec.Polarity == Positive {
+			for k := range t {
+				t[k] = 1 - t[k]/base[k]
+			}
+		}
+		var px [3]uint8
+		for k := range t {
+			v := t[k]*255 + rng.NormFloat64()*spec.Noise
+			px[k] = uint8(math.Round(math.Max(0, math.Min(255, v))))
+		}
+		img.SetRGBA(i%w, i/w, color.RGBA{px[0], px[1], px[2], 255})
+	}
+
+	var corners [4]eval.Point
+	for i, p := range l.corners() {
+		corners[i] = p
+	}
+	q := eval.NewQuad(corners)
+	return img, Truth{
+		Annotation: eval.Annotation{Corners: q[:]},
+		Polarity:   spec.Polarity.Detected(),
+		Spec:       spec,
+	}, nil
+}
+
+// frameTransmission is the light let through the frame where the scene has
+// the positive color s
+func frameTransmission(p Polarity, base, s rgb) rgb {
+	var t rgb
+	for k := range t {
+		if p == Slide {
+			t[k] = 0.03 + 0.95*s[k]
+		} else {
+			t[k] = base[k] * (1 - 0.85*s[k])
+		}
+	}
+	return t
+}
+
+// layout places the film and frame on the canvas
+type layout struct {
+	cx, cy   float64
+	cos, sin float64
+	// hw and hh are half the frame size across and down before turning
+	hw, hh float64
+	// frameAlong is half the frame size along the run of the film, strip
+	// half the film width
+	frameAlong, strip float64
+	portrait          bool
+	// mm is the size of a millimetre in pixels
+	mm    float64
+	phase float64
+	// holderAlong and holderAcross are half the holder opening, zero when
+	// there is no holder
+	holderAlong, holderAcross float64
+}
+
+func newLayout(spec Spec) (*layout, error) {
+	switch {
+	case spec.Width < 64 || spec.Height < 64:
+		return nil, fmt.Errorf("canvas %dx%d is smaller than 64x64", spec.Width, spec.Height)
+	case spec.Aspect < 1:
+		return nil, fmt.Errorf("aspect %v is below 1", spec.Aspect)
+	case spec.Coverage <= 0 || spec.Coverage > 1:
+		return nil, fmt.Errorf("coverage %v is outside (0, 1]", spec.Coverage)
+	case spec.Noise < 0 || spec.Dust < 0:
+		return nil, errors.New("noise and dust cannot be negative")
+	}
+	if _, err := ParsePolarity(string(spec.Polarity)); err != nil {
+		return nil, err
+	}
+
+	l := &layout{
+		cx:       float64(spec.Width)*(0.5+spec.OffsetX) - 0.5,
+		cy:       float64(spec.Height)*(0.5+spec.OffsetY) - 0.5,
+		cos:      math.Cos(spec.Angle * math.Pi / 180),
+		sin:      math.Sin(spec.Angle * math.Pi / 180),
+		portrait: spec.Portrait,
+	}
+	if spec.Portrait {
+		l.hh = spec.Coverage * float64(spec.Height) / 2
+		l.hw = l.hh / spec.Aspect
+		l.frameAlong = l.hh
+		l.mm = 2 * l.hw / frameHeightMM
+	} else {
+		l.hw = spec.Coverage * float64(spec.Width) / 2
+		l.hh = l.hw / spec.Aspect
+		l.frameAlong = l.hw
+		l.mm = 2 * l.hh / frameHeightMM
+	}
+	l.strip = filmWidthMM / 2 * l.mm
+
+	for _, p := range l.corners() {
+		if p.X < 1 || p.Y < 1 || p.X > float64(spec.Width)-2 || p.Y > float64(spec.Height)-2 {
+			return nil, fmt.Errorf("frame corner (%.1f, %.1f) is off the %dx%d canvas", p.X, p.Y, spec.Width, spec.Height)
+		}
+	}
+	return l, nil
+}
+
+// local returns the position of a pixel along and across the run of the
+// film, and across and down the frame before turning
+func (l *layout) local(x, y float64) (along, across, u, v float64) {
+	dx, dy := x-l.cx, y-l.cy
+	u = dx*l.cos + dy*l.sin
+	v = -dx*l.sin + dy*l.cos
+	if l.portrait {
+		return v, u, u, v
+	}
+	return u, v, u, v
+}
+
+func (l *layout) corners() [4]eval.Point {
+	var out [4]eval.Point
+	for i, p := range [4][2]float64{{-l.hw, -l.hh}, {l.hw, -l.hh}, {l.hw, l.hh}, {-l.hw, l.hh}} {
+		out[i] = eval.Point{
+			X: l.cx + p[0]*l.cos - p[1]*l.sin,
+			Y: l.cy + p[0]*l.sin + p[1]*l.cos,
+		}
+	}
+	return out
+}
+
+// hole returns how much of a pixel lies in a sprocket hole
+func (l *layout) hole(along, across float64) float64 {
+	pitch := holePitchMM * l.mm
+	a := along - l.phase
+	a -= math.Round(a/pitch) * pitch
+	c := math.Abs(across) - (l.strip - (holeEdgeMM+holeAcrossMM/2)*l.mm)
+	return coverage(roundedBox(a, c, holeAlongMM/2*l.mm, holeAcrossMM/2*l.mm, holeRadiusMM*l.mm))
+}
+
+// leak is a glow of stray light that fogged the film near the frame edge
+type leak struct {
+	x, y, radius, strength float64
+}
+
+func newLeak(rng *rand.Rand, l *layout, enabled bool) *leak {
+	// Draw the numbers either way so the rest of the scan does not depend
+	// on whether there is a leak
+	side := rng.Intn(4)
+	pos := rng.Float64()*2 - 1
+	radius := (0.15 + 0.2*rng.Float64()) * 2 * math.Min(l.hw, l.hh)
+	strength := 0.6 + 0.3*rng.Float64()
+	if !enabled {
+		return nil
+	}
+
+	u, v := pos*l.hw, pos*l.hh
+	switch side {
+	case 0:
+		u = -l.hw
+	case 1:
+		u = l.hw
+	case 2:
+		v = -l.hh
+	default:
+		v = l.hh
+	}
+	return &leak{
+		x:        l.cx + u*l.cos - v*l.sin,
+		y:        l.cy + u*l.sin + v*l.cos,
+		radius:   radius,
+		strength: strength,
+	}
+}
+
+func (k *leak) amount(x, y float64) float64 {
+	d2 := (x-k.x)*(x-k.x) + (y-k.y)*(y-k.y)
+	return k.strength * math.Exp(-d2/(k.radius*k.radius))
+}
+
+// apply fogs t by amount: fog darkens a negative and brightens a slide,
+// with the warm cast of a leak through the film back
+func (k *leak) apply(t rgb, amount float64, p Polarity) rgb {
+	if amount <= 0 {
+		return t
+	}
+	if p == Slide {
+		warm := rgb{1, 0.6, 0.25}
+		for i := range t {
+			t[i] += amount * warm[i] * (1 - t[i])
+		}
+		return t
+	}
+	density := rgb{0.55, 0.65, 0.75}
+	for i := range t {
+		t[i] *= 1 - amount*density[i]
+	}
+	return t
+}
+
+// addDust drops small specks that block the light
+func addDust(buf []rgb, w, h int, rng *rand.Rand, n int) {
+	for i := 0; i < n; i++ {
+		cx, cy := rng.Float64()*float64(w), rng.Float64()*float64(h)
+		r := 0.6 + 2.4*rng.Float64()
+		for y := max(0, int(cy-r-1)); y <= min(h-1, int(cy+r+1)); y++ {
+			for x := max(0, int(cx-r-1)); x <= min(w-1, int(cx+r+1)); x++ {
+				c := coverage(math.Hypot(float64(x)-cx, float64(y)-cy) - r)
+				for k := range buf[y*w+x] {
+					buf[y*w+x][k] *= 1 - 0.9*c
+				}
+			}
+		}
+	}
+}
+
+type rgb [3]float64
+
+func mix(a, b rgb, t float64) rgb {
+	for k := range a {
+		a[k] += (b[k] - a[k]) * t
+	}
+	return a
+}
+
+// coverage returns how much of a pixel lies inside a shape from the signed
+// distance of its center to the edge, negative inside
+func coverage(d float64) float64 {
+	return math.Max(0, math.Min(1, 0.5-d))
+}
+
+// roundedBox is the signed distance from (x, y) to a box of half size
+// (hx, hy) with corners rounded by r, centered on the origin
+func roundedBox(x, y, hx, hy, r float64) float64 {
+	qx := math.Abs(x) - hx + r
+	qy := math.Abs(y) - hy + r
+	return math.Hypot(math.Max(qx, 0), math.Max(qy, 0)) + math.Min(math.Max(qx, qy), 0) - r
+}
@@ -0,0 +1,151 @@
+package synth
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+
+	"film-crop-detector/eval"
+)
+
+// clean returns a spec without any defect
+func clean(p Polarity, portrait bool, angle float64) Spec {
+	s := Spec{
+		Width: 450, Height: 300,
+		Format: "135", Aspect: 1.5,
+		Portrait: portrait, Coverage: 0.75, Angle: angle,
+		Polarity: p, OrangeMask: true, Seed: 7,
+	}
+	if portrait {
+		s.Width, s.Height = s.Height, s.Width
+	}
+	return s
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	s := RandomSpec(42, DefaultVariation)
+	a, ta, err := Generate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, tb, err := Generate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Pix, b.Pix) {
+		t.Error("equal specs rendered different pixels")
+	}
+	if ta.Corners[0] != tb.Corners[0] {
+		t.Error("equal specs gave different truth")
+	}
+	if RandomSpec(42, DefaultVariation) != s {
+		t.Error("equal seeds gave different specs")
+	}
+}
+
+// TestTruthMatchesPixels checks that the frame edges of the truth separate
+// the frame content from the rebate
+func TestTruthMatchesPixels(t *testing.T) {
+	rebate := map[Polarity]func(r, g, b uint8) bool{
+		Negative: func(r, g, b uint8) bool { return near8(r, 235) && near8(g, 158) && near8(b, 102) },
+		Slide:    func(r, g, b uint8) bool { return r < 10 && g < 10 && b < 10 },
+		Positive: func(r, g, b uint8) bool { return r < 3 && g < 3 && b < 3 },
+	}
+
+	for _, p := range Polarities {
+		for _, portrait := range []bool{false, true} {
+			for _, angle := range []float64{-2.5, 0, 1} {
+				img, truth, err := Generate(clean(p, portrait, angle))
+				if err != nil {
+					t.Fatal(err)
+				}
+				q, err := truth.Quad()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if truth.Polarity != p.Detected() {
+					t.Errorf("%s: truth polarity %s", p, truth.Polarity)
+				}
+
+				for i := range q {
+					a, b := q[i], q[(i+1)%4]
+					in, out := probe(a, b, 3), probe(a, b, -3)
+					if !rebate[p](pixel(img, out)) {
+						t.Errorf("%s portrait=%v angle=%v: outside edge %d at %v is %v, not rebate", p, portrait, angle, i, out, rgbAt(img, out))
+					}
+					if rebate[p](pixel(img, in)) {
+						t.Errorf("%s portrait=%v angle=%v: inside edge %d at %v is rebate", p, portrait, angle, i, in)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRandomSpecsFit(t *testing.T) {
+	v := DefaultVariation
+	// Copied from filmcrop.Formats, which this package cannot import
+	// without OpenCV
+	v.Formats = []Format{{"135", 36.0 / 24}, {"645", 56 / 41.5}, {"6x6", 1}, {"6x7", 69.5 / 56}, {"xpan", 65.0 / 24}}
+	v.MaxAngle = 5
+	for seed := int64(0); seed < 2000; seed++ {
+		v.LongEdge = []int{240, 900, 4000}[seed%3]
+		s := RandomSpec(seed, v)
+		if _, err := newLayout(s); err != nil {
+			t.Errorf("seed %d: %v (%+v)", seed, err, s)
+		}
+	}
+}
+
+func TestSpecValidation(t *testing.T) {
+	for _, change := range []func(*Spec){
+		func(s *Spec) { s.Width = 10 },
+		func(s *Spec) { s.Aspect = 0.5 },
+		func(s *Spec) { s.Coverage = 0 },
+		func(s *Spec) { s.Coverage = 1 },
+		func(s *Spec) { s.Polarity = "sepia" },
+		func(s *Spec) { s.Noise = -1 },
+		func(s *Spec) { s.OffsetX = 0.3 },
+	} {
+		s := clean(Negative, false, 0)
+		change(&s)
+		if _, _, err := Generate(s); err == nil {
+			t.Errorf("Generate accepted %+v", s)
+		}
+	}
+}
+
+func TestParsePolarity(t *testing.T) {
+	for _, p := range Polarities {
+		if got, err := ParsePolarity(string(p)); err != nil || got != p {
+			t.Errorf("ParsePolarity(%q) = %q, %v", p, got, err)
+		}
+	}
+	if _, err := ParsePolarity("sepia"); err == nil {
+		t.Error("ParsePolarity accepted sepia")
+	}
+}
+
+// probe returns the point d pixels inside the middle of the frame edge from
+// a to b, or outside for negative d. Quad corners run clockwise on screen,
+// so the inside lies to the right of the edge direction.
+func probe(a, b eval.Point, d float64) eval.Point {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	n := math.Hypot(dx, dy)
+	return eval.Point{X: (a.X+b.X)/2 - dy/n*d, Y: (a.Y+b.Y)/2 + dx/n*d}
+}
+
+func pixel(img *image.RGBA, p eval.Point) (r, g, b uint8) {
+	c := img.RGBAAt(int(math.Round(p.X)), int(math.Round(p.Y)))
+	return c.R, c.G, c.B
+}
+
+func rgbAt(img *image.RGBA, p eval.Point) [3]uint8 {
+	r, g, b := pixel(img, p)
+	return [3]uint8{r, g, b}
+}
+
+func near8(v, want uint8) bool {
+	return math.Abs(float64(v)-float64(want)) <= 2
+}
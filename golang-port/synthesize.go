@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"film-crop-detector/filmcrop"
+	"film-crop-detector/synth"
+)
+
+// runSynth implements the synth command: it renders random synthetic scans
+// into a folder, each with its ground truth beside it, and an annotation
+// file listing them all for the eval command. It returns the exit code.
+func runSynth(args []string) int {
+	fs := flag.NewFlagSet(os.Args[0]+" synth", flag.ExitOnError)
+
+	v := synth.DefaultVariation
+	var count int
+	var seed int64
+	var formatNames string
+	var polarityNames string
+
+	fs.IntVar(&count, "count", 100, "Number of scans to render")
+	fs.Int64Var(&seed, "seed", 1, "Seed of the first scan; scan i uses seed+i, so equal seeds give equal scans")
+	fs.IntVar(&v.LongEdge, "size", v.LongEdge, "Long edge of each scan in pixels")
+	fs.Float64Var(&v.MinCoverage, "min-coverage", v.MinCoverage, "Smallest share of each scan side the frame spans (0-1)")
+	fs.Float64Var(&v.MaxCoverage, "max-coverage", v.MaxCoverage, "Largest share of each scan side the frame spans (0-1)")
+	fs.StringVar(&formatNames, "formats", "135", "Comma-separated film formats to draw from: "+strings.Join(filmcrop.FormatNames()[1:], ", "))
+	fs.StringVar(&polarityNames, "polarities", "negative,slide,positive", "Comma-separated polarities to draw from: negative, slide, positive")
+	fs.Float64Var(&v.PortraitChance, "portrait", v.PortraitChance, "Chance of a portrait frame (0-1)")
+	fs.Float64Var(&v.MaxAngle, "max-angle", v.MaxAngle, "Largest turn of the film either way, in degrees")
+	fs.Float64Var(&v.Sprockets, "sprockets", v.Sprockets, "Chance of sprocket holes (0-1)")
+	fs.Float64Var(&v.LightLeaks, "light-leaks", v.LightLeaks, "Chance of a light leak (0-1)")
+	fs.Float64Var(&v.Holders, "holders", v.Holders, "Chance of a film holder around the frame (0-1)")
+	fs.IntVar(&v.MaxDust, "dust", v.MaxDust, "Largest number of dust specks")
+	fs.Float64Var(&v.MaxNoise, "noise", v.MaxNoise, "Largest standard deviation of sensor noise, in 8-bit levels")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s synth [options] output_dir\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Renders synthetic film scans whose frame is known exactly.\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+Each scan is written as synth-NNNN.png with its ground truth, the frame
+corners, expected polarity and the settings it was drawn with, in
+synth-NNNN.json. annotations.json lists every scan, so the folder can be
+scored with:
+  %s eval output_dir/annotations.json
+`, os.Args[0])
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+	if count < 1 {
+		fmt.Fprintf(os.Stderr, "ERROR: --count must be at least 1\n")
+		return ExitUsage
+	}
+	if v.MinCoverage <= 0 || v.MaxCoverage > 1 || v.MinCoverage > v.MaxCoverage {
+		fmt.Fprintf(os.Stderr, "ERROR: --min-coverage and --max-coverage must satisfy 0 < min <= max <= 1\n")
+		return ExitUsage
+	}
+
+	v.Formats = nil
+	for _, name := range strings.Split(formatNames, ",") {
+		format, err := filmcrop.LookupFormat(strings.TrimSpace(name))
+		if err == nil && format.Aspect <= 0 {
+			err = fmt.Errorf("format %q has no fixed aspect ratio", name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return ExitUsage
+		}
+		v.Formats = append(v.Formats, synth.Format{Name: format.Name, Aspect: format.Aspect})
+	}
+	v.Polarities = nil
+	for _, name := range strings.Split(polarityNames, ",") {
+		p, err := synth.ParsePolarity(strings.TrimSpace(name))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return ExitUsage
+		}
+		v.Polarities = append(v.Polarities, p)
+	}
+
+	dir := fs.Arg(0)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitAllFailed
+	}
+
+	truths := make([]synth.Truth, 0, count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("synth-%04d", i+1)
+		spec := synth.RandomSpec(seed+int64(i), v)
+		truth, err := writeSynthetic(dir, name, spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s: %v\n", name, err)
+			return ExitAllFailed
+		}
+		truths = append(truths, truth)
+		fmt.Printf("[%d/%d] %s: %s %s, %.2f degrees\n", i+1, count, truth.File, spec.Format, spec.Polarity, spec.Angle)
+	}
+
+	index := filepath.Join(dir, "annotations.json")
+	if err := writeJSON(index, struct {
+		Images []synth.Truth `json:"images"`
+	}{truths}); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitAllFailed
+	}
+	fmt.Printf("wrote %d scans and %s\n", count, index)
+	return ExitOK
+}
+
+// writeSynthetic renders spec to name.png in dir with its truth in name.json
+func writeSynthetic(dir, name string, spec synth.Spec) (synth.Truth, error) {
+	img, truth, err := synth.Generate(spec)
+	if err != nil {
+		return synth.Truth{}, err
+	}
+	truth.File = name + ".png"
+
+	f, err := os.Create(filepath.Join(dir, truth.File))
+	if err != nil {
+		return synth.Truth{}, err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return synth.Truth{}, err
+	}
+	if err := f.Close(); err != nil {
+		return synth.Truth{}, err
+	}
+	return truth, writeJSON(filepath.Join(dir, name+".json"), truth)
+}
+
+// writeJSON writes v to path as indented JSON
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
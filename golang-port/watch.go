@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"film-crop-detector/filmcrop"
+)
+
+// errInterrupted marks files left unprocessed by a shutdown; they are picked
+// up again on the next start
+var errInterrupted = errors.New("interrupted")
+
+// stamp identifies one version of a file, so a file written again under the
+// same name is processed again
+type stamp struct {
+	size    int64
+	modTime int64
+}
+
+func fileStamp(info os.FileInfo) stamp {
+	return stamp{size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+// pending is a file seen in the watch folder that may still be written to
+type pending struct {
+	stamp stamp
+	since time.Time
+}
+
+// watchState is the list of files already handled by earlier watch runs. It
+// is kept as tab-separated "status size mtime path" lines, appended as each
+// file finishes so an interrupted run loses nothing.
+type watchState struct {
+	path string
+	done map[string]stamp
+}
+
+// runWatch implements the watch command: it polls a capture folder and crops
+// each new image into --output-dir once the file has stopped growing
+func runWatch(args []string) int {
+	fs := flag.NewFlagSet(os.Args[0]+" watch", flag.ExitOnError)
+
+	f := addCropFlags(fs, true)
+	var outputFormat string
+	var statePath string
+	var interval, settle time.Duration
+	var walk walkConfig
+
+	// Output
+	fs.StringVar(&outputFormat, "output-format", "text", "Result output on stdout: "+strings.Join(outputFormats, ", "))
+
+	// Watching
+	fs.DurationVar(&interval, "interval", 2*time.Second, "How often to look for new files")
+	fs.DurationVar(&settle, "settle", 3*time.Second, "How long a file's size must stay unchanged before it is processed")
+	fs.StringVar(&statePath, "state", "", "File recording processed captures across restarts (default <output-dir>/.film-crop-watch)")
+	fs.BoolVar(&walk.recursive, "recursive", false, "Watch subfolders of the watch folder too")
+	fs.Var(&walk.include, "include", "Only process files matching this glob (repeatable)")
+	fs.Var(&walk.exclude, "exclude", "Ignore files and subfolders matching this glob (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s watch --output-dir DIR [options] folder\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Crops each image that appears in folder, such as a tethered capture folder,\nonce its size has stopped changing. Runs until interrupted.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+	dir := fs.Arg(0)
+	if !isDir(dir) {
+		fmt.Fprintf(os.Stderr, "ERROR: '%s' is not a folder\n", dir)
+		return ExitUsage
+	}
+
+	report, err := newReporter(outputFormat, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+	progress := io.Writer(os.Stdout)
+	if report != nil {
+		progress = os.Stderr
+	}
+
+	cfg, opts, err := f.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+	if cfg.outputDir == "" && !cfg.xmp {
+		fmt.Fprintf(os.Stderr, "ERROR: watch needs --output-dir or --xmp\n")
+		return ExitUsage
+	}
+	if interval <= 0 || settle < 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: --interval must be positive and --settle not negative\n")
+		return ExitUsage
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return ExitUsage
+	}
+	for _, out := range []string{cfg.outputDir, cfg.reviewDir} {
+		if out == "" {
+			continue
+		}
+		if out == absDir {
+			fmt.Fprintf(os.Stderr, "ERROR: output folders must differ from the watch folder\n")
+			return ExitUsage
+		}
+		walk.skip = append(walk.skip, out)
+	}
+
+	if statePath == "" {
+		stateDir := cfg.outputDir
+		if stateDir == "" {
+			stateDir = absDir
+		}
+		statePath = filepath.Join(stateDir, ".film-crop-watch")
+	}
+	state, err := loadWatchState(statePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Failed to read --state '%s': %v\n", statePath, err)
+		return ExitUsage
+	}
+
+	detector := filmcrop.NewDetector(opts)
+
+	// The first signal finishes the file in hand; a second one kills the
+	// process as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	fmt.Fprintf(os.Stderr, "watching %s (%d file(s) already processed), Ctrl-C to stop\n", dir, len(state.done))
+
+	seen := make(map[string]*pending)
+	processed, failed := 0, 0
+	for ctx.Err() == nil {
+		ready, err := scanWatchFolder(dir, walk, state, seen, settle)
+		if err != nil {
+			// The folder may be briefly unavailable, such as a card or share
+			// being remounted
+			fmt.Fprintf(os.Stderr, "WARNING: Failed to list '%s': %v\n", dir, err)
+		}
+
+		work := func(in input) outcome {
+			if ctx.Err() != nil {
+				return outcome{err: errInterrupted}
+			}
+			return runFile(detector, in, cfg)
+		}
+		runBatch(ready, cfg, work, func(idx int, o outcome) {
+			in := ready[idx]
+			if errors.Is(o.err, errInterrupted) {
+				return
+			}
+			if report != nil {
+				if err := report.write(newRecords(in.path, filepath.ToSlash(in.rel), o)); err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: Failed to write results: %v\n", err)
+				}
+			}
+
+			status := "ok"
+			if o.err != nil {
+				// A failed capture is retried only once it is written again
+				status = "error"
+				failed++
+				fmt.Fprintf(os.Stderr, "[%d] WARNING: Skipping '%s': %v\n", processed+failed, in.path, o.err)
+			} else {
+				processed++
+				fmt.Fprintf(progress, "[%d] %s\n", processed+failed, o.line)
+			}
+			if err := state.record(in.rel, seen[in.rel].stamp, status); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: Failed to update --state '%s': %v\n", statePath, err)
+			}
+			delete(seen, in.rel)
+		})
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+
+	if report != nil {
+		if err := report.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Failed to write results: %v\n", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nstopped: %d file(s) processed, %d failed\n", processed, failed)
+	return ExitOK
+}
+
+// scanWatchFolder lists the images in dir and returns those not yet handled
+// whose size and modification time have not changed for settle. seen carries
+// the files still being written from one scan to the next.
+func scanWatchFolder(dir string, walk walkConfig, state *watchState, seen map[string]*pending, settle time.Duration) ([]input, error) {
+	found, err := expandDirectory(dir, walk)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	present := make(map[string]bool, len(found))
+	var ready []input
+	for _, in := range found {
+		// Overlays that older versions wrote next to the input are not captures
+		if strings.HasSuffix(in.path, "-analysis.jpg") {
+			continue
+		}
+		info, err := os.Stat(in.path)
+		if err != nil {
+			continue
+		}
+		st := fileStamp(info)
+		if done, ok := state.done[in.rel]; ok && done == st {
+			continue
+		}
+
+		present[in.rel] = true
+		p := seen[in.rel]
+		if p == nil || p.stamp != st {
+			seen[in.rel] = &pending{stamp: st, since: now}
+			if verbose {
+				fmt.Fprintf(os.Stderr, "waiting for %s (%d bytes)\n", in.rel, st.size)
+			}
+			if settle > 0 {
+				continue
+			}
+			p = seen[in.rel]
+		}
+		if now.Sub(p.since) >= settle && st.size > 0 {
+			ready = append(ready, in)
+		}
+	}
+
+	// Forget files deleted or renamed before they settled
+	for rel := range seen {
+		if !present[rel] {
+			delete(seen, rel)
+		}
+	}
+	return ready, nil
+}
+
+// loadWatchState reads the state file at path. A missing file is an empty
+// state.
+func loadWatchState(path string) (*watchState, error) {
+	state := &watchState{path: path, done: make(map[string]stamp)}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected \"status<TAB>size<TAB>mtime<TAB>path\"", n)
+		}
+		size, err1 := strconv.ParseInt(fields[1], 10, 64)
+		modTime, err2 := strconv.ParseInt(fields[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("line %d: bad size or mtime", n)
+		}
+		// Later lines win, as a file written again is recorded again
+		state.done[filepath.FromSlash(fields[3])] = stamp{size: size, modTime: modTime}
+	}
+	return state, scanner.Err()
+}
+
+// record appends a handled file to the state file
+func (s *watchState) record(rel string, st stamp, status string) error {
+	s.done[rel] = st
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s\t%d\t%d\t%s\n", status, st.size, st.modTime, filepath.ToSlash(rel))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}